@@ -0,0 +1,90 @@
+package api_models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 图片的基本信息
+type ImageInfo struct {
+	MIME     string // 如"image/png"
+	Ext      string // 不带"."的扩展名，如"png"
+	Width    int
+	Height   int
+	FileSize int // 单位为字节
+}
+
+var image_exts = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// 根据文件内容识别图片的类型、宽高及大小，支持PNG、JPEG、GIF、WebP；无法识别宽高时Width、Height为0
+func DetectImage(data []byte) (ImageInfo, error) {
+	info := ImageInfo{MIME: http.DetectContentType(data), FileSize: len(data)}
+	ext, ok := image_exts[info.MIME]
+	if !ok {
+		return info, errors.New("unsupported image type: " + info.MIME)
+	}
+	info.Ext = ext
+	if info.MIME == "image/webp" {
+		info.Width, info.Height = webpSize(data)
+	} else if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		info.Width, info.Height = config.Width, config.Height
+	}
+	return info, nil
+}
+
+// parse width and height from the VP8, VP8L or VP8X chunk, return 0, 0 if unknown
+func webpSize(data []byte) (int, int) {
+	if len(data) < 30 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0
+	}
+	chunk := data[12:]
+	switch string(chunk[0:4]) {
+	case "VP8 ": // lossy, frame header starts after 3 bytes frame tag and 3 bytes start code
+		if len(chunk) < 18 || chunk[11] != 0x9d || chunk[12] != 0x01 || chunk[13] != 0x2a {
+			return 0, 0
+		}
+		return int(binary.LittleEndian.Uint16(chunk[14:16]) & 0x3fff), int(binary.LittleEndian.Uint16(chunk[16:18]) & 0x3fff)
+	case "VP8L": // lossless, 14 bits width-1 and 14 bits height-1 after the signature byte
+		if len(chunk) < 13 || chunk[8] != 0x2f {
+			return 0, 0
+		}
+		bits := binary.LittleEndian.Uint32(chunk[9:13])
+		return int(bits&0x3fff) + 1, int((bits>>14)&0x3fff) + 1
+	case "VP8X": // extended, 24 bits canvas width-1 and height-1
+		if len(chunk) < 18 {
+			return 0, 0
+		}
+		width := int(chunk[12]) | int(chunk[13])<<8 | int(chunk[14])<<16
+		height := int(chunk[15]) | int(chunk[16])<<8 | int(chunk[17])<<16
+		return width + 1, height + 1
+	}
+	return 0, 0
+}
+
+// 是否为米游社的图片链接，发送图片消息时非米游社的图片需要先通过 transferImage 转存
+func IsVillaImageURL(raw_url string) bool {
+	u, err := url.Parse(raw_url)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range []string{"miyoushe.com", "mihoyo.com"} {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
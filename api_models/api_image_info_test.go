@@ -0,0 +1,33 @@
+package api_models
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestDetectImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	info, err := DetectImage(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MIME != "image/png" || info.Ext != "png" || info.Width != 3 || info.Height != 2 || info.FileSize != buf.Len() {
+		t.Errorf("DetectImage(png) = %+v", info)
+	}
+
+	// lossless webp of 5x4 pixels, only the header is needed for the size
+	webp := append([]byte("RIFF\x00\x00\x00\x00WEBPVP8L\x00\x00\x00\x00\x2f"), 4, 0xc0, 0, 0, 0, 0, 0, 0, 0, 0)
+	if info, err := DetectImage(webp); err != nil || info.Ext != "webp" || info.Width != 5 || info.Height != 4 {
+		t.Errorf("DetectImage(webp) = %+v, %v", info, err)
+	}
+
+	bmp := append([]byte("BM"), make([]byte, 64)...)
+	if _, err := DetectImage(bmp); err == nil {
+		t.Error("DetectImage(bmp) returned no error")
+	}
+}
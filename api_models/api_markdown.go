@@ -0,0 +1,370 @@
+package api_models
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* Markdown converter
+ *
+ * 支持的Markdown子集：
+ *
+ *	**粗体** 或 __粗体__、*斜体* 或 _斜体_、~~删除线~~、<u>下划线</u>
+ *	[文字](url)          跳转链接
+ *	![描述](url)         附带图片，图片统一放在消息的images中
+ *	@123456、@bot_xxx、@everyone   艾特用户、机器人、全体成员（需位于单词开头）
+ *	#123456              跳转房间（需位于单词开头，"#"后为房间id）
+ *	`代码`               原样保留，内部不解析
+ *	\*                   反斜杠可转义任意ASCII标点符号
+ *
+ * 其余内容（标题、列表等）均作为普通文本保留；无法配对的强调符号会作为普通文本，因此解析不会失败 */
+
+type mdItem struct {
+	token  MarkupToken
+	opener bool   // placeholder of an emphasis opener, dropped when matched or turned into text
+	marker string // the opener's marker, empty when matched
+}
+
+type mdDelimiter struct {
+	style string
+	index int // index of the opener placeholder in items
+}
+
+type markdownParser struct {
+	input []rune
+	items []mdItem
+	open  []mdDelimiter
+	text  strings.Builder
+}
+
+var markdown_markers = []struct {
+	marker string
+	style  string
+}{
+	{"**", StyleBold},
+	{"__", StyleBold},
+	{"~~", StyleStrikethrough},
+	{"*", StyleItalic},
+	{"_", StyleItalic},
+}
+
+// 将Markdown解析为 MarkupToken，可通过 MsgInputModel.AppendMarkup 追加到文本消息中（艾特用户及跳转房间需先填充Text）
+func ParseMarkdown(markdown string) []MarkupToken {
+	p := &markdownParser{input: []rune(markdown)}
+	p.parse()
+	return p.finish()
+}
+
+func (p *markdownParser) styles() []string {
+	if len(p.open) == 0 {
+		return nil
+	}
+	styles := make([]string, len(p.open))
+	for i, delim := range p.open {
+		styles[i] = delim.style
+	}
+	return styles
+}
+
+func (p *markdownParser) flushText() {
+	if p.text.Len() == 0 {
+		return
+	}
+	p.items = append(p.items, mdItem{token: MarkupToken{Type: MarkupText, Text: p.text.String(), Styles: p.styles()}})
+	p.text.Reset()
+}
+
+func (p *markdownParser) push(token MarkupToken) {
+	p.flushText()
+	token.Styles = p.styles()
+	p.items = append(p.items, mdItem{token: token})
+}
+
+func (p *markdownParser) at(i int) rune {
+	if i < 0 || i >= len(p.input) {
+		return ' '
+	}
+	return p.input[i]
+}
+
+func (p *markdownParser) hasPrefix(i int, prefix string) bool {
+	for _, r := range prefix {
+		if i >= len(p.input) || p.input[i] != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isASCIIPunct(r rune) bool {
+	return (r < 128 && unicode.IsPunct(r)) || strings.ContainsRune("$+<=>^`|~", r)
+}
+
+func (p *markdownParser) parse() {
+	for i := 0; i < len(p.input); {
+		i = p.parseAt(i)
+	}
+	p.flushText()
+}
+
+// parse the element at input[i], return the index after it
+func (p *markdownParser) parseAt(i int) int {
+	c := p.input[i]
+	switch {
+	case c == '\\' && i+1 < len(p.input) && isASCIIPunct(p.input[i+1]):
+		p.text.WriteRune(p.input[i+1])
+		return i + 2
+	case c == '`':
+		return p.parseCode(i)
+	case c == '!' && p.at(i+1) == '[':
+		if _, url, end, ok := p.parseLink(i + 1); ok {
+			p.push(MarkupToken{Type: MarkupImage, URL: url})
+			return end
+		}
+	case c == '[':
+		if text, url, end, ok := p.parseLink(i); ok {
+			p.push(MarkupToken{Type: MarkupLink, Text: text, URL: url})
+			return end
+		}
+	case c == '@' && !isWordRune(p.at(i-1)):
+		if end, ok := p.parseMention(i); ok {
+			return end
+		}
+	case c == '#' && !isWordRune(p.at(i-1)):
+		j := i + 1
+		for j < len(p.input) && p.input[j] >= '0' && p.input[j] <= '9' {
+			j++
+		}
+		if room_id, err := strconv.ParseUint(string(p.input[i+1:j]), 10, 64); err == nil && room_id != 0 && !isWordRune(p.at(j)) {
+			p.push(MarkupToken{Type: MarkupRoomLink, RoomID: room_id})
+			return j
+		}
+	case p.hasPrefix(i, "<u>"):
+		if p.openStyle(StyleUnderline, "<u>") {
+			return i + 3
+		}
+	case p.hasPrefix(i, "</u>"):
+		if p.closeStyle(StyleUnderline) {
+			return i + 4
+		}
+	default:
+		// try closing before opening, so that "***" may close "*" and then "**"
+		for _, closing := range []bool{true, false} {
+			for _, m := range markdown_markers {
+				if !p.hasPrefix(i, m.marker) {
+					continue
+				}
+				end := i + len(m.marker)
+				before, after := p.at(i-1), p.at(end)
+				can_open := !unicode.IsSpace(after)
+				can_close := !unicode.IsSpace(before)
+				if m.marker[0] == '_' { // no intraword emphasis with "_", e.g. snake_case
+					can_open = can_open && !isWordRune(before)
+					can_close = can_close && !isWordRune(after)
+				}
+				if closing && can_close && p.closeStyle(m.style) {
+					return end
+				}
+				if !closing && can_open && p.openStyle(m.style, m.marker) {
+					return end
+				}
+			}
+		}
+	}
+	p.text.WriteRune(c)
+	return i + 1
+}
+
+func (p *markdownParser) openStyle(style, marker string) bool {
+	for _, delim := range p.open {
+		if delim.style == style {
+			return false
+		}
+	}
+	p.flushText()
+	p.open = append(p.open, mdDelimiter{style: style, index: len(p.items)})
+	p.items = append(p.items, mdItem{opener: true, marker: marker, token: MarkupToken{Styles: p.styles()[:len(p.open)-1]}})
+	return true
+}
+
+// close the innermost style if it is style, empty spans are not closed
+func (p *markdownParser) closeStyle(style string) bool {
+	if len(p.open) == 0 || p.open[len(p.open)-1].style != style {
+		return false
+	}
+	p.flushText()
+	delim := p.open[len(p.open)-1]
+	if delim.index == len(p.items)-1 {
+		return false
+	}
+	p.open = p.open[:len(p.open)-1]
+	p.items[delim.index].marker = "" // matched
+	return true
+}
+
+func (p *markdownParser) parseCode(i int) int {
+	j := i
+	for j < len(p.input) && p.input[j] == '`' {
+		j++
+	}
+	fence := string(p.input[i:j])
+	if k := strings.Index(string(p.input[j:]), fence); k >= 0 {
+		end := j + len([]rune(string(p.input[j:])[:k])) + len(fence)
+		p.text.WriteString(string(p.input[i:end]))
+		return end
+	}
+	p.text.WriteString(fence)
+	return j
+}
+
+// parse [text](url) starting at input[i] which is "[", escapes are allowed in text
+func (p *markdownParser) parseLink(i int) (string, string, int, bool) {
+	var text strings.Builder
+	j := i + 1
+	for ; j < len(p.input) && p.input[j] != ']'; j++ {
+		if p.input[j] == '\\' && j+1 < len(p.input) && isASCIIPunct(p.input[j+1]) {
+			j++
+		} else if p.input[j] == '[' || p.input[j] == '\n' {
+			return "", "", 0, false
+		}
+		text.WriteRune(p.input[j])
+	}
+	if j >= len(p.input) || p.at(j+1) != '(' {
+		return "", "", 0, false
+	}
+	k := j + 2
+	for k < len(p.input) && p.input[k] != ')' && !unicode.IsSpace(p.input[k]) {
+		k++
+	}
+	if k >= len(p.input) || p.input[k] != ')' || k == j+2 {
+		return "", "", 0, false
+	}
+	url := string(p.input[j+2 : k])
+	if text.Len() == 0 {
+		text.WriteString(url)
+	}
+	return text.String(), url, k + 1, true
+}
+
+func (p *markdownParser) parseMention(i int) (int, bool) {
+	j := i + 1
+	for j < len(p.input) && (isWordRune(p.input[j]) || p.input[j] == '_') {
+		j++
+	}
+	name := string(p.input[i+1 : j])
+	switch {
+	case name == "everyone":
+		p.push(MarkupToken{Type: MarkupMentionAll, Text: MsgMentionAllText})
+	case strings.HasPrefix(name, "bot_") && len(name) > 4:
+		p.push(MarkupToken{Type: MarkupMentionRobot, Text: "@机器人", BotID: name})
+	default:
+		uid, err := strconv.ParseUint(name, 10, 64)
+		if err != nil || uid == 0 {
+			return 0, false
+		}
+		p.push(MarkupToken{Type: MarkupMentionUser, UserID: uid})
+	}
+	return j, true
+}
+
+// turn unmatched openers into text and drop the matched ones
+func (p *markdownParser) finish() []MarkupToken {
+	unmatched := make(map[string]int) // style: index of its unmatched opener, the style is only dropped after it
+	for _, delim := range p.open {
+		unmatched[delim.style] = delim.index
+	}
+	tokens := make([]MarkupToken, 0, len(p.items))
+	for i, item := range p.items {
+		token := item.token
+		if item.opener {
+			if item.marker == "" {
+				continue
+			}
+			token.Type, token.Text = MarkupText, item.marker
+		}
+		if len(unmatched) > 0 && len(token.Styles) > 0 {
+			styles := make([]string, 0, len(token.Styles))
+			for _, style := range token.Styles {
+				if index, ok := unmatched[style]; !ok || i < index {
+					styles = append(styles, style)
+				}
+			}
+			token.Styles = styles
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+/* markdown renderer */
+
+var markdown_escaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `_`, `\_`, `~`, `\~`, `[`, `\[`, `]`, `\]`,
+	"`", "\\`", `<`, `\<`, `@`, `\@`, `#`, `\#`, `!`, `\!`)
+var markdown_url_escaper = strings.NewReplacer(`(`, `%28`, `)`, `%29`, ` `, `%20`)
+
+var markdown_style_markers = map[string][2]string{
+	StyleBold:          {"**", "**"},
+	StyleItalic:        {"*", "*"},
+	StyleStrikethrough: {"~~", "~~"},
+	StyleUnderline:     {"<u>", "</u>"},
+}
+
+type markdownDialect struct{}
+
+func (markdownDialect) openStyle(style string) string {
+	return markdown_style_markers[style][0]
+}
+
+func (markdownDialect) closeStyle(style string) string {
+	return markdown_style_markers[style][1]
+}
+
+func (markdownDialect) entity(entity MarkupEntity, text string) string {
+	switch entity.Type {
+	case MsgEntityMentionUserType:
+		return "@" + entity.UserID
+	case MsgEntityMentionRobotType:
+		return "@" + entity.BotID
+	case MsgEntityMentionAllType:
+		return "@everyone"
+	case MsgEntityVillaRoomLinkType:
+		return "#" + entity.RoomID
+	case MsgEntityLinkType:
+		return "[" + markdown_escaper.Replace(text) + "](" + markdown_url_escaper.Replace(entity.URL) + ")"
+	}
+	return markdown_escaper.Replace(text)
+}
+
+func (markdownDialect) text(text string) string {
+	return markdown_escaper.Replace(text)
+}
+
+func (markdownDialect) image(url string) string {
+	return "![](" + markdown_url_escaper.Replace(url) + ")"
+}
+
+// 将消息的文本、实体及图片渲染为Markdown，是 ParseMarkdown 的逆过程，可用于记录及存档收到的消息；
+// 艾特用户及跳转房间会渲染为id，附带bot_member_access_token的链接会渲染为普通链接
+func RenderMarkdown(text string, entities []MarkupEntity, image_urls []string) string {
+	return renderEntities(text, entities, image_urls, markdownDialect{})
+}
+
+// 将 NewMsg 创建的文本消息渲染为Markdown
+func (msg MsgInputModel) Markdown() (string, error) {
+	text, entities, image_urls, err := msg.textContent()
+	if err != nil {
+		return "", err
+	}
+	return RenderMarkdown(text, entities, image_urls), nil
+}
+
+// 将文本消息渲染为Markdown
+func (msg *TextMessage) Markdown() string {
+	return RenderMarkdown(msg.text.String(), msg.markupEntities(), nil)
+}
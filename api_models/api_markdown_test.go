@@ -0,0 +1,86 @@
+package api_models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMarkdown(t *testing.T) {
+	tokens := ParseMarkdown("**b *bi*** [site](https://a.com) @123 #45 @everyone ![](https://c.com/x.png)")
+	want := []MarkupToken{
+		{Type: MarkupText, Text: "b ", Styles: []string{StyleBold}},
+		{Type: MarkupText, Text: "bi", Styles: []string{StyleBold, StyleItalic}},
+		{Type: MarkupText, Text: " "},
+		{Type: MarkupLink, Text: "site", URL: "https://a.com"},
+		{Type: MarkupText, Text: " "},
+		{Type: MarkupMentionUser, UserID: 123},
+		{Type: MarkupText, Text: " "},
+		{Type: MarkupRoomLink, RoomID: 45},
+		{Type: MarkupText, Text: " "},
+		{Type: MarkupMentionAll, Text: MsgMentionAllText},
+		{Type: MarkupText, Text: " "},
+		{Type: MarkupImage, URL: "https://c.com/x.png"},
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("ParseMarkdown() =\n%+v\nwant\n%+v", tokens, want)
+	}
+}
+
+func TestParseMarkdownLiteral(t *testing.T) {
+	cases := map[string]string{
+		"unmatched **bold":       "unmatched **bold",
+		"a * b * c":              "a * b * c",
+		"`**code**` \\*x\\*":     "`**code**` *x*",
+		"snake_case_name":        "snake_case_name",
+		"mail@123 and issue#45":  "mail@123 and issue#45",
+		"# heading\n- list item": "# heading\n- list item",
+	}
+	for input, want := range cases {
+		var text string
+		for _, token := range ParseMarkdown(input) {
+			if token.Type != MarkupText || len(token.Styles) != 0 {
+				t.Errorf("ParseMarkdown(%q) has non-text token %+v", input, token)
+			}
+			text += token.Text
+		}
+		if text != want {
+			t.Errorf("ParseMarkdown(%q) text = %q, want %q", input, text, want)
+		}
+	}
+}
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	inputs := []string{
+		"plain text",
+		"**bold** and *italic <u>under</u>* ~~strike~~",
+		"**bold @123 mention** @everyone @bot_abc",
+		"room #45 [link \\[x\\]](https://a.com/%28a%29) end",
+		"escaped \\* \\_ \\@ \\# \\\\",
+		"emoji 😀 **😀😀** end![](https://c.com/x.png)",
+	}
+	for _, input := range inputs {
+		tokens := ParseMarkdown(input)
+		for i := range tokens {
+			switch tokens[i].Type {
+			case MarkupMentionUser:
+				tokens[i].Text = "@user"
+			case MarkupRoomLink:
+				tokens[i].Text = "#room"
+			}
+		}
+		msg, err := NewMsg(MsgTypeText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := msg.AppendMarkup(1, tokens); err != nil {
+			t.Fatalf("AppendMarkup(%q): %v", input, err)
+		}
+		output, err := msg.Markdown()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != input {
+			t.Errorf("round trip of %q = %q", input, output)
+		}
+	}
+}
@@ -0,0 +1,590 @@
+package api_models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+/* inline markup used by SendMessage、Reply、MessageParser
+ *
+ * 语法（多个msg参数会按顺序拼接后解析）：
+ *
+ *	markup   = { text | escape | tag }
+ *	escape   = "\" 任意字符                      ; 输出该字符本身，用于 \< \> \\ 及链接中的 \|
+ *	tag      = "<" body ">"
+ *	body     = "@" uid | "@everyone" | "@" bot_id   ; 艾特用户、全体成员、机器人（bot_id以"bot_"开头）
+ *	         | "#" room_id                          ; 跳转房间
+ *	         | "$" url [ "|" text ]                 ; 跳转链接，text为显示的文字，默认为url
+ *	         | "$$" url [ "|" text ]                ; 跳转链接，点击时附带bot_member_access_token
+ *	         | "!" url                              ; 附带图片，图片不占用文本位置，统一放在消息的images中
+ *	         | style | "/" style                    ; 样式的开始与结束
+ *	style    = "b" | "i" | "s" | "u"                ; 粗体、斜体、删除线、下划线
+ *
+ * 嵌套规则：样式可以互相嵌套，并可包含文本及艾特、房间、链接，但必须按开始的相反顺序结束，
+ * 同一种样式不能嵌套在自身之内，也不能为空；标签内部不能再出现未转义的"<"，链接文字中不能包含其他标签。
+ * 出错时返回 *MarkupError，其中的位置为字符（rune）在对应msg参数中的下标 */
+
+// 标记语法的解析错误
+type MarkupError struct {
+	Arg int // 出错的msg参数下标
+	Pos int // 出错的字符在该参数中的下标（以rune计）
+	Msg string
+}
+
+func (e *MarkupError) Error() string {
+	return fmt.Sprintf("invalid format, %s in position %d on msg arg %d", e.Msg, e.Pos, e.Arg)
+}
+
+type MarkupTokenType int
+
+const (
+	MarkupText MarkupTokenType = iota
+	MarkupMentionUser
+	MarkupMentionRobot
+	MarkupMentionAll
+	MarkupRoomLink
+	MarkupLink
+	MarkupImage
+)
+
+// ParseMarkup 的解析结果，Text为显示的文字，艾特用户及跳转房间的Text需由调用者根据昵称、房间名称填充
+type MarkupToken struct {
+	Type                   MarkupTokenType
+	Text                   string
+	UserID                 uint64
+	BotID                  string
+	RoomID                 uint64
+	URL                    string
+	RequiresBotAccessToken bool
+	Styles                 []string // 生效的样式，由外到内
+	Arg                    int      // token在输入中的位置，用于报告错误
+	Pos                    int
+}
+
+var markup_styles = map[string]string{
+	"b": StyleBold,
+	"i": StyleItalic,
+	"s": StyleStrikethrough,
+	"u": StyleUnderline,
+}
+
+// canonical order when rendering
+var markup_style_order = []string{StyleBold, StyleItalic, StyleStrikethrough, StyleUnderline}
+
+func markupStyleTag(style string) string {
+	for tag, s := range markup_styles {
+		if s == style {
+			return tag
+		}
+	}
+	return ""
+}
+
+type markupRune struct {
+	r   rune
+	arg int
+	pos int
+}
+
+type markupStyleFrame struct {
+	tag    string
+	style  string
+	arg    int
+	pos    int
+	tokens int // number of tokens when the span was opened
+}
+
+type markupParser struct {
+	input    []markupRune
+	tokens   []MarkupToken
+	styles   []markupStyleFrame
+	text     strings.Builder
+	text_arg int
+	text_pos int
+}
+
+func markupErr(at markupRune, format string, args ...interface{}) *MarkupError {
+	return &MarkupError{Arg: at.arg, Pos: at.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// 解析标记语法，语法见文件开头的说明
+func ParseMarkup(msg_parts ...string) ([]MarkupToken, error) {
+	p := &markupParser{}
+	for i, part := range msg_parts {
+		pos := 0
+		for _, r := range part {
+			p.input = append(p.input, markupRune{r: r, arg: i, pos: pos})
+			pos++
+		}
+	}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.tokens, nil
+}
+
+func (p *markupParser) currentStyles() []string {
+	if len(p.styles) == 0 {
+		return nil
+	}
+	styles := make([]string, len(p.styles))
+	for i, frame := range p.styles {
+		styles[i] = frame.style
+	}
+	return styles
+}
+
+func (p *markupParser) writeText(at markupRune) {
+	if p.text.Len() == 0 {
+		p.text_arg, p.text_pos = at.arg, at.pos
+	}
+	p.text.WriteRune(at.r)
+}
+
+func (p *markupParser) flushText() {
+	if p.text.Len() == 0 {
+		return
+	}
+	p.tokens = append(p.tokens, MarkupToken{Type: MarkupText, Text: p.text.String(), Styles: p.currentStyles(),
+		Arg: p.text_arg, Pos: p.text_pos})
+	p.text.Reset()
+}
+
+func (p *markupParser) parse() error {
+	for i := 0; i < len(p.input); i++ {
+		c := p.input[i]
+		switch c.r {
+		case '\\':
+			if i+1 >= len(p.input) {
+				return markupErr(c, `unexpected EOF after "\"`)
+			}
+			i++
+			p.writeText(p.input[i])
+		case '>':
+			return markupErr(c, `unexpected ">"`)
+		case '<':
+			end, err := p.parseTag(i)
+			if err != nil {
+				return err
+			}
+			i = end
+		default:
+			p.writeText(c)
+		}
+	}
+	p.flushText()
+	if len(p.styles) > 0 {
+		frame := p.styles[len(p.styles)-1]
+		return &MarkupError{Arg: frame.arg, Pos: frame.pos, Msg: fmt.Sprintf("unclosed <%s>", frame.tag)}
+	}
+	return nil
+}
+
+// parse the tag starting at input[start] which is "<", return the index of the closing ">"
+func (p *markupParser) parseTag(start int) (int, error) {
+	open := p.input[start]
+	var body []rune
+	sep := -1 // index of the first unescaped "|" in body
+	end := -1
+	for i := start + 1; i < len(p.input) && end < 0; i++ {
+		c := p.input[i]
+		switch c.r {
+		case '\\':
+			if i+1 >= len(p.input) {
+				return 0, markupErr(c, `unexpected EOF after "\"`)
+			}
+			i++
+			body = append(body, p.input[i].r)
+		case '<':
+			return 0, markupErr(c, `unexpected "<"`)
+		case '>':
+			end = i
+		case '|':
+			if sep < 0 {
+				sep = len(body)
+			}
+			body = append(body, c.r)
+		default:
+			body = append(body, c.r)
+		}
+	}
+	if end < 0 {
+		return 0, markupErr(open, `unexpected EOF (start with "<" but not end with ">")`)
+	}
+	content := string(body)
+	if content == "" {
+		return 0, markupErr(open, "empty tag")
+	}
+
+	if style, ok := markup_styles[content]; ok {
+		for _, frame := range p.styles {
+			if frame.style == style {
+				return 0, markupErr(open, "<%s> can not be nested in itself", content)
+			}
+		}
+		p.flushText()
+		p.styles = append(p.styles, markupStyleFrame{tag: content, style: style, arg: open.arg, pos: open.pos, tokens: len(p.tokens)})
+		return end, nil
+	}
+	if strings.HasPrefix(content, "/") {
+		tag := content[1:]
+		if _, ok := markup_styles[tag]; !ok {
+			return 0, markupErr(open, "unknown closing tag <%s>", content)
+		}
+		if len(p.styles) == 0 {
+			return 0, markupErr(open, "unexpected <%s>", content)
+		}
+		frame := p.styles[len(p.styles)-1]
+		if frame.tag != tag {
+			return 0, markupErr(open, "unexpected <%s>, expected </%s>", content, frame.tag)
+		}
+		p.flushText()
+		if len(p.tokens) == frame.tokens {
+			return 0, markupErr(open, "empty <%s>", tag)
+		}
+		p.styles = p.styles[:len(p.styles)-1]
+		return end, nil
+	}
+
+	p.flushText()
+	token := MarkupToken{Styles: p.currentStyles(), Arg: open.arg, Pos: open.pos}
+	arg := string(body[1:])
+	switch body[0] {
+	case '@':
+		if arg == "everyone" {
+			token.Type, token.Text = MarkupMentionAll, MsgMentionAllText
+		} else if strings.HasPrefix(arg, "bot_") {
+			token.Type, token.Text, token.BotID = MarkupMentionRobot, "@机器人", arg
+		} else {
+			uid, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil || uid == 0 {
+				return 0, markupErr(open, "invalid user id %q", arg)
+			}
+			token.Type, token.UserID = MarkupMentionUser, uid
+		}
+	case '#':
+		room_id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil || room_id == 0 {
+			return 0, markupErr(open, "invalid room id %q", arg)
+		}
+		token.Type, token.RoomID = MarkupRoomLink, room_id
+	case '$':
+		token.Type = MarkupLink
+		url_start := 1
+		if len(body) > 1 && body[1] == '$' {
+			token.RequiresBotAccessToken = true
+			url_start = 2
+		}
+		url_end := len(body)
+		if sep >= 0 {
+			url_end = sep
+			token.Text = string(body[sep+1:])
+			if token.Text == "" {
+				return 0, markupErr(open, "empty link text")
+			}
+		}
+		if url_start >= url_end {
+			return 0, markupErr(open, "empty link url")
+		}
+		token.URL = string(body[url_start:url_end])
+		if token.Text == "" {
+			token.Text = token.URL
+		}
+	case '!':
+		if arg == "" {
+			return 0, markupErr(open, "empty image url")
+		}
+		token.Type, token.URL, token.Styles = MarkupImage, arg, nil
+	default:
+		return 0, markupErr(open, "unknown tag <%s>", content)
+	}
+	p.tokens = append(p.tokens, token)
+	return end, nil
+}
+
+// 将 ParseMarkup 的结果追加到文本消息中，艾特用户及跳转房间的token需已填充Text
+func (msg MsgInputModel) AppendMarkup(villa_id uint64, tokens []MarkupToken) error {
+	last_style := make(map[string]MsgInputModel) // the last style entity of each style, to merge adjacent spans
+	for _, token := range tokens {
+		var arg interface{}
+		switch token.Type {
+		case MarkupText:
+			arg = token.Text
+		case MarkupMentionUser:
+			arg = MsgEntityMentionUser{Text: token.Text, UserID: token.UserID}
+		case MarkupMentionRobot:
+			arg = MsgEntityMentionRobot{Text: token.Text, BotID: token.BotID}
+		case MarkupMentionAll:
+			arg = MsgEntityMentionAll{Text: token.Text}
+		case MarkupRoomLink:
+			arg = MsgEntityVillaRoomLink{Text: token.Text, VillaID: villa_id, RoomID: token.RoomID}
+		case MarkupLink:
+			arg = MsgEntityLink{Text: token.Text, URL: token.URL, RequiresBotAccessToken: token.RequiresBotAccessToken}
+		case MarkupImage:
+			msg.AddTextImage(token.URL)
+			continue
+		default:
+			return &MarkupError{Arg: token.Arg, Pos: token.Pos, Msg: fmt.Sprintf("unknown token type %d", token.Type)}
+		}
+		if token.Text == "" {
+			return &MarkupError{Arg: token.Arg, Pos: token.Pos, Msg: "empty display text"}
+		}
+		offset := msg.textLen()
+		if err := msg.AppendText(arg); err != nil {
+			return err
+		}
+		length := msg.textLen() - offset
+		content := msg["msg_content"].(MsgInputModel)["content"].(MsgInputModel)
+		for _, style := range token.Styles {
+			if entity, ok := last_style[style]; ok && entity["offset"].(int)+entity["length"].(int) == offset {
+				entity["length"] = entity["length"].(int) + length
+				continue
+			}
+			entity := MsgInputModel{"entity": MsgInputModel{"type": MsgEntityStyleType, "font_style": style},
+				"offset": offset, "length": length}
+			content["entities"] = append(content["entities"].([]MsgInputModel), entity)
+			last_style[style] = entity
+		}
+	}
+	return nil
+}
+
+// length of the text message in UTF-16 code units
+func (msg MsgInputModel) textLen() int {
+	content := msg["msg_content"].(MsgInputModel)["content"].(MsgInputModel)
+	switch text := content["text"].(type) {
+	case string:
+		return utf16Len(text)
+	case interface{ String() string }:
+		return utf16Len(text.String())
+	}
+	return 0
+}
+
+// 为文本消息附带图片，图片会显示在文本下方，非米游社的图片会在发送时自动转存
+func (msg MsgInputModel) AddTextImage(url string) {
+	content := msg["msg_content"].(MsgInputModel)["content"].(MsgInputModel)
+	images, _ := content["images"].([]MsgInputModel)
+	content["images"] = append(images, MsgInputModel{"url": url})
+}
+
+/* markup renderer */
+
+// 消息中的实体，用于渲染标记语法，Offset与Length以UTF-16编码单元计
+type MarkupEntity struct {
+	Offset                 int
+	Length                 int
+	Type                   MsgEntityType
+	UserID                 string
+	BotID                  string
+	RoomID                 string
+	URL                    string
+	RequiresBotAccessToken bool
+	FontStyle              string
+}
+
+var markup_text_escaper = strings.NewReplacer(`\`, `\\`, `<`, `\<`, `>`, `\>`)
+var markup_url_escaper = strings.NewReplacer(`\`, `\\`, `<`, `\<`, `>`, `\>`, `|`, `\|`)
+
+// the syntax used by renderEntities, implemented by the inline markup and markdown
+type markupDialect interface {
+	openStyle(style string) string
+	closeStyle(style string) string
+	entity(entity MarkupEntity, text string) string
+	text(text string) string
+	image(url string) string
+}
+
+type inlineMarkup struct{}
+
+func (inlineMarkup) openStyle(style string) string {
+	return "<" + markupStyleTag(style) + ">"
+}
+
+func (inlineMarkup) closeStyle(style string) string {
+	return "</" + markupStyleTag(style) + ">"
+}
+
+func (inlineMarkup) entity(entity MarkupEntity, text string) string {
+	switch entity.Type {
+	case MsgEntityMentionUserType:
+		return "<@" + entity.UserID + ">"
+	case MsgEntityMentionRobotType:
+		return "<@" + entity.BotID + ">"
+	case MsgEntityMentionAllType:
+		return "<@everyone>"
+	case MsgEntityVillaRoomLinkType:
+		return "<#" + entity.RoomID + ">"
+	case MsgEntityLinkType:
+		prefix := "<$"
+		if entity.RequiresBotAccessToken {
+			prefix = "<$$"
+		}
+		if text == entity.URL {
+			return prefix + markup_url_escaper.Replace(entity.URL) + ">"
+		}
+		return prefix + markup_url_escaper.Replace(entity.URL) + "|" + markup_text_escaper.Replace(text) + ">"
+	}
+	return markup_text_escaper.Replace(text)
+}
+
+func (inlineMarkup) text(text string) string {
+	return markup_text_escaper.Replace(text)
+}
+
+func (inlineMarkup) image(url string) string {
+	return "<!" + markup_text_escaper.Replace(url) + ">"
+}
+
+// 将消息的文本、实体及图片渲染为标记语法，是 ParseMarkup 的逆过程；艾特用户及跳转房间会渲染为id，不保留显示的文字
+func RenderMarkup(text string, entities []MarkupEntity, image_urls []string) string {
+	return renderEntities(text, entities, image_urls, inlineMarkup{})
+}
+
+func renderEntities(text string, entities []MarkupEntity, image_urls []string, dialect markupDialect) string {
+	units := utf16.Encode([]rune(text))
+	boundaries := map[int]bool{0: true, len(units): true}
+	starts := make(map[int]MarkupEntity) // non-style entities by offset
+	var styles []MarkupEntity
+	for _, entity := range entities {
+		if entity.Offset < 0 || entity.Length <= 0 || entity.Offset+entity.Length > len(units) {
+			continue
+		}
+		boundaries[entity.Offset], boundaries[entity.Offset+entity.Length] = true, true
+		if entity.Type == MsgEntityStyleType {
+			if markupStyleTag(entity.FontStyle) != "" {
+				styles = append(styles, entity)
+			}
+		} else if _, ok := starts[entity.Offset]; !ok {
+			starts[entity.Offset] = entity
+		}
+	}
+	sorted := make([]int, 0, len(boundaries))
+	for b := range boundaries {
+		sorted = append(sorted, b)
+	}
+	sort.Ints(sorted)
+
+	var sb strings.Builder
+	var open []string
+	// close and open styles so that exactly the styles covering [from, to) are open, keeping them properly nested
+	sync := func(from, to int) {
+		want := make(map[string]bool)
+		for _, entity := range styles {
+			if entity.Offset <= from && entity.Offset+entity.Length >= to {
+				want[entity.FontStyle] = true
+			}
+		}
+		keep := 0
+		for keep < len(open) && want[open[keep]] {
+			keep++
+		}
+		for i := len(open) - 1; i >= keep; i-- {
+			sb.WriteString(dialect.closeStyle(open[i]))
+		}
+		open = open[:keep]
+		for _, style := range markup_style_order {
+			if !want[style] {
+				continue
+			}
+			opened := false
+			for _, s := range open {
+				opened = opened || s == style
+			}
+			if !opened {
+				sb.WriteString(dialect.openStyle(style))
+				open = append(open, style)
+			}
+		}
+	}
+
+	for pos := 0; pos < len(units); {
+		if entity, ok := starts[pos]; ok {
+			end := entity.Offset + entity.Length
+			sync(pos, end)
+			sb.WriteString(dialect.entity(entity, string(utf16.Decode(units[pos:end]))))
+			pos = end
+			continue
+		}
+		next := sort.SearchInts(sorted, pos+1)
+		end := sorted[next]
+		sync(pos, end)
+		sb.WriteString(dialect.text(string(utf16.Decode(units[pos:end]))))
+		pos = end
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		sb.WriteString(dialect.closeStyle(open[i]))
+	}
+	for _, url := range image_urls {
+		sb.WriteString(dialect.image(url))
+	}
+	return sb.String()
+}
+
+func markupEntityFromMap(entity map[string]interface{}, offset, length int) MarkupEntity {
+	ret := MarkupEntity{Offset: offset, Length: length}
+	ret.Type, _ = entity["type"].(MsgEntityType)
+	ret.UserID, _ = entity["user_id"].(string)
+	ret.BotID, _ = entity["bot_id"].(string)
+	ret.RoomID, _ = entity["room_id"].(string)
+	ret.URL, _ = entity["url"].(string)
+	ret.RequiresBotAccessToken, _ = entity["requires_bot_access_token"].(bool)
+	ret.FontStyle, _ = entity["font_style"].(string)
+	return ret
+}
+
+// text, entities and attached images of a text message created by NewMsg
+func (msg MsgInputModel) textContent() (string, []MarkupEntity, []string, error) {
+	if object_name, _ := msg["object_name"].(MsgContentType); object_name != MsgTypeText {
+		return "", nil, nil, fmt.Errorf("can not render %v message as text", msg["object_name"])
+	}
+	content := msg["msg_content"].(MsgInputModel)["content"].(MsgInputModel)
+	var text string
+	switch t := content["text"].(type) {
+	case string:
+		text = t
+	case interface{ String() string }:
+		text = t.String()
+	}
+	entities := []MarkupEntity{}
+	list, _ := content["entities"].([]MsgInputModel)
+	for _, item := range list {
+		entity, _ := item["entity"].(MsgInputModel)
+		offset, _ := item["offset"].(int)
+		length, _ := item["length"].(int)
+		entities = append(entities, markupEntityFromMap(entity, offset, length))
+	}
+	image_urls := []string{}
+	images, _ := content["images"].([]MsgInputModel)
+	for _, image := range images {
+		if url, ok := image["url"].(string); ok {
+			image_urls = append(image_urls, url)
+		}
+	}
+	return text, entities, image_urls, nil
+}
+
+// 将 NewMsg 创建的文本消息渲染为标记语法
+func (msg MsgInputModel) Markup() (string, error) {
+	text, entities, image_urls, err := msg.textContent()
+	if err != nil {
+		return "", err
+	}
+	return RenderMarkup(text, entities, image_urls), nil
+}
+
+func (msg *TextMessage) markupEntities() []MarkupEntity {
+	entities := make([]MarkupEntity, 0, len(msg.entities))
+	for _, entity := range msg.entities {
+		entities = append(entities, markupEntityFromMap(entity.Entity, entity.Offset, entity.Length))
+	}
+	return entities
+}
+
+// 将文本消息渲染为标记语法
+func (msg *TextMessage) Markup() string {
+	return RenderMarkup(msg.text.String(), msg.markupEntities(), nil)
+}
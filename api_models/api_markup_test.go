@@ -0,0 +1,123 @@
+package api_models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseMarkup(t *testing.T) {
+	tokens, err := ParseMarkup("hi <@123> in <#45>, <$https://a.com/?q=1|site> <$$https://b.com> <!https://c.com/x.png>",
+		"<b>bold <i>both</i></b> \\<not a tag\\> <@everyone> <@bot_abc>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MarkupToken{
+		{Type: MarkupText, Text: "hi ", Arg: 0, Pos: 0},
+		{Type: MarkupMentionUser, UserID: 123, Arg: 0, Pos: 3},
+		{Type: MarkupText, Text: " in ", Arg: 0, Pos: 9},
+		{Type: MarkupRoomLink, RoomID: 45, Arg: 0, Pos: 13},
+		{Type: MarkupText, Text: ", ", Arg: 0, Pos: 18},
+		{Type: MarkupLink, URL: "https://a.com/?q=1", Text: "site", Arg: 0, Pos: 20},
+		{Type: MarkupText, Text: " ", Arg: 0, Pos: 46},
+		{Type: MarkupLink, URL: "https://b.com", Text: "https://b.com", RequiresBotAccessToken: true, Arg: 0, Pos: 47},
+		{Type: MarkupText, Text: " ", Arg: 0, Pos: 64},
+		{Type: MarkupImage, URL: "https://c.com/x.png", Arg: 0, Pos: 65},
+		{Type: MarkupText, Text: "bold ", Styles: []string{StyleBold}, Arg: 1, Pos: 3},
+		{Type: MarkupText, Text: "both", Styles: []string{StyleBold, StyleItalic}, Arg: 1, Pos: 11},
+		{Type: MarkupText, Text: " <not a tag> ", Arg: 1, Pos: 23},
+		{Type: MarkupMentionAll, Text: MsgMentionAllText, Arg: 1, Pos: 38},
+		{Type: MarkupText, Text: " ", Arg: 1, Pos: 49},
+		{Type: MarkupMentionRobot, Text: "@机器人", BotID: "bot_abc", Arg: 1, Pos: 50},
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("ParseMarkup() =\n%+v\nwant\n%+v", tokens, want)
+	}
+}
+
+func TestParseMarkupLinkSeparator(t *testing.T) {
+	tokens, err := ParseMarkup(`<$https://a.com/?a=1\|2>`, `<$https://a.com|x|y>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens[0].URL != "https://a.com/?a=1|2" || tokens[0].Text != tokens[0].URL {
+		t.Errorf("escaped separator: got url %q text %q", tokens[0].URL, tokens[0].Text)
+	}
+	if tokens[1].URL != "https://a.com" || tokens[1].Text != "x|y" {
+		t.Errorf("first separator: got url %q text %q", tokens[1].URL, tokens[1].Text)
+	}
+}
+
+func TestParseMarkupErrors(t *testing.T) {
+	cases := []struct {
+		input string
+		pos   int
+	}{
+		{"a > b", 2},             // bare ">" must be escaped
+		{"<abc>", 0},             // unknown tag
+		{"<>", 0},                // empty tag
+		{"x <@abc>", 2},          // invalid user id
+		{"<#0>", 0},              // invalid room id
+		{"<$|text>", 0},          // empty link url
+		{"<$https://a.com|>", 0}, // empty link text
+		{"<!>", 0},               // empty image url
+		{"<b>x", 0},              // unclosed style
+		{"<b><i>x</b></i>", 7},   // crossed styles
+		{"<b><b>x</b></b>", 3},   // style nested in itself
+		{"<b></b>", 3},           // empty style
+		{"</b>", 0},              // closing without opening
+		{"<@1<", 3},              // "<" inside a tag
+		{"<@1", 0},               // unclosed tag
+		{`a\`, 1},                // dangling escape
+	}
+	for _, c := range cases {
+		_, err := ParseMarkup(c.input)
+		var markup_err *MarkupError
+		if !errors.As(err, &markup_err) {
+			t.Errorf("ParseMarkup(%q) error = %v, want *MarkupError", c.input, err)
+			continue
+		}
+		if markup_err.Pos != c.pos {
+			t.Errorf("ParseMarkup(%q) error position = %d, want %d (%v)", c.input, markup_err.Pos, c.pos, err)
+		}
+	}
+}
+
+func TestMarkupRoundTrip(t *testing.T) {
+	inputs := []string{
+		"plain text",
+		`escaped \<tag\> and \\ backslash`,
+		"<b>bold</b> and <i>italic <u>under</u></i> <s>strike</s>",
+		"<b>bold <@123> mention</b> <@everyone> <@bot_abc>",
+		"room <#45> link <$https://a.com/?a=1\\|2|text \\> here> <$$https://b.com>",
+		"emoji 😀 <b>😀😀</b> end<!https://c.com/x.png>",
+	}
+	for _, input := range inputs {
+		tokens, err := ParseMarkup(input)
+		if err != nil {
+			t.Fatalf("ParseMarkup(%q): %v", input, err)
+		}
+		for i := range tokens {
+			switch tokens[i].Type {
+			case MarkupMentionUser:
+				tokens[i].Text = "@user"
+			case MarkupRoomLink:
+				tokens[i].Text = "#room"
+			}
+		}
+		msg, err := NewMsg(MsgTypeText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := msg.AppendMarkup(1, tokens); err != nil {
+			t.Fatalf("AppendMarkup(%q): %v", input, err)
+		}
+		output, err := msg.Markup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != input {
+			t.Errorf("round trip of %q = %q", input, output)
+		}
+	}
+}
@@ -145,17 +145,20 @@ type GetRoomModel struct {
 	} `json:"data"`
 }
 
+// 开放API返回 {"list": [{"group_id", "group_name", "room_list": [...]}]}，每个分组及其房间为list中的一项
 type GetVillaGroupRoomListModel struct {
 	APIBaseModel
 	Data struct {
-		GroupID   uint64 `json:"group_id,string"`
-		GroupName string `json:"group_name"`
-		RoomList  []struct {
-			RoomID   uint64 `json:"room_id,string"`
-			RoomName string `json:"room_name"`
-			RoomType string `json:"room_type"`
-			GroupID  uint64 `json:"group_id,string"`
-		} `json:"room_list"`
+		List []struct {
+			GroupID   uint64 `json:"group_id,string"`
+			GroupName string `json:"group_name"`
+			RoomList  []struct {
+				RoomID   uint64 `json:"room_id,string"`
+				RoomName string `json:"room_name"`
+				RoomType string `json:"room_type"`
+				GroupID  uint64 `json:"group_id,string"`
+			} `json:"room_list"`
+		} `json:"list"`
 	} `json:"data"`
 }
 
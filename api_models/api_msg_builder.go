@@ -0,0 +1,561 @@
+package api_models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	utils "github.com/GLGDLY/mhy_botsdk/utils"
+)
+
+/* typed message builders
+ *
+ * TextMessage、ImageMessage、PostMessage 为强类型的消息构造器，与 NewMsg 返回的 MsgInputModel 一样可直接传入 SendMessageCustomize，
+ * 构造过程中的错误（如不合法的参数）会被记录下来，在 Build 时统一返回，不会在运行时panic */
+
+const (
+	MsgTextMaxLength    = 4000             // 文本消息的最大长度（UTF-16编码单元）
+	MsgImageMaxFileSize = 10 * 1024 * 1024 // 图片的最大大小，单位为字节
+	MsgMentionAllText   = "@全体成员"
+)
+
+// 可被 SendMessageCustomize 发送的消息，Build 返回最终的请求体（包含 object_name、msg_content、room_id）
+type MsgBuilder interface {
+	Build(room_id uint64) (MsgInputModel, error)
+}
+
+// 使 NewMsg 创建的 MsgInputModel 同样可以作为 MsgBuilder 使用，内部的类型错误会以error的形式返回
+func (msg MsgInputModel) Build(room_id uint64) (result MsgInputModel, err error) {
+	if _, ok := msg["object_name"].(MsgContentType); !ok {
+		return nil, errors.New("invalid message: missing object_name, please create the message with NewMsg")
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			result, err = nil, fmt.Errorf("invalid message: %v", e)
+		}
+	}()
+	return msg.Finialize(room_id), nil
+}
+
+// length of s in UTF-16 code units, which is used by the open api for entity offsets
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+func finalizeMsg(object_name MsgContentType, room_id uint64, msg_content interface{}) (MsgInputModel, error) {
+	bytes_data, err := json.Marshal(msg_content)
+	if err != nil {
+		return nil, err
+	}
+	return MsgInputModel{"object_name": object_name, "msg_content": string(bytes_data), "room_id": room_id}, nil
+}
+
+/* text message */
+
+type msgTextEntity struct {
+	Entity map[string]interface{} `json:"entity"`
+	Offset int                    `json:"offset"`
+	Length int                    `json:"length"`
+}
+
+type msgQuote struct {
+	OriginalMessageID       string `json:"original_message_id"`
+	OriginalMessageSendTime uint64 `json:"original_message_send_time"`
+	QuotedMessageID         string `json:"quoted_message_id"`
+	QuotedMessageSendTime   uint64 `json:"quoted_message_send_time"`
+}
+
+type MsgComponentType int
+type MsgButtonType int
+
+const (
+	MsgComponentButton MsgComponentType = 1 // 按钮组件
+)
+
+const (
+	MsgButtonCallback MsgButtonType = 1 // 回传型按钮，点击后机器人会收到 ClickMsgComponent 事件
+	MsgButtonInput    MsgButtonType = 2 // 输入型按钮，点击后将input填入用户的输入框
+	MsgButtonLink     MsgButtonType = 3 // 跳转型按钮，点击后打开link
+)
+
+// 每行可放置的组件数量上限
+const (
+	MsgPanelSmallRowMax = 3
+	MsgPanelMidRowMax   = 2
+	MsgPanelBigRowMax   = 1
+)
+
+// 消息组件，使用 NewCallbackButton、NewInputButton、NewLinkButton 创建
+type MsgComponent struct {
+	ID           string           `json:"id"`            // 组件id，由机器人自定义，同一消息内不可重复
+	Text         string           `json:"text"`          // 组件显示的文字
+	Type         MsgComponentType `json:"type"`          // 组件类型，目前只有按钮
+	CType        MsgButtonType    `json:"c_type"`        // 按钮类型
+	Input        string           `json:"input"`         // 输入型按钮填入输入框的内容
+	Link         string           `json:"link"`          // 跳转型按钮的链接
+	NeedCallback bool             `json:"need_callback"` // 点击后是否回调 ClickMsgComponent 事件
+	Extra        string           `json:"extra"`         // 回调时原样附带的自定义数据
+	NeedToken    bool             `json:"need_token"`    // 跳转时是否附带bot_member_access_token
+}
+
+// 回传型按钮，点击后机器人会收到带有id及extra的 ClickMsgComponent 事件
+func NewCallbackButton(id, text, extra string) MsgComponent {
+	return MsgComponent{ID: id, Text: text, Type: MsgComponentButton, CType: MsgButtonCallback, NeedCallback: true, Extra: extra}
+}
+
+// 输入型按钮，点击后将input填入用户的输入框
+func NewInputButton(id, text, input string) MsgComponent {
+	return MsgComponent{ID: id, Text: text, Type: MsgComponentButton, CType: MsgButtonInput, Input: input}
+}
+
+// 跳转型按钮，点击后打开link；need_token为true时跳转会附带bot_member_access_token
+func NewLinkButton(id, text, link string, need_token bool) MsgComponent {
+	return MsgComponent{ID: id, Text: text, Type: MsgComponentButton, CType: MsgButtonLink, Link: link, NeedToken: need_token}
+}
+
+// 消息组件面板，可使用在开放平台预先配置的模板（TemplateID），或自行添加小、中、大三种尺寸的组件行
+type MsgPanel struct {
+	TemplateID              uint64           `json:"template_id,omitempty"`                // 组件模板id
+	SmallComponentGroupList [][]MsgComponent `json:"small_component_group_list,omitempty"` // 小尺寸组件，每行最多3个
+	MidComponentGroupList   [][]MsgComponent `json:"mid_component_group_list,omitempty"`   // 中尺寸组件，每行最多2个
+	BigComponentGroupList   [][]MsgComponent `json:"big_component_group_list,omitempty"`   // 大尺寸组件，每行最多1个
+}
+
+func NewPanel() *MsgPanel {
+	return &MsgPanel{}
+}
+
+// 使用预先配置的组件模板
+func NewTemplatePanel(template_id uint64) *MsgPanel {
+	return &MsgPanel{TemplateID: template_id}
+}
+
+// 添加一行小尺寸组件（最多3个）
+func (panel *MsgPanel) AddSmallRow(components ...MsgComponent) *MsgPanel {
+	panel.SmallComponentGroupList = append(panel.SmallComponentGroupList, components)
+	return panel
+}
+
+// 添加一行中尺寸组件（最多2个）
+func (panel *MsgPanel) AddMidRow(components ...MsgComponent) *MsgPanel {
+	panel.MidComponentGroupList = append(panel.MidComponentGroupList, components)
+	return panel
+}
+
+// 添加一行大尺寸组件（最多1个）
+func (panel *MsgPanel) AddBigRow(components ...MsgComponent) *MsgPanel {
+	panel.BigComponentGroupList = append(panel.BigComponentGroupList, components)
+	return panel
+}
+
+// 检查组件数量、id是否重复及各类按钮的必填内容
+func (panel *MsgPanel) Validate() error {
+	if panel == nil {
+		return errors.New("nil panel")
+	}
+	ids := make(map[string]bool)
+	check := func(size string, groups [][]MsgComponent, max int) error {
+		for i, row := range groups {
+			if len(row) == 0 || len(row) > max {
+				return fmt.Errorf("panel %s row %d has %d components, should be 1 to %d", size, i, len(row), max)
+			}
+			for _, c := range row {
+				if c.ID == "" || c.Text == "" {
+					return fmt.Errorf("panel component requires both id and text, got id %q text %q", c.ID, c.Text)
+				}
+				if ids[c.ID] {
+					return fmt.Errorf("duplicated panel component id %q", c.ID)
+				}
+				ids[c.ID] = true
+				if c.CType == MsgButtonLink && c.Link == "" {
+					return fmt.Errorf("link button %q requires link", c.ID)
+				}
+				if c.CType == MsgButtonInput && c.Input == "" {
+					return fmt.Errorf("input button %q requires input", c.ID)
+				}
+			}
+		}
+		return nil
+	}
+	if err := check("small", panel.SmallComponentGroupList, MsgPanelSmallRowMax); err != nil {
+		return err
+	}
+	if err := check("mid", panel.MidComponentGroupList, MsgPanelMidRowMax); err != nil {
+		return err
+	}
+	if err := check("big", panel.BigComponentGroupList, MsgPanelBigRowMax); err != nil {
+		return err
+	}
+	if panel.TemplateID == 0 && len(ids) == 0 {
+		return errors.New("empty panel, requires either template id or components")
+	}
+	return nil
+}
+
+type TextMessage struct {
+	text         strings.Builder
+	length       int // length of text in UTF-16 code units
+	entities     []msgTextEntity
+	mention_type MsgMentionType
+	mentioned    []string
+	quote        *msgQuote
+	panel        *MsgPanel
+	err          error // first error during building, returned by Build
+}
+
+func NewTextMessage() *TextMessage {
+	return &TextMessage{mention_type: MentionUser, mentioned: []string{}, entities: []msgTextEntity{}}
+}
+
+func (msg *TextMessage) setErr(err error) {
+	if msg.err == nil {
+		msg.err = err
+	}
+}
+
+func (msg *TextMessage) write(text string) int {
+	offset := msg.length
+	msg.text.WriteString(text)
+	msg.length += utf16Len(text)
+	return offset
+}
+
+func (msg *TextMessage) addEntity(text string, entity map[string]interface{}) *TextMessage {
+	if text == "" {
+		msg.setErr(fmt.Errorf("empty text for %v entity", entity["type"]))
+		return msg
+	}
+	offset := msg.write(text)
+	msg.entities = append(msg.entities, msgTextEntity{Entity: entity, Offset: offset, Length: msg.length - offset})
+	return msg
+}
+
+func (msg *TextMessage) addMentioned(id string) {
+	for _, v := range msg.mentioned {
+		if v == id {
+			return
+		}
+	}
+	msg.mentioned = append(msg.mentioned, id)
+}
+
+// 追加普通文本
+func (msg *TextMessage) Text(text string) *TextMessage {
+	msg.write(text)
+	return msg
+}
+
+// 追加格式化的普通文本
+func (msg *TextMessage) Textf(format string, args ...interface{}) *TextMessage {
+	return msg.Text(fmt.Sprintf(format, args...))
+}
+
+// 艾特用户，显示为"@nickname"
+func (msg *TextMessage) MentionUser(uid uint64, nickname string) *TextMessage {
+	if uid == 0 {
+		msg.setErr(errors.New("invalid user id 0 for mention"))
+		return msg
+	}
+	msg.addMentioned(utils.String(uid))
+	return msg.addEntity("@"+nickname, map[string]interface{}{"type": MsgEntityMentionUserType, "user_id": utils.String(uid)})
+}
+
+// 艾特机器人，显示为"@name"
+func (msg *TextMessage) MentionRobot(bot_id string, name string) *TextMessage {
+	if bot_id == "" {
+		msg.setErr(errors.New("empty bot id for mention"))
+		return msg
+	}
+	msg.addMentioned(bot_id)
+	return msg.addEntity("@"+name, map[string]interface{}{"type": MsgEntityMentionRobotType, "bot_id": bot_id})
+}
+
+// 艾特全体成员，显示为"@全体成员"
+func (msg *TextMessage) MentionAll() *TextMessage {
+	msg.mention_type = MentionAll
+	return msg.addEntity(MsgMentionAllText, map[string]interface{}{"type": MsgEntityMentionAllType})
+}
+
+// 跳转房间，显示为"#room_name"
+func (msg *TextMessage) RoomLink(villa_id, room_id uint64, room_name string) *TextMessage {
+	if villa_id == 0 || room_id == 0 {
+		msg.setErr(errors.New("invalid villa id or room id for room link"))
+		return msg
+	}
+	return msg.addEntity("#"+room_name, map[string]interface{}{"type": MsgEntityVillaRoomLinkType,
+		"villa_id": utils.String(villa_id), "room_id": utils.String(room_id)})
+}
+
+// 跳转链接，text为空时显示链接自身；requires_bot_access_token为true时，点击链接会附带bot_member_access_token
+func (msg *TextMessage) Link(url, text string, requires_bot_access_token bool) *TextMessage {
+	if url == "" {
+		msg.setErr(errors.New("empty url for link"))
+		return msg
+	}
+	if text == "" {
+		text = url
+	}
+	return msg.addEntity(text, map[string]interface{}{"type": MsgEntityLinkType, "url": url,
+		"requires_bot_access_token": requires_bot_access_token})
+}
+
+// 带样式的文本，font_style为 StyleBold、StyleItalic、StyleStrikethrough、StyleUnderline 之一
+func (msg *TextMessage) Styled(text, font_style string) *TextMessage {
+	switch font_style {
+	case StyleBold, StyleItalic, StyleStrikethrough, StyleUnderline:
+	default:
+		msg.setErr(fmt.Errorf("unknown font style %q", font_style))
+		return msg
+	}
+	return msg.addEntity(text, map[string]interface{}{"type": MsgEntityStyleType, "font_style": font_style})
+}
+
+func (msg *TextMessage) Bold(text string) *TextMessage {
+	return msg.Styled(text, StyleBold)
+}
+
+func (msg *TextMessage) Italic(text string) *TextMessage {
+	return msg.Styled(text, StyleItalic)
+}
+
+func (msg *TextMessage) Strikethrough(text string) *TextMessage {
+	return msg.Styled(text, StyleStrikethrough)
+}
+
+func (msg *TextMessage) Underline(text string) *TextMessage {
+	return msg.Styled(text, StyleUnderline)
+}
+
+// 与 MsgInputModel.AppendText 相同，可混合传入string及 MsgEntityMentionUser、MsgEntityLink 等实体
+func (msg *TextMessage) Append(args ...interface{}) *TextMessage {
+	for _, arg := range args {
+		switch typed_arg := arg.(type) {
+		case string:
+			msg.Text(typed_arg)
+		case MsgEntityMentionRobot:
+			msg.addMentioned(typed_arg.BotID)
+			msg.addEntity(typed_arg.Text, map[string]interface{}{"type": MsgEntityMentionRobotType, "bot_id": typed_arg.BotID})
+		case MsgEntityMentionUser:
+			msg.addMentioned(utils.String(typed_arg.UserID))
+			msg.addEntity(typed_arg.Text, map[string]interface{}{"type": MsgEntityMentionUserType, "user_id": utils.String(typed_arg.UserID)})
+		case MsgEntityMentionAll:
+			msg.mention_type = MentionAll
+			msg.addEntity(typed_arg.Text, map[string]interface{}{"type": MsgEntityMentionAllType})
+		case MsgEntityVillaRoomLink:
+			msg.addEntity(typed_arg.Text, map[string]interface{}{"type": MsgEntityVillaRoomLinkType,
+				"villa_id": utils.String(typed_arg.VillaID), "room_id": utils.String(typed_arg.RoomID)})
+		case MsgEntityLink:
+			msg.Link(typed_arg.URL, typed_arg.Text, typed_arg.RequiresBotAccessToken)
+		case MsgEntityStyle:
+			msg.Styled(typed_arg.Text, typed_arg.FontStyle)
+		default:
+			msg.Text(utils.String(arg))
+		}
+	}
+	return msg
+}
+
+// 引用回复消息，接受被引用消息的id和发送时间
+func (msg *TextMessage) Quote(quoted_message_id string, quoted_message_send_time uint64) *TextMessage {
+	if quoted_message_id == "" {
+		msg.setErr(errors.New("empty quoted message id"))
+		return msg
+	}
+	msg.quote = &msgQuote{OriginalMessageID: quoted_message_id, OriginalMessageSendTime: quoted_message_send_time,
+		QuotedMessageID: quoted_message_id, QuotedMessageSendTime: quoted_message_send_time}
+	return msg
+}
+
+// 附加消息组件面板（按钮等），使用 NewPanel 或 NewTemplatePanel 创建
+func (msg *TextMessage) Panel(panel *MsgPanel) *TextMessage {
+	msg.panel = panel
+	return msg
+}
+
+// 当前文本的长度（UTF-16编码单元）
+func (msg *TextMessage) Len() int {
+	return msg.length
+}
+
+func (msg *TextMessage) String() string {
+	return msg.text.String()
+}
+
+// 构造过程中出现的第一个错误
+func (msg *TextMessage) Err() error {
+	return msg.err
+}
+
+func (msg *TextMessage) validate() error {
+	if msg.err != nil {
+		return msg.err
+	}
+	if msg.length == 0 {
+		return errors.New("empty text message")
+	}
+	if msg.length > MsgTextMaxLength {
+		return fmt.Errorf("text message too long: %d > %d", msg.length, MsgTextMaxLength)
+	}
+	if msg.panel != nil {
+		if err := msg.panel.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, entity := range msg.entities {
+		if entity.Offset < 0 || entity.Length <= 0 || entity.Offset+entity.Length > msg.length {
+			return fmt.Errorf("entity %v out of range: offset %d, length %d, text length %d",
+				entity.Entity["type"], entity.Offset, entity.Length, msg.length)
+		}
+	}
+	return nil
+}
+
+func (msg *TextMessage) Build(room_id uint64) (MsgInputModel, error) {
+	if err := msg.validate(); err != nil {
+		return nil, err
+	}
+	msg_content := map[string]interface{}{
+		"content": map[string]interface{}{"text": msg.text.String(), "entities": msg.entities},
+	}
+	if msg.mention_type == MentionAll || len(msg.mentioned) > 0 {
+		msg_content["mentionedInfo"] = map[string]interface{}{"type": msg.mention_type, "userIdList": msg.mentioned}
+	}
+	if msg.quote != nil {
+		msg_content["quote"] = msg.quote
+	}
+	if msg.panel != nil {
+		msg_content["panel"] = msg.panel
+	}
+	return finalizeMsg(MsgTypeText, room_id, msg_content)
+}
+
+/* image message */
+
+type ImageMessage struct {
+	url       string
+	width     int
+	height    int
+	file_size int
+	data      []byte // local image waiting to be uploaded
+	ext       string
+	err       error
+}
+
+// 创建图片消息，非米游社的图片地址会在发送时自动通过 UploadImage 转存
+func NewImageMessage(url string) *ImageMessage {
+	return &ImageMessage{url: url}
+}
+
+// 以图片文件的内容创建图片消息，发送时会自动上传，宽高及大小会根据内容自动识别
+func NewImageMessageFromBytes(data []byte) *ImageMessage {
+	msg := &ImageMessage{data: data}
+	info, err := DetectImage(data)
+	if err != nil {
+		msg.err = err
+		return msg
+	}
+	msg.ext, msg.width, msg.height, msg.file_size = info.Ext, info.Width, info.Height, info.FileSize
+	return msg
+}
+
+// 以本地图片文件创建图片消息，读取失败时会在发送时返回错误
+func NewImageMessageFromFile(file_path string) *ImageMessage {
+	data, err := os.ReadFile(file_path)
+	if err != nil {
+		return &ImageMessage{err: err}
+	}
+	return NewImageMessageFromBytes(data)
+}
+
+// 读取r的全部内容创建图片消息，读取失败时会在发送时返回错误
+func NewImageMessageFromReader(r io.Reader) *ImageMessage {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return &ImageMessage{err: err}
+	}
+	return NewImageMessageFromBytes(data)
+}
+
+// 设置图片的宽高，单位为像素
+func (msg *ImageMessage) Size(width, height int) *ImageMessage {
+	msg.width, msg.height = width, height
+	return msg
+}
+
+// 设置图片的大小，单位为字节，不应超过10M
+func (msg *ImageMessage) FileSize(file_size int) *ImageMessage {
+	msg.file_size = file_size
+	return msg
+}
+
+// 图片的地址，尚未上传时为空
+func (msg *ImageMessage) URL() string {
+	return msg.url
+}
+
+// 构造过程中的错误（如读取文件失败、无法识别的图片格式）
+func (msg *ImageMessage) Err() error {
+	return msg.err
+}
+
+// 待上传的图片内容及扩展名，已上传或由url创建时data为nil
+func (msg *ImageMessage) PendingUpload() (data []byte, ext string) {
+	if msg.url != "" {
+		return nil, ""
+	}
+	return msg.data, msg.ext
+}
+
+// 设置上传或转存后的图片地址，并释放待上传的内容
+func (msg *ImageMessage) SetURL(url string) *ImageMessage {
+	msg.url, msg.data = url, nil
+	return msg
+}
+
+func (msg *ImageMessage) Build(room_id uint64) (MsgInputModel, error) {
+	if msg.err != nil {
+		return nil, msg.err
+	}
+	if msg.url == "" && msg.data != nil {
+		return nil, errors.New("image has not been uploaded, please send it with SendMessageCustomize")
+	}
+	if msg.url == "" {
+		return nil, errors.New("empty image url")
+	}
+	if msg.width < 0 || msg.height < 0 || msg.file_size < 0 {
+		return nil, errors.New("negative image size")
+	}
+	if msg.file_size > MsgImageMaxFileSize {
+		return nil, fmt.Errorf("image too large: %d > %d bytes", msg.file_size, MsgImageMaxFileSize)
+	}
+	content := map[string]interface{}{"url": msg.url}
+	if msg.width > 0 && msg.height > 0 {
+		content["size"] = map[string]interface{}{"width": msg.width, "height": msg.height}
+	}
+	if msg.file_size > 0 {
+		content["file_size"] = msg.file_size
+	}
+	return finalizeMsg(MsgTypeImage, room_id, map[string]interface{}{"content": content})
+}
+
+/* post message */
+
+type PostMessage struct {
+	post_id string
+}
+
+// 创建帖子消息，post_id为米游社帖子的id
+func NewPostMessage(post_id string) *PostMessage {
+	return &PostMessage{post_id: post_id}
+}
+
+func (msg *PostMessage) Build(room_id uint64) (MsgInputModel, error) {
+	if msg.post_id == "" {
+		return nil, errors.New("empty post id")
+	}
+	return finalizeMsg(MsgTypePost, room_id, map[string]interface{}{"content": map[string]interface{}{"post_id": msg.post_id}})
+}
@@ -0,0 +1,199 @@
+package api_models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+/* long text message splitting */
+
+type splitEntity struct {
+	Entity map[string]interface{} `json:"entity"`
+	Offset int                    `json:"offset"`
+	Length int                    `json:"length"`
+}
+
+func (entity splitEntity) end() int {
+	return entity.Offset + entity.Length
+}
+
+// style entities may be cut when there is no other choice, other entities must stay whole
+func (entity splitEntity) atomic() bool {
+	return entity.Entity["type"] != string(MsgEntityStyleType)
+}
+
+func (entity splitEntity) mentionedID() string {
+	switch entity.Entity["type"] {
+	case string(MsgEntityMentionUserType):
+		id, _ := entity.Entity["user_id"].(string)
+		return id
+	case string(MsgEntityMentionRobotType):
+		id, _ := entity.Entity["bot_id"].(string)
+		return id
+	}
+	return ""
+}
+
+type splitMentionedInfo struct {
+	Type       MsgMentionType `json:"type"`
+	UserIdList []string       `json:"userIdList"`
+}
+
+/* 将 Build 得到的文本消息按max_length（UTF-16编码单元，<=0时为 MsgTextMaxLength）拆分为多条消息，不超长时返回原消息
+ *
+ * 优先在换行处拆分，其次为空白字符处，不会拆开艾特、跳转房间及链接，也尽量不拆开样式（样式本身超长时除外），
+ * 实体的offset会按每条消息重新计算；引用只保留在第一条，组件面板及附带的图片只保留在最后一条，
+ * 艾特的用户只出现在包含对应实体的消息的mentionedInfo中 */
+func SplitTextMsg(msg MsgInputModel, max_length int) ([]MsgInputModel, error) {
+	if max_length <= 0 {
+		max_length = MsgTextMaxLength
+	}
+	if object_name, _ := msg["object_name"].(MsgContentType); object_name != MsgTypeText {
+		return []MsgInputModel{msg}, nil
+	}
+	raw_content, ok := msg["msg_content"].(string)
+	if !ok {
+		return nil, errors.New("SplitTextMsg requires a message returned by Build")
+	}
+	var msg_content map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw_content), &msg_content); err != nil {
+		return nil, err
+	}
+	var content map[string]json.RawMessage
+	if err := json.Unmarshal(msg_content["content"], &content); err != nil {
+		return nil, err
+	}
+	var text string
+	var entities []splitEntity
+	if err := json.Unmarshal(content["text"], &text); err != nil {
+		return nil, err
+	}
+	if raw, ok := content["entities"]; ok {
+		if err := json.Unmarshal(raw, &entities); err != nil {
+			return nil, err
+		}
+	}
+	units := utf16.Encode([]rune(text))
+	if len(units) <= max_length {
+		return []MsgInputModel{msg}, nil
+	}
+
+	var chunks [][2]int
+	for start := 0; start < len(units); {
+		end := len(units)
+		if end-start > max_length {
+			var err error
+			if end, err = splitPoint(units, entities, start, max_length); err != nil {
+				return nil, err
+			}
+		}
+		chunks = append(chunks, [2]int{start, end})
+		start = end
+	}
+
+	var mentioned splitMentionedInfo
+	if raw, ok := msg_content["mentionedInfo"]; ok {
+		if err := json.Unmarshal(raw, &mentioned); err != nil {
+			return nil, err
+		}
+	}
+	with_entity := make(map[string]bool)
+	for _, entity := range entities {
+		if id := entity.mentionedID(); id != "" {
+			with_entity[id] = true
+		}
+	}
+
+	ret := make([]MsgInputModel, 0, len(chunks))
+	for i, chunk := range chunks {
+		start, end := chunk[0], chunk[1]
+		chunk_entities := []splitEntity{}
+		chunk_mentioned := splitMentionedInfo{Type: MentionUser, UserIdList: []string{}}
+		ids := make(map[string]bool)
+		for _, entity := range entities {
+			if entity.end() <= start || entity.Offset >= end {
+				continue
+			}
+			offset := entity.Offset
+			if offset < start {
+				offset = start
+			}
+			entity_end := entity.end()
+			if entity_end > end {
+				entity_end = end
+			}
+			chunk_entities = append(chunk_entities, splitEntity{Entity: entity.Entity, Offset: offset - start, Length: entity_end - offset})
+			if entity.Entity["type"] == string(MsgEntityMentionAllType) {
+				chunk_mentioned.Type = MentionAll
+			}
+			if id := entity.mentionedID(); id != "" {
+				ids[id] = true
+			}
+		}
+		for _, id := range mentioned.UserIdList {
+			if ids[id] || (i == 0 && !with_entity[id]) {
+				chunk_mentioned.UserIdList = append(chunk_mentioned.UserIdList, id)
+			}
+		}
+
+		chunk_content := make(map[string]interface{}, len(content))
+		for k, v := range content {
+			if k != "images" || i == len(chunks)-1 {
+				chunk_content[k] = v
+			}
+		}
+		chunk_content["text"] = string(utf16.Decode(units[start:end]))
+		chunk_content["entities"] = chunk_entities
+
+		chunk_msg_content := make(map[string]interface{}, len(msg_content))
+		for k, v := range msg_content {
+			switch {
+			case k == "quote" && i != 0, k == "panel" && i != len(chunks)-1, k == "mentionedInfo":
+			default:
+				chunk_msg_content[k] = v
+			}
+		}
+		chunk_msg_content["content"] = chunk_content
+		if chunk_mentioned.Type == MentionAll || len(chunk_mentioned.UserIdList) > 0 {
+			chunk_msg_content["mentionedInfo"] = chunk_mentioned
+		}
+		bytes_data, err := json.Marshal(chunk_msg_content)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, MsgInputModel{"object_name": MsgTypeText, "msg_content": string(bytes_data), "room_id": msg["room_id"]})
+	}
+	return ret, nil
+}
+
+// find the end of the chunk starting at start, preferring newlines, then spaces, and never cutting an entity
+func splitPoint(units []uint16, entities []splitEntity, start, max_length int) (int, error) {
+	limit := start + max_length
+	valid := func(pos int, allow_style bool) bool {
+		if utf16.IsSurrogate(rune(units[pos-1])) && utf16.IsSurrogate(rune(units[pos])) && units[pos-1] < 0xdc00 {
+			return false // inside a surrogate pair
+		}
+		for _, entity := range entities {
+			if entity.Offset < pos && pos < entity.end() && (entity.atomic() || !allow_style) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, allow_style := range []bool{false, true} {
+		for _, prefer := range []func(u uint16) bool{
+			func(u uint16) bool { return u == '\n' },
+			func(u uint16) bool { return u == ' ' || u == '\t' || u == 0x3000 },
+			func(u uint16) bool { return true },
+		} {
+			for pos := limit; pos > start; pos-- {
+				if prefer(units[pos-1]) && valid(pos, allow_style) {
+					return pos, nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("can not split text message at offset %d: an entity is longer than %d", start, max_length)
+}
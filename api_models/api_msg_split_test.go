@@ -0,0 +1,166 @@
+package api_models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+type splitChunk struct {
+	Content struct {
+		Text     string        `json:"text"`
+		Entities []splitEntity `json:"entities"`
+	} `json:"content"`
+	MentionedInfo *splitMentionedInfo    `json:"mentionedInfo"`
+	Quote         map[string]interface{} `json:"quote"`
+	Panel         map[string]interface{} `json:"panel"`
+}
+
+func splitChunks(t *testing.T, msg MsgInputModel, max_length int) []splitChunk {
+	t.Helper()
+	msgs, err := SplitTextMsg(msg, max_length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := make([]splitChunk, len(msgs))
+	for i, m := range msgs {
+		if err := json.Unmarshal([]byte(m["msg_content"].(string)), &chunks[i]); err != nil {
+			t.Fatal(err)
+		}
+		if length := len(utf16.Encode([]rune(chunks[i].Content.Text))); length > max_length {
+			t.Errorf("chunk %d has length %d > %d", i, length, max_length)
+		}
+	}
+	return chunks
+}
+
+func entityText(text string, entity splitEntity) string {
+	units := utf16.Encode([]rune(text))
+	return string(utf16.Decode(units[entity.Offset:entity.end()]))
+}
+
+func TestSplitTextMsgShort(t *testing.T) {
+	msg, err := NewTextMessage().Text("short").Build(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := SplitTextMsg(msg, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || !reflect.DeepEqual(msgs[0], msg) {
+		t.Errorf("SplitTextMsg() = %v, want the original message", msgs)
+	}
+}
+
+func TestSplitTextMsgPreferNewline(t *testing.T) {
+	msg, err := NewTextMessage().Text("aaa bbb\nccc ddd eee").Build(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, chunk := range splitChunks(t, msg, 10) {
+		texts = append(texts, chunk.Content.Text)
+	}
+	want := []string{"aaa bbb\n", "ccc ddd ", "eee"}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("chunks = %q, want %q", texts, want)
+	}
+}
+
+func TestSplitTextMsgSurrogatePair(t *testing.T) {
+	text := strings.Repeat("😀", 10) // 20 UTF-16 units without any space
+	msg, err := NewTextMessage().Text(text).Build(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var joined string
+	for i, chunk := range splitChunks(t, msg, 5) {
+		if !utf8.ValidString(chunk.Content.Text) || strings.ContainsRune(chunk.Content.Text, utf8.RuneError) {
+			t.Errorf("chunk %d cuts a surrogate pair: %q", i, chunk.Content.Text)
+		}
+		if chunk.Content.Text != "😀😀" {
+			t.Errorf("chunk %d = %q, want two emojis", i, chunk.Content.Text)
+		}
+		joined += chunk.Content.Text
+	}
+	if joined != text {
+		t.Errorf("joined chunks = %q, want %q", joined, text)
+	}
+}
+
+func TestSplitTextMsgEntities(t *testing.T) {
+	msg, err := NewTextMessage().
+		Quote("quoted_msg", 1).
+		Text("hi ").MentionUser(123, "user").Text("x").
+		Link("https://a.com", "link😀", false).
+		MentionUser(456, "other").Text(" end").
+		Build(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := splitChunks(t, msg, 10)
+	var joined string
+	found := make(map[string]string)
+	for i, chunk := range chunks {
+		joined += chunk.Content.Text
+		var ids []string
+		for _, entity := range chunk.Content.Entities {
+			text := entityText(chunk.Content.Text, entity)
+			found[text] = entity.Entity["type"].(string)
+			if id := entity.mentionedID(); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		var mentioned []string
+		if chunk.MentionedInfo != nil {
+			mentioned = chunk.MentionedInfo.UserIdList
+		}
+		if !reflect.DeepEqual(mentioned, ids) {
+			t.Errorf("chunk %d mentionedInfo = %v, want %v", i, mentioned, ids)
+		}
+		if (chunk.Quote != nil) != (i == 0) {
+			t.Errorf("chunk %d has quote: %v", i, chunk.Quote != nil)
+		}
+	}
+	if joined != "hi @userxlink😀@other end" {
+		t.Errorf("joined chunks = %q", joined)
+	}
+	want := map[string]string{
+		"@user":  string(MsgEntityMentionUserType),
+		"link😀":  string(MsgEntityLinkType),
+		"@other": string(MsgEntityMentionUserType),
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("entities = %v, want %v", found, want)
+	}
+}
+
+func TestSplitTextMsgStyle(t *testing.T) {
+	msg, err := NewTextMessage().Bold("aaaaaaaaaaaa").Build(1) // style longer than a chunk is cut
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := splitChunks(t, msg, 5)
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if len(chunk.Content.Entities) != 1 || entityText(chunk.Content.Text, chunk.Content.Entities[0]) != chunk.Content.Text {
+			t.Errorf("chunk %d entities = %+v, want the whole chunk bold", i, chunk.Content.Entities)
+		}
+	}
+}
+
+func TestSplitTextMsgEntityTooLong(t *testing.T) {
+	msg, err := NewTextMessage().Link("https://a.com", "a very long link text", false).Build(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SplitTextMsg(msg, 10); err == nil {
+		t.Error("SplitTextMsg() with an entity longer than max_length returned no error")
+	}
+}
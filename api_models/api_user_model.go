@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"unicode/utf16"
 
 	utils "github.com/GLGDLY/mhy_botsdk/utils"
@@ -198,15 +199,67 @@ func (msg MsgInputModel) SetTextQuote(quoted_message_id string, quoted_message_s
 	return nil
 }
 
+// 设置文本消息的组件面板（按钮等），使用 NewPanel 或 NewTemplatePanel 创建
+func (msg MsgInputModel) SetPanel(panel *MsgPanel) error {
+	if err := panel.Validate(); err != nil {
+		return err
+	}
+	msg["msg_content"].(MsgInputModel)["panel"] = panel
+	return nil
+}
+
 // 设置图片消息内容，接受图片url, 图片宽度, 图片高度, 图片大小 4种类型的参数，宽高单位为像素，图片大小单位为字节，不应超过10M
 func (msg MsgInputModel) SetImage(url string, args ...interface{}) error {
 	// if MsgContentType(msg["object_name"].(MsgContentType)) != MsgTypeImage {
 	// 	return errors.New("消息类型不是图片消息，请使用NewMsg(MsgTypeImage)创建图片消息后SetImage")
 	// }
-	msg["msg_content"].(MsgInputModel)["content"].(MsgInputModel)["url"] = url
+	if len(args) > 3 {
+		return errors.New("SetImage 最多接受图片宽度、图片高度、图片大小3个额外参数")
+	}
+	values := make([]int, len(args))
+	for i, arg := range args {
+		value, ok := imageArgToInt(arg)
+		if !ok || value < 0 {
+			return fmt.Errorf("SetImage 的第%d个额外参数 %v 不是合法的非负整数", i+1, arg)
+		}
+		values[i] = value
+	}
+	if len(values) == 3 && values[2] > MsgImageMaxFileSize {
+		return fmt.Errorf("image too large: %d > %d bytes", values[2], MsgImageMaxFileSize)
+	}
+	content := msg["msg_content"].(MsgInputModel)["content"].(MsgInputModel)
+	content["url"] = url
+	if len(values) >= 2 && values[0] > 0 && values[1] > 0 {
+		content["size"] = MsgInputModel{"width": values[0], "height": values[1]}
+	} else {
+		delete(content, "size")
+	}
+	if len(values) == 3 && values[2] > 0 {
+		content["file_size"] = values[2]
+	} else {
+		delete(content, "file_size")
+	}
 	return nil
 }
 
+func imageArgToInt(arg interface{}) (int, bool) {
+	switch v := arg.(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case uint:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	}
+	return 0, false
+}
+
 func (msg MsgInputModel) SetPost(post_id string) error {
 	// if MsgContentType(msg["object_name"].(MsgContentType)) != MsgTypePost {
 	// 	return errors.New("消息类型不是动态消息，请使用NewMsg(MsgTypePost)创建动态消息后SetPost")
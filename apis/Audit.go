@@ -2,6 +2,7 @@ package apis
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 
@@ -9,8 +10,12 @@ import (
 )
 
 func (api *ApiBase) Audit(villa_id uint64, audit_input models.UserInputAudit) (models.AuditModel, int, error) {
+	return api.AuditCtx(context.Background(), villa_id, audit_input)
+}
+
+func (api *ApiBase) AuditCtx(ctx context.Context, villa_id uint64, audit_input models.UserInputAudit) (models.AuditModel, int, error) {
 	bytesData, _ := json.Marshal(audit_input)
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/audit"), bytes.NewReader(bytesData))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/audit"), bytes.NewReader(bytesData))
 	var resp_data models.AuditModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
@@ -2,50 +2,83 @@ package apis
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
-	"fmt"
+	"errors"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 
 	models "github.com/GLGDLY/mhy_botsdk/api_models"
 )
 
 func (api *ApiBase) UploadImage(villa_id uint64, url string) (models.UploadImageModel, int, error) {
+	return api.UploadImageCtx(context.Background(), villa_id, url)
+}
+
+func (api *ApiBase) UploadImageCtx(ctx context.Context, villa_id uint64, url string) (models.UploadImageModel, int, error) {
 	data := map[string]interface{}{"url": url}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/transferImage"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/transferImage"), api.parseJSON(data))
 	var resp_data models.UploadImageModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
 }
 
+// 上传本地图片，扩展名取自文件路径，没有扩展名时根据文件内容识别
 func (api *ApiBase) UploadFileImage(villa_id uint64, file_path string) (models.UploadFileImageModel, int, error) {
-	var resp_data models.UploadFileImageModel
+	return api.UploadFileImageCtx(context.Background(), villa_id, file_path)
+}
 
-	file, err := os.Open(file_path)
+func (api *ApiBase) UploadFileImageCtx(ctx context.Context, villa_id uint64, file_path string) (models.UploadFileImageModel, int, error) {
+	buf, err := os.ReadFile(file_path)
 	if err != nil {
-		return resp_data, 600, err
+		return models.UploadFileImageModel{}, HttpStatusLocalError, err
 	}
-	defer file.Close()
+	return api.UploadImageBytesCtx(ctx, villa_id, buf, strings.TrimPrefix(filepath.Ext(file_path), "."))
+}
 
-	buf, err := io.ReadAll(file)
+// 读取r的全部内容并上传，ext为图片扩展名（如"png"），为空时根据内容自动识别
+func (api *ApiBase) UploadImageReader(villa_id uint64, r io.Reader, ext string) (models.UploadFileImageModel, int, error) {
+	return api.UploadImageReaderCtx(context.Background(), villa_id, r, ext)
+}
+
+func (api *ApiBase) UploadImageReaderCtx(ctx context.Context, villa_id uint64, r io.Reader, ext string) (models.UploadFileImageModel, int, error) {
+	buf, err := io.ReadAll(r)
 	if err != nil {
-		return resp_data, 600, err
+		return models.UploadFileImageModel{}, HttpStatusLocalError, err
 	}
+	return api.UploadImageBytesCtx(ctx, villa_id, buf, ext)
+}
 
-	file_ext := strings.ToLower(file_path[strings.LastIndex(file_path, ".")+1:])
+// 上传图片内容，ext为图片扩展名（如"png"），为空时根据内容自动识别
+func (api *ApiBase) UploadImageBytes(villa_id uint64, buf []byte, ext string) (models.UploadFileImageModel, int, error) {
+	return api.UploadImageBytesCtx(context.Background(), villa_id, buf, ext)
+}
 
-	h := md5.New()
-	if _, err := h.Write(buf); err != nil {
-		return resp_data, 600, err
+func (api *ApiBase) UploadImageBytesCtx(ctx context.Context, villa_id uint64, buf []byte, ext string) (models.UploadFileImageModel, int, error) {
+	var resp_data models.UploadFileImageModel
+
+	if len(buf) == 0 {
+		return resp_data, HttpStatusLocalError, errors.New("empty image")
+	}
+	file_ext := strings.ToLower(strings.TrimPrefix(ext, "."))
+	if file_ext == "" {
+		info, err := models.DetectImage(buf)
+		if err != nil {
+			return resp_data, HttpStatusLocalError, err
+		}
+		file_ext = info.Ext
 	}
 
-	data := map[string]interface{}{"md5": hex.EncodeToString(h.Sum(nil)), "ext": file_ext}
-	fmt.Println(data["md5"])
-	request, build_req_err := http.NewRequest(http.MethodGet, api.makeURL("/vila/api/bot/platform/getUploadImageParams"), api.parseJSON(data))
+	h := md5.Sum(buf)
+	file_md5 := hex.EncodeToString(h[:])
+
+	data := map[string]interface{}{"md5": file_md5, "ext": file_ext}
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodGet, api.makeURL("/vila/api/bot/platform/getUploadImageParams"), api.parseJSON(data))
 	var param models.GetUploadFileImageParamsModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &param)
 	if err != nil {
@@ -66,28 +99,77 @@ func (api *ApiBase) UploadFileImage(villa_id uint64, file_path string) (models.U
 	multiPartWriter.WriteField("key", param.Data.Params.Key)
 	multiPartWriter.WriteField("policy", param.Data.Params.Policy)
 
-	fileWriter, err := multiPartWriter.CreateFormFile("file", data["md5"].(string)+"."+data["ext"].(string))
+	fileWriter, err := multiPartWriter.CreateFormFile("file", file_md5+"."+file_ext)
 	if err != nil {
-		return resp_data, 600, err
+		return resp_data, HttpStatusLocalError, err
 	}
 
 	_, err = fileWriter.Write(buf)
 	if err != nil {
-		return resp_data, 600, err
+		return resp_data, HttpStatusLocalError, err
 	}
 
 	err = multiPartWriter.Close()
 	if err != nil {
-		return resp_data, 600, err
+		return resp_data, HttpStatusLocalError, err
 	}
 
-	request, build_req_err = http.NewRequest(http.MethodPost, param.Data.Params.Host, &requestBody)
-	if build_req_err != nil {
-		return resp_data, 600, build_req_err
+	request, build_req_err = http.NewRequestWithContext(ctx, http.MethodPost, param.Data.Params.Host, &requestBody)
+	if build_req_err == nil {
+		request.Header.Set("Content-Type", multiPartWriter.FormDataContentType())
 	}
-	request.Header.Set("Content-Type", multiPartWriter.FormDataContentType())
-	fmt.Println(request.Header)
 
-	http_status, err = api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	// OSS不是开放API，请求经过限流器及中间件并附带默认请求头，但不附带x-rpc-bot_*鉴权请求头
+	http_status, err = api.requestHandler(villa_id, request, build_req_err, &resp_data, &callOptions{retry: api.retry_policy, external: true})
 	return resp_data, http_status, err
 }
+
+// 按需上传或转存msg中的图片，使消息中只包含米游社的图片链接
+func (api *ApiBase) prepareImageMessage(ctx context.Context, villa_id uint64, _msg models.MsgBuilder) (int, error) {
+	switch msg := _msg.(type) {
+	case *models.ImageMessage:
+		if msg.Err() != nil {
+			return HttpStatusLocalError, msg.Err()
+		}
+		if data, ext := msg.PendingUpload(); data != nil {
+			resp, http_status, err := api.UploadImageBytesCtx(ctx, villa_id, data, ext)
+			if err != nil {
+				return http_status, err
+			}
+			msg.SetURL(resp.Data.NewURL)
+		} else if msg.URL() != "" && !models.IsVillaImageURL(msg.URL()) {
+			resp, http_status, err := api.UploadImageCtx(ctx, villa_id, msg.URL())
+			if err != nil {
+				return http_status, err
+			}
+			msg.SetURL(resp.Data.NewURL)
+		}
+	case models.MsgInputModel:
+		msg_content, _ := msg["msg_content"].(models.MsgInputModel)
+		content, _ := msg_content["content"].(models.MsgInputModel)
+		switch object_name, _ := msg["object_name"].(models.MsgContentType); object_name {
+		case models.MsgTypeImage:
+			return api.transferImageURL(ctx, villa_id, content)
+		case models.MsgTypeText: // AddTextImage 附带的图片
+			images, _ := content["images"].([]models.MsgInputModel)
+			for _, image := range images {
+				if http_status, err := api.transferImageURL(ctx, villa_id, image); err != nil {
+					return http_status, err
+				}
+			}
+		}
+	}
+	return 200, nil
+}
+
+// image["url"]不是米游社图片时替换为转存后的链接
+func (api *ApiBase) transferImageURL(ctx context.Context, villa_id uint64, image models.MsgInputModel) (int, error) {
+	if url, _ := image["url"].(string); url != "" && !models.IsVillaImageURL(url) {
+		resp, http_status, err := api.UploadImageCtx(ctx, villa_id, url)
+		if err != nil {
+			return http_status, err
+		}
+		image["url"] = resp.Data.NewURL
+	}
+	return 200, nil
+}
@@ -1,39 +1,65 @@
 package apis
 
 import (
+	"context"
 	"net/http"
 
 	models "github.com/GLGDLY/mhy_botsdk/api_models"
 )
 
 func (api *ApiBase) GetMember(villa_id uint64, uid uint64) (models.GetMemberModel, int, error) {
+	return api.GetMemberCtx(context.Background(), villa_id, uid)
+}
+
+func (api *ApiBase) GetMemberCtx(ctx context.Context, villa_id uint64, uid uint64) (models.GetMemberModel, int, error) {
 	query := map[string]interface{}{"uid": uid}
-	request, build_req_err := http.NewRequest(http.MethodGet, api.parseParams(api.makeURL("/vila/api/bot/platform/getMember"), query), nil)
 	var resp_data models.GetMemberModel
+	if api.cacheLoad(cacheKeyMember(villa_id, uid), &resp_data) {
+		return resp_data, http.StatusOK, nil
+	}
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodGet, api.parseParams(api.makeURL("/vila/api/bot/platform/getMember"), query), nil)
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheStore(cacheKeyMember(villa_id, uid), villa_id, resp_data, err)
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) GetVillaMembers(villa_id uint64, offset_str string, size uint64) (models.GetVillaMembersModel, int, error) {
+	return api.GetVillaMembersCtx(context.Background(), villa_id, offset_str, size)
+}
+
+func (api *ApiBase) GetVillaMembersCtx(ctx context.Context, villa_id uint64, offset_str string, size uint64) (models.GetVillaMembersModel, int, error) {
 	query := map[string]interface{}{"offset": offset_str, "size": size}
-	request, build_req_err := http.NewRequest(http.MethodGet, api.parseParams(api.makeURL("/vila/api/bot/platform/getVillaMembers"), query), nil)
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodGet, api.parseParams(api.makeURL("/vila/api/bot/platform/getVillaMembers"), query), nil)
 	var resp_data models.GetVillaMembersModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
 }
 
+// 以最大的size一次性获取成员列表，成员较多时建议使用 IterVillaMembers 分页获取
 func (api *ApiBase) GetVillaMembersDefault(villa_id uint64) (models.GetVillaMembersModel, int, error) {
+	return api.GetVillaMembersDefaultCtx(context.Background(), villa_id)
+}
+
+func (api *ApiBase) GetVillaMembersDefaultCtx(ctx context.Context, villa_id uint64) (models.GetVillaMembersModel, int, error) {
 	query := map[string]interface{}{"offset": "", "size": "18446744073709551615"} // MaxUint64
-	request, build_req_err := http.NewRequest(http.MethodGet, api.parseParams(api.makeURL("/vila/api/bot/platform/getVillaMembers"), query), nil)
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodGet, api.parseParams(api.makeURL("/vila/api/bot/platform/getVillaMembers"), query), nil)
 	var resp_data models.GetVillaMembersModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) DeleteVillaMember(villa_id uint64, uid uint64) (models.EmptyModel, int, error) {
+	return api.DeleteVillaMemberCtx(context.Background(), villa_id, uid)
+}
+
+func (api *ApiBase) DeleteVillaMemberCtx(ctx context.Context, villa_id uint64, uid uint64) (models.EmptyModel, int, error) {
 	data := map[string]interface{}{"uid": uid}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/deleteVillaMember"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/deleteVillaMember"), api.parseJSON(data))
 	var resp_data models.EmptyModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheInvalidate(func(c *Cache) {
+		c.InvalidateMember(villa_id, uid)
+		c.InvalidateVillaInfo(villa_id)
+	})
 	return resp_data, http_status, err
 }
@@ -1,14 +1,19 @@
 package apis
 
 import (
+	"context"
 	"net/http"
 
 	models "github.com/GLGDLY/mhy_botsdk/api_models"
 )
 
 func (api *ApiBase) CheckMemberBotAccessToken(villa_id uint64, token string) (models.CheckMemberBotAccessTokenModel, int, error) {
+	return api.CheckMemberBotAccessTokenCtx(context.Background(), villa_id, token)
+}
+
+func (api *ApiBase) CheckMemberBotAccessTokenCtx(ctx context.Context, villa_id uint64, token string) (models.CheckMemberBotAccessTokenModel, int, error) {
 	data := map[string]interface{}{"token": token}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/checkMemberBotAccessToken"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/checkMemberBotAccessToken"), api.parseJSON(data))
 	var resp_data models.CheckMemberBotAccessTokenModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
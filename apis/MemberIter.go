@@ -0,0 +1,115 @@
+package apis
+
+import (
+	"context"
+
+	models "github.com/GLGDLY/mhy_botsdk/api_models"
+)
+
+const default_members_page_size uint64 = 100
+
+/* 别野成员的分页迭代器，会自动跟随 next_offset_str 获取下一页，直到没有更多成员为止
+ *
+ *	iter := api.IterVillaMembers(villa_id, 50)
+ *	defer iter.Close()
+ *	for iter.Next() {
+ *		member := iter.Member()
+ *	}
+ *	if err := iter.Err(); err != nil {}
+ *
+ * 每一页均通过 GetVillaMembersCtx 请求，因此同样受 SetRetryPolicy、SetRateLimiter 的重试及限流控制 */
+type VillaMembersIterator struct {
+	api         *ApiBase
+	ctx         context.Context
+	villa_id    uint64
+	page_size   uint64
+	offset_str  string
+	seen        map[string]bool // 已请求过的offset，避免无限循环
+	page        []models.MemberModel
+	index       int
+	current     models.MemberModel
+	http_status int
+	err         error
+	done        bool
+}
+
+// 创建别野成员的迭代器，page_size为每页的数量，为0时使用默认值100
+func (api *ApiBase) IterVillaMembers(villa_id uint64, page_size uint64) *VillaMembersIterator {
+	return api.IterVillaMembersCtx(context.Background(), villa_id, page_size)
+}
+
+func (api *ApiBase) IterVillaMembersCtx(ctx context.Context, villa_id uint64, page_size uint64) *VillaMembersIterator {
+	if page_size == 0 {
+		page_size = default_members_page_size
+	}
+	return &VillaMembersIterator{api: api, ctx: ctx, villa_id: villa_id, page_size: page_size, seen: make(map[string]bool)}
+}
+
+// 移动到下一个成员，没有更多成员、出错或已Close时返回false
+func (iter *VillaMembersIterator) Next() bool {
+	for iter.index >= len(iter.page) {
+		if iter.done {
+			return false
+		}
+		iter.fetch()
+	}
+	iter.current = iter.page[iter.index]
+	iter.index++
+	return true
+}
+
+func (iter *VillaMembersIterator) fetch() {
+	if err := iter.ctx.Err(); err != nil {
+		iter.err, iter.done = err, true
+		return
+	}
+	iter.seen[iter.offset_str] = true
+	resp, http_status, err := iter.api.GetVillaMembersCtx(iter.ctx, iter.villa_id, iter.offset_str, iter.page_size)
+	iter.http_status = http_status
+	if err != nil {
+		iter.err, iter.done = err, true
+		return
+	}
+	iter.page, iter.index = resp.Data.List, 0
+	iter.offset_str = resp.Data.NextOffsetStr
+	if len(resp.Data.List) == 0 || iter.offset_str == "" || iter.seen[iter.offset_str] {
+		iter.done = true
+	}
+}
+
+// 当前的成员，需在 Next() 返回true后调用
+func (iter *VillaMembersIterator) Member() models.MemberModel {
+	return iter.current
+}
+
+// 迭代过程中发生的错误，正常结束或提前Close时为nil
+func (iter *VillaMembersIterator) Err() error {
+	return iter.err
+}
+
+// 最后一次请求的http状态码
+func (iter *VillaMembersIterator) HttpStatus() int {
+	return iter.http_status
+}
+
+// 提前结束迭代，之后 Next() 将返回false，不会再发出请求
+func (iter *VillaMembersIterator) Close() {
+	iter.done = true
+	iter.page, iter.index = nil, 0
+}
+
+// 以回调的形式遍历别野的所有成员，callback返回false时提前结束
+func (api *ApiBase) ForEachVillaMember(villa_id uint64, page_size uint64, callback func(member models.MemberModel) bool) error {
+	return api.ForEachVillaMemberCtx(context.Background(), villa_id, page_size, callback)
+}
+
+func (api *ApiBase) ForEachVillaMemberCtx(ctx context.Context, villa_id uint64, page_size uint64, callback func(member models.MemberModel) bool) error {
+	iter := api.IterVillaMembersCtx(ctx, villa_id, page_size)
+	defer iter.Close()
+	for iter.Next() {
+		if !callback(iter.Member()) {
+			break
+		}
+	}
+	return iter.Err()
+}
@@ -1,63 +1,95 @@
 package apis
 
 import (
+	"context"
 	"net/http"
 
 	models "github.com/GLGDLY/mhy_botsdk/api_models"
 )
 
 func (api *ApiBase) PinMessage(villa_id uint64, msg_uid string, is_cancel bool, room_id uint64, send_at int64) (models.EmptyModel, int, error) {
+	return api.PinMessageCtx(context.Background(), villa_id, msg_uid, is_cancel, room_id, send_at)
+}
+
+func (api *ApiBase) PinMessageCtx(ctx context.Context, villa_id uint64, msg_uid string, is_cancel bool, room_id uint64, send_at int64) (models.EmptyModel, int, error) {
 	data := map[string]interface{}{"msg_uid": msg_uid, "is_cancel": is_cancel, "room_id": room_id, "send_at": send_at}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/pinMessage"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/pinMessage"), api.parseJSON(data))
 	var resp_data models.EmptyModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) RecallMessage(villa_id uint64, msg_uid string, room_id uint64, msg_time int64) (models.EmptyModel, int, error) {
+	return api.RecallMessageCtx(context.Background(), villa_id, msg_uid, room_id, msg_time)
+}
+
+func (api *ApiBase) RecallMessageCtx(ctx context.Context, villa_id uint64, msg_uid string, room_id uint64, msg_time int64) (models.EmptyModel, int, error) {
 	data := map[string]interface{}{"msg_uid": msg_uid, "room_id": room_id, "msg_time": msg_time}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/recallMessage"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/recallMessage"), api.parseJSON(data))
 	var resp_data models.EmptyModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
 }
 
-// func (api *ApiBase) uploadImageForMessage(villa_id uint64, _msg *models.MsgInputModel) (int, error) {
-// 	url := (*_msg)["msg_content"].(models.MsgInputModel)["content"].(models.MsgInputModel)["image_uri"].(string)
-// 	resp_data, http_status, err := api.UploadImage(villa_id, url)
-// 	if err != nil {
-// 		return http_status, err
-// 	}
-// 	if http_status != 200 {
-// 		return http_status, nil
-// 	}
-// 	(*_msg)["msg_content"].(models.MsgInputModel)["content"].(models.MsgInputModel)["image_uri"] = resp_data.Data.NewURL
-// 	return http_status, nil
-// }
-
 // 使用models.NewMsg创建消息，然后使用models.SetText等方法加入内容，最后使用此函数发送
-func (api *ApiBase) SendMessageCustomize(villa_id uint64, room_id uint64, _msg models.MsgInputModel) (models.SendMessageModel, int, error) {
-	// if models.MsgContentType(_msg["object_name"].(models.MsgContentType)) == models.MsgTypeImage {
-	// 	http_status, err := api.uploadImageForMessage(villa_id, &_msg)
-	// 	if err != nil || http_status != 200 {
-	// 		return models.SendMessageModel{}, http_status, err
-	// 	}
-	// }
-	msg := _msg.Finialize(room_id)
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/sendMessage"), api.parseJSON(msg))
+// 也可传入models.NewTextMessage、NewImageMessage、NewPostMessage创建的强类型消息
+// 图片消息中非米游社的图片地址会先通过 UploadImage 转存，NewImageMessageFromBytes 等创建的本地图片会先上传
+func (api *ApiBase) SendMessageCustomize(villa_id uint64, room_id uint64, _msg models.MsgBuilder) (models.SendMessageModel, int, error) {
+	return api.SendMessageCustomizeCtx(context.Background(), villa_id, room_id, _msg)
+}
+
+// 启用发送队列时，ctx在消息开始发送前结束会将消息移出队列，开始发送后结束则中止正在进行的请求
+func (api *ApiBase) SendMessageCustomizeCtx(ctx context.Context, villa_id uint64, room_id uint64, _msg models.MsgBuilder) (models.SendMessageModel, int, error) {
+	if http_status, err := api.prepareImageMessage(ctx, villa_id, _msg); err != nil {
+		return models.SendMessageModel{}, http_status, err
+	}
+	msg, err := _msg.Build(room_id)
+	if err != nil {
+		return models.SendMessageModel{}, HttpStatusLocalError, err
+	}
+	if api.outbound_queue != nil {
+		return api.outbound_queue.sendWait(ctx, villa_id, room_id, msg)
+	}
+	return api.sendChunks(ctx, villa_id, msg, api.sendBuiltMessage)
+}
+
+func (api *ApiBase) sendBuiltMessage(ctx context.Context, villa_id uint64, msg models.MsgInputModel) (models.SendMessageModel, int, error) {
+	return api.sendBuiltMessageWith(ctx, villa_id, msg, nil)
+}
+
+func (api *ApiBase) sendBuiltMessageWith(ctx context.Context, villa_id uint64, msg models.MsgInputModel, options *callOptions) (models.SendMessageModel, int, error) {
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/sendMessage"), api.parseJSON(msg))
 	var resp_data models.SendMessageModel
-	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	http_status, err := api.requestHandler(villa_id, request, build_req_err, &resp_data, options)
 	return resp_data, http_status, err
 }
 
+// 发送Markdown格式的文本消息，支持粗体、斜体、删除线、<u>下划线</u>、[链接](url)、@用户id、#房间id等，完整语法见 api_models.ParseMarkdown
+func (api *ApiBase) SendMarkdown(villa_id uint64, room_id uint64, markdown string) (models.SendMessageModel, int, error) {
+	return api.SendMarkdownCtx(context.Background(), villa_id, room_id, markdown)
+}
+
+func (api *ApiBase) SendMarkdownCtx(ctx context.Context, villa_id uint64, room_id uint64, markdown string) (models.SendMessageModel, int, error) {
+	msg, _ := models.NewMsg(models.MsgTypeText)
+	if err := api.MarkdownParserCtx(ctx, &msg, villa_id, markdown); err != nil {
+		return models.SendMessageModel{}, HttpStatusLocalError, err
+	}
+	return api.SendMessageCustomizeCtx(ctx, villa_id, room_id, msg)
+}
+
 // 使用内嵌格式发送消息，并自动处理内部Entity（<@xxx>为艾特机器人或用户，<@everyone>为艾特全体，<#xxx>为跳转房间，<$xxx>为跳转连接）
 // 艾特用户会自动获取用户昵称，跳转房间会自动获取房间名称；艾特机器人会显示文字“机器人”，艾特全体会显示“全体成员”，跳转连接会显示链接自身
+// 另支持<$url|文字>自定义链接文字、<$$url>附带bot_member_access_token的链接、<!url>附带图片，以及<b>粗体</b>、<i>斜体</i>、<s>删除线</s>、<u>下划线</u>，完整语法见 api_models.ParseMarkup
 // 使用\< 和 \> 可转义 < 和 >，不会被解析为Entity
 func (api *ApiBase) SendMessage(villa_id uint64, room_id uint64, _msg_parts ...string) (models.SendMessageModel, int, error) {
+	return api.SendMessageCtx(context.Background(), villa_id, room_id, _msg_parts...)
+}
+
+func (api *ApiBase) SendMessageCtx(ctx context.Context, villa_id uint64, room_id uint64, _msg_parts ...string) (models.SendMessageModel, int, error) {
 	msg, _ := models.NewMsg(models.MsgTypeText)
-	err := api.MessageParser(&msg, villa_id, _msg_parts...)
+	err := api.MessageParserCtx(ctx, &msg, villa_id, _msg_parts...)
 	if err != nil {
-		return models.SendMessageModel{}, 600, err
+		return models.SendMessageModel{}, HttpStatusLocalError, err
 	}
-	return api.SendMessageCustomize(villa_id, room_id, msg)
+	return api.SendMessageCustomizeCtx(ctx, villa_id, room_id, msg)
 }
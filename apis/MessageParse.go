@@ -1,156 +1,73 @@
 package apis
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"strconv"
-	"strings"
 
 	models "github.com/GLGDLY/mhy_botsdk/api_models"
 )
 
 func (api *ApiBase) MessageParser(msg *models.MsgInputModel, villa_id uint64, _msg_parts ...string) error {
-	/* for parsing */
-	msg_buf := bytes.NewBufferString("")
-	is_entity := false
-	var entity_type *models.MsgEntityType = nil
+	return api.MessageParserCtx(context.Background(), msg, villa_id, _msg_parts...)
+}
+
+func (api *ApiBase) MessageParserCtx(ctx context.Context, msg *models.MsgInputModel, villa_id uint64, _msg_parts ...string) error {
+	tokens, err := models.ParseMarkup(_msg_parts...)
+	if err != nil {
+		return err
+	}
+	if err := api.resolveMarkupTokens(ctx, villa_id, tokens); err != nil {
+		return err
+	}
+	return msg.AppendMarkup(villa_id, tokens)
+}
+
+// 将Markdown（支持的子集见 api_models.ParseMarkdown）转换为entity并追加到文本消息中，艾特用户及跳转房间会自动获取昵称及房间名称
+func (api *ApiBase) MarkdownParser(msg *models.MsgInputModel, villa_id uint64, markdown string) error {
+	return api.MarkdownParserCtx(context.Background(), msg, villa_id, markdown)
+}
+
+func (api *ApiBase) MarkdownParserCtx(ctx context.Context, msg *models.MsgInputModel, villa_id uint64, markdown string) error {
+	tokens := models.ParseMarkdown(markdown)
+	if err := api.resolveMarkupTokens(ctx, villa_id, tokens); err != nil {
+		return err
+	}
+	return msg.AppendMarkup(villa_id, tokens)
+}
+
+// 填充艾特用户及房间链接的显示文字
+func (api *ApiBase) resolveMarkupTokens(ctx context.Context, villa_id uint64, tokens []models.MarkupToken) error {
 	/* for appending entity cache */
 	usernames := make(map[uint64]string)
 	roomnames := make(map[uint64]string)
 
-	skip_next_word := false
-	for i, msg_part := range _msg_parts {
-		for j, word := range msg_part {
-			// check for escape
-			if skip_next_word {
-				skip_next_word = false
-				msg_buf.WriteRune(word)
-				continue
-			}
-			if word == '\\' {
-				skip_next_word = true
-				continue
-			}
-			// check for entity
-			if word == '<' {
-				if is_entity {
-					return fmt.Errorf(`invalid format, unexcepted "<" in position %d on msg arg %d`, j, i)
-				}
-				is_entity = true
-				err := msg.AppendText(msg_buf.String())
+	for i := range tokens {
+		switch tokens[i].Type {
+		case models.MarkupMentionUser:
+			uid := tokens[i].UserID
+			username, ok := usernames[uid]
+			if !ok {
+				resp, _, err := api.GetMemberCtx(ctx, villa_id, uid)
 				if err != nil {
-					return err
-				}
-				msg_buf.Reset()
-				entity_type = nil
-			} else if word == '>' {
-				if !is_entity {
-					return fmt.Errorf(`invalid format, unexcepted ">" in position %d on msg arg %d`, j, i)
-				}
-				entity_content := msg_buf.String()
-				switch *entity_type {
-				case models.MsgEntityMentionUserType:
-					if entity_content == "everyone" {
-						err := msg.AppendText(models.MsgEntityMentionAll{
-							Text: "@全体成员",
-						})
-						if err != nil {
-							return err
-						}
-						break
-					} else if strings.HasPrefix(entity_content, "bot_") {
-						err := msg.AppendText(models.MsgEntityMentionRobot{
-							Text:  "@机器人",
-							BotID: entity_content,
-						})
-						if err != nil {
-							return err
-						}
-						break
-					}
-					uid, error := strconv.ParseUint(entity_content, 10, 64)
-					if error != nil {
-						return fmt.Errorf(`invalid format, invalid user id "%s" in position %d on msg arg %d`, entity_content, j, i)
-					}
-					username, ok := usernames[uid]
-					if !ok {
-						resp, http, err := api.GetMember(villa_id, uid)
-						if err != nil {
-							return err
-						}
-						if http != 200 || resp.Retcode != 0 {
-							return fmt.Errorf(`failed to fetch user info, http code: %d, retcode: %d`, http, resp.Retcode)
-						}
-						username = resp.Data.Member.Basic.Nickname
-						usernames[uid] = username
-					}
-					err := msg.AppendText(models.MsgEntityMentionUser{
-						Text:   "@" + username,
-						UserID: uid,
-					})
-					if err != nil {
-						return err
-					}
-				case models.MsgEntityVillaRoomLinkType:
-					room_id, error := strconv.ParseUint(entity_content, 10, 64)
-					if error != nil {
-						return fmt.Errorf(`invalid format, invalid room id "%s" in position %d on msg arg %d`, entity_content, j, i)
-					}
-					roomname, ok := roomnames[room_id]
-					if !ok {
-						resp, http, err := api.GetRoom(villa_id, room_id)
-						if err != nil {
-							return err
-						}
-						if http != 200 || resp.Retcode != 0 {
-							return fmt.Errorf(`failed to fetch room info, http code: %d, retcode: %d`, http, resp.Retcode)
-						}
-						roomname = resp.Data.Room.RoomName
-						roomnames[room_id] = roomname
-					}
-					err := msg.AppendText(models.MsgEntityVillaRoomLink{
-						Text:    "#" + roomname,
-						VillaID: villa_id,
-						RoomID:  room_id,
-					})
-					if err != nil {
-						return err
-					}
-				case models.MsgEntityLinkType:
-					err := msg.AppendText(models.MsgEntityLink{
-						URL:                    entity_content,
-						RequiresBotAccessToken: false,
-					})
-					if err != nil {
-						return err
-					}
+					return fmt.Errorf(`failed to fetch user info: %w`, err)
 				}
-				is_entity = false
-				msg_buf.Reset()
-			} else {
-				if is_entity && entity_type == nil {
-					var _entity_type models.MsgEntityType
-					switch word {
-					case '@':
-						_entity_type = models.MsgEntityMentionUserType
-					case '#':
-						_entity_type = models.MsgEntityVillaRoomLinkType
-					case '$':
-						_entity_type = models.MsgEntityLinkType
-					}
-					entity_type = &_entity_type
-				} else {
-					msg_buf.WriteRune(word)
+				username = resp.Data.Member.Basic.Nickname
+				usernames[uid] = username
+			}
+			tokens[i].Text = "@" + username
+		case models.MarkupRoomLink:
+			room_id := tokens[i].RoomID
+			roomname, ok := roomnames[room_id]
+			if !ok {
+				resp, _, err := api.GetRoomCtx(ctx, villa_id, room_id)
+				if err != nil {
+					return fmt.Errorf(`failed to fetch room info: %w`, err)
 				}
+				roomname = resp.Data.Room.RoomName
+				roomnames[room_id] = roomname
 			}
+			tokens[i].Text = "#" + roomname
 		}
 	}
-	if is_entity {
-		return fmt.Errorf(`invalid format, unexcepted EOF till last msg arg (start with "<" but not end with ">")`)
-	}
-	err := msg.AppendText(msg_buf.String())
-	if err != nil {
-		return err
-	}
 	return nil
 }
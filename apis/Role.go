@@ -1,55 +1,97 @@
 package apis
 
 import (
+	"context"
 	"net/http"
 
 	models "github.com/GLGDLY/mhy_botsdk/api_models"
 )
 
 func (api *ApiBase) OperateMemberToRole(villa_id uint64, role_id, uid uint64, is_add bool) (models.EmptyModel, int, error) {
+	return api.OperateMemberToRoleCtx(context.Background(), villa_id, role_id, uid, is_add)
+}
+
+func (api *ApiBase) OperateMemberToRoleCtx(ctx context.Context, villa_id uint64, role_id, uid uint64, is_add bool) (models.EmptyModel, int, error) {
 	data := map[string]interface{}{"role_id": role_id, "uid": uid, "is_add": is_add}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/operateMemberToRole"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/operateMemberToRole"), api.parseJSON(data))
 	var resp_data models.EmptyModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheInvalidate(func(c *Cache) {
+		c.InvalidateMember(villa_id, uid)
+		c.InvalidateRoles(villa_id)
+	})
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) CreateMemberRole(villa_id uint64, name, color string, permissions []string) (models.CreateRoleModel, int, error) {
+	return api.CreateMemberRoleCtx(context.Background(), villa_id, name, color, permissions)
+}
+
+func (api *ApiBase) CreateMemberRoleCtx(ctx context.Context, villa_id uint64, name, color string, permissions []string) (models.CreateRoleModel, int, error) {
 	data := map[string]interface{}{"name": name, "color": color, "permissions": permissions}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/createMemberRole"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/createMemberRole"), api.parseJSON(data))
 	var resp_data models.CreateRoleModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheInvalidate(func(c *Cache) {
+		c.InvalidateRoles(villa_id)
+	})
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) EditMemberRole(villa_id uint64, id uint64, name, color string, permissions []string) (models.EmptyModel, int, error) {
+	return api.EditMemberRoleCtx(context.Background(), villa_id, id, name, color, permissions)
+}
+
+func (api *ApiBase) EditMemberRoleCtx(ctx context.Context, villa_id uint64, id uint64, name, color string, permissions []string) (models.EmptyModel, int, error) {
 	data := map[string]interface{}{"id": id, "name": name, "color": color, "permissions": permissions}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/editMemberRole"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/editMemberRole"), api.parseJSON(data))
 	var resp_data models.EmptyModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheInvalidate(func(c *Cache) {
+		c.InvalidateVilla(villa_id) // 成员及房间信息中包含身份组信息
+	})
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) DeleteMemberRole(villa_id uint64, id uint64) (models.EmptyModel, int, error) {
+	return api.DeleteMemberRoleCtx(context.Background(), villa_id, id)
+}
+
+func (api *ApiBase) DeleteMemberRoleCtx(ctx context.Context, villa_id uint64, id uint64) (models.EmptyModel, int, error) {
 	data := map[string]interface{}{"id": id}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/deleteMemberRole"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/deleteMemberRole"), api.parseJSON(data))
 	var resp_data models.EmptyModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheInvalidate(func(c *Cache) {
+		c.InvalidateVilla(villa_id) // 成员及房间信息中包含身份组信息
+	})
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) GetMemberRoleInfo(villa_id uint64, role_id uint64) (models.GetRoleInfoModel, int, error) {
+	return api.GetMemberRoleInfoCtx(context.Background(), villa_id, role_id)
+}
+
+func (api *ApiBase) GetMemberRoleInfoCtx(ctx context.Context, villa_id uint64, role_id uint64) (models.GetRoleInfoModel, int, error) {
 	data := map[string]interface{}{"role_id": role_id}
-	request, build_req_err := http.NewRequest(http.MethodGet, api.makeURL("/vila/api/bot/platform/getMemberRoleInfo"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodGet, api.makeURL("/vila/api/bot/platform/getMemberRoleInfo"), api.parseJSON(data))
 	var resp_data models.GetRoleInfoModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) GetVillaMemberRoles(villa_id uint64) (models.GetVillaMemberRolesModel, int, error) {
+	return api.GetVillaMemberRolesCtx(context.Background(), villa_id)
+}
+
+func (api *ApiBase) GetVillaMemberRolesCtx(ctx context.Context, villa_id uint64) (models.GetVillaMemberRolesModel, int, error) {
 	data := map[string]interface{}{}
-	request, build_req_err := http.NewRequest(http.MethodGet, api.makeURL("/vila/api/bot/platform/getVillaMemberRoles"), api.parseJSON(data))
 	var resp_data models.GetVillaMemberRolesModel
+	if api.cacheLoad(cacheKeyRoles(villa_id), &resp_data) {
+		return resp_data, http.StatusOK, nil
+	}
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodGet, api.makeURL("/vila/api/bot/platform/getVillaMemberRoles"), api.parseJSON(data))
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheStore(cacheKeyRoles(villa_id), villa_id, resp_data, err)
 	return resp_data, http_status, err
 }
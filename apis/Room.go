@@ -1,70 +1,116 @@
 package apis
 
 import (
+	"context"
 	"net/http"
 
 	models "github.com/GLGDLY/mhy_botsdk/api_models"
 )
 
 func (api *ApiBase) CreateGroup(villa_id uint64, group_name string) (models.CreateRoomModel, int, error) {
+	return api.CreateGroupCtx(context.Background(), villa_id, group_name)
+}
+
+func (api *ApiBase) CreateGroupCtx(ctx context.Context, villa_id uint64, group_name string) (models.CreateRoomModel, int, error) {
 	data := map[string]interface{}{"group_name": group_name}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/createGroup"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/createGroup"), api.parseJSON(data))
 	var resp_data models.CreateRoomModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) EditGroup(villa_id uint64, group_id uint64, group_name string) (models.EmptyModel, int, error) {
+	return api.EditGroupCtx(context.Background(), villa_id, group_id, group_name)
+}
+
+func (api *ApiBase) EditGroupCtx(ctx context.Context, villa_id uint64, group_id uint64, group_name string) (models.EmptyModel, int, error) {
 	data := map[string]interface{}{"group_id": group_id, "group_name": group_name}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/editGroup"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/editGroup"), api.parseJSON(data))
 	var resp_data models.EmptyModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) DeleteGroup(villa_id uint64, group_id uint64) (models.EmptyModel, int, error) {
+	return api.DeleteGroupCtx(context.Background(), villa_id, group_id)
+}
+
+func (api *ApiBase) DeleteGroupCtx(ctx context.Context, villa_id uint64, group_id uint64) (models.EmptyModel, int, error) {
 	data := map[string]interface{}{"group_id": group_id}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/deleteGroup"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/deleteGroup"), api.parseJSON(data))
 	var resp_data models.EmptyModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheInvalidate(func(c *Cache) {
+		c.removeVilla(villa_id, "room:") // 分组的房间一并移除
+	})
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) GetGroupList(villa_id uint64) (models.GetGroupListModel, int, error) {
+	return api.GetGroupListCtx(context.Background(), villa_id)
+}
+
+func (api *ApiBase) GetGroupListCtx(ctx context.Context, villa_id uint64) (models.GetGroupListModel, int, error) {
 	data := map[string]interface{}{}
-	request, build_req_err := http.NewRequest(http.MethodGet, api.makeURL("/vila/api/bot/platform/getGroupList"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodGet, api.makeURL("/vila/api/bot/platform/getGroupList"), api.parseJSON(data))
 	var resp_data models.GetGroupListModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) EditRoom(villa_id uint64, room_id uint64, room_name string) (models.EmptyModel, int, error) {
+	return api.EditRoomCtx(context.Background(), villa_id, room_id, room_name)
+}
+
+func (api *ApiBase) EditRoomCtx(ctx context.Context, villa_id uint64, room_id uint64, room_name string) (models.EmptyModel, int, error) {
 	data := map[string]interface{}{"room_id": room_id, "room_name": room_name}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/editRoom"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/editRoom"), api.parseJSON(data))
 	var resp_data models.EmptyModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheInvalidate(func(c *Cache) {
+		c.InvalidateRoom(villa_id, room_id)
+	})
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) DeleteRoom(villa_id uint64, room_id uint64) (models.EmptyModel, int, error) {
+	return api.DeleteRoomCtx(context.Background(), villa_id, room_id)
+}
+
+func (api *ApiBase) DeleteRoomCtx(ctx context.Context, villa_id uint64, room_id uint64) (models.EmptyModel, int, error) {
 	data := map[string]interface{}{"room_id": room_id}
-	request, build_req_err := http.NewRequest(http.MethodPost, api.makeURL("/vila/api/bot/platform/deleteRoom"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodPost, api.makeURL("/vila/api/bot/platform/deleteRoom"), api.parseJSON(data))
 	var resp_data models.EmptyModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheInvalidate(func(c *Cache) {
+		c.InvalidateRoom(villa_id, room_id)
+	})
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) GetRoom(villa_id uint64, room_id uint64) (models.GetRoomModel, int, error) {
+	return api.GetRoomCtx(context.Background(), villa_id, room_id)
+}
+
+func (api *ApiBase) GetRoomCtx(ctx context.Context, villa_id uint64, room_id uint64) (models.GetRoomModel, int, error) {
 	data := map[string]interface{}{"room_id": room_id}
-	request, build_req_err := http.NewRequest(http.MethodGet, api.makeURL("/vila/api/bot/platform/getRoom"), api.parseJSON(data))
 	var resp_data models.GetRoomModel
+	if api.cacheLoad(cacheKeyRoom(villa_id, room_id), &resp_data) {
+		return resp_data, http.StatusOK, nil
+	}
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodGet, api.makeURL("/vila/api/bot/platform/getRoom"), api.parseJSON(data))
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheStore(cacheKeyRoom(villa_id, room_id), villa_id, resp_data, err)
 	return resp_data, http_status, err
 }
 
 func (api *ApiBase) GetVillaGroupRoomList(villa_id uint64) (models.GetVillaGroupRoomListModel, int, error) {
+	return api.GetVillaGroupRoomListCtx(context.Background(), villa_id)
+}
+
+func (api *ApiBase) GetVillaGroupRoomListCtx(ctx context.Context, villa_id uint64) (models.GetVillaGroupRoomListModel, int, error) {
 	data := map[string]interface{}{}
-	request, build_req_err := http.NewRequest(http.MethodGet, api.makeURL("/vila/api/bot/platform/getVillaGroupRoomList"), api.parseJSON(data))
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodGet, api.makeURL("/vila/api/bot/platform/getVillaGroupRoomList"), api.parseJSON(data))
 	var resp_data models.GetVillaGroupRoomListModel
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
 	return resp_data, http_status, err
@@ -1,14 +1,23 @@
 package apis
 
 import (
+	"context"
 	"net/http"
 
 	models "github.com/GLGDLY/mhy_botsdk/api_models"
 )
 
 func (api *ApiBase) GetVilla(villa_id uint64) (models.GetVillaModel, int, error) {
-	request, build_req_err := http.NewRequest(http.MethodGet, api.makeURL("/vila/api/bot/platform/getVilla"), nil)
+	return api.GetVillaCtx(context.Background(), villa_id)
+}
+
+func (api *ApiBase) GetVillaCtx(ctx context.Context, villa_id uint64) (models.GetVillaModel, int, error) {
 	var resp_data models.GetVillaModel
+	if api.cacheLoad(cacheKeyVilla(villa_id), &resp_data) {
+		return resp_data, http.StatusOK, nil
+	}
+	request, build_req_err := http.NewRequestWithContext(ctx, http.MethodGet, api.makeURL("/vila/api/bot/platform/getVilla"), nil)
 	http_status, err := api.RequestHandler(villa_id, request, build_req_err, &resp_data)
+	api.cacheStore(cacheKeyVilla(villa_id), villa_id, resp_data, err)
 	return resp_data, http_status, err
 }
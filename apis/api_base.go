@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	base "github.com/GLGDLY/mhy_botsdk"
@@ -17,10 +19,23 @@ import (
 )
 
 const open_api_url string = "https://bbs-api.miyoushe.com"
+const api_path_prefix string = "/vila/api/bot/platform/"
+
+// 所有API方法均有对应的 XxxCtx(ctx, ...) 版本，ctx会传递到底层的http请求中，用于取消请求或设置截止时间；
+// 不带Ctx的版本等同于使用 context.Background() 调用，仅受 SetTimeout 设置的超时时间限制
 
 type ApiBase struct {
-	Base    models.BotBase
-	session http.Client
+	Base            models.BotBase
+	session         http.Client
+	base_url        string              // 开放API的根地址，默认为open_api_url
+	default_headers http.Header         // 每个请求都会附带的额外请求头
+	retry_policy    *RetryPolicy        // 请求失败时的重试策略，nil为不重试
+	rate_limiter    *RateLimiter        // 客户端限流器，nil为不限流
+	cache           *Cache              // 成员、房间等信息的缓存，nil为不缓存
+	middlewares     []Middleware        // 请求中间件，按添加顺序由外到内执行，添加时替换为新的切片
+	middlewares_mu  sync.RWMutex        // 保护middlewares
+	split_policy    *MessageSplitPolicy // 超长文本消息的拆分策略，nil为不拆分
+	outbound_queue  *OutboundQueue      // 发送消息的队列，nil为直接发送
 }
 
 func MakeAPIBase(base models.BotBase, timeout time.Duration) *ApiBase {
@@ -29,6 +44,8 @@ func MakeAPIBase(base models.BotBase, timeout time.Duration) *ApiBase {
 		session: http.Client{
 			Timeout: timeout,
 		},
+		base_url:        open_api_url,
+		default_headers: http.Header{},
 	}
 }
 
@@ -36,8 +53,34 @@ func (api *ApiBase) SetTimeout(timeout time.Duration) {
 	api.session.Timeout = timeout
 }
 
+// 设置开放API的根地址（如本地测试服务器"http://127.0.0.1:8080"），传入空字符串则恢复默认地址
+func (api *ApiBase) SetBaseURL(base_url string) {
+	if base_url == "" {
+		base_url = open_api_url
+	}
+	api.base_url = strings.TrimRight(base_url, "/")
+}
+
+func (api *ApiBase) GetBaseURL() string {
+	return api.base_url
+}
+
+// 设置发送请求所使用的http.RoundTripper（如内部出口网关或测试用的mock），传入nil则使用http.DefaultTransport
+func (api *ApiBase) SetTransport(transport http.RoundTripper) {
+	api.session.Transport = transport
+}
+
+// 设置每个请求都会附带的请求头，不会覆盖SDK用于鉴权的x-rpc-bot_*请求头；value为空字符串时移除该请求头
+func (api *ApiBase) SetDefaultHeader(key, value string) {
+	if value == "" {
+		api.default_headers.Del(key)
+		return
+	}
+	api.default_headers.Set(key, value)
+}
+
 func (api *ApiBase) makeURL(path string) string {
-	return open_api_url + path
+	return api.base_url + path
 }
 
 func (api *ApiBase) parseParams(raw_url string, params map[string]interface{}) string {
@@ -56,44 +99,176 @@ func (api *ApiBase) parseJSON(json_map map[string]interface{}) *bytes.Reader {
 }
 
 func (api *ApiBase) RequestHandler(villa_id uint64, request *http.Request, build_req_err error, resp_data interface{}) (int, error) {
+	return api.requestHandler(villa_id, request, build_req_err, resp_data, nil)
+}
+
+// 单次调用的设置，用于替换ApiBase的设置，供自行重试的发送队列及OSS上传使用
+type callOptions struct {
+	retry    *RetryPolicy // 替换ApiBase.retry_policy，nil为不重试
+	limiter  *RateLimiter // 每次尝试前等待的限流器，ApiBase的限流器仍然生效
+	attempts int          // 已尝试的次数
+	external bool         // 请求的不是开放API（如OSS上传），不附带x-rpc-bot_*鉴权请求头
+}
+
+func (api *ApiBase) requestHandler(villa_id uint64, request *http.Request, build_req_err error, resp_data interface{}, options *callOptions) (int, error) {
 	if build_req_err != nil {
-		return 600, build_req_err
+		return HttpStatusLocalError, build_req_err
 	}
 	if reflect.TypeOf(resp_data).Kind() != reflect.Ptr {
-		return 600, errors.New("resp_data is not a pointer")
+		return HttpStatusLocalError, errors.New("resp_data is not a pointer")
 	}
-	resp, err := api.Request(villa_id, request)
-	if err != nil {
-		return 600, err
+	middlewares := api.getMiddlewares()
+	if len(middlewares) == 0 {
+		return api.roundTrip(villa_id, request, resp_data, options)
 	}
-	defer resp.Body.Close()
-	if resp.Header.Get("Content-Type") != "application/json" {
-		return resp.StatusCode, errors.New("response Content-Type is not application/json")
+	return api.runMiddlewares(middlewares, villa_id, request, resp_data, options)
+}
+
+// 发送请求（按重试策略重试），并将响应解析到resp_data
+func (api *ApiBase) roundTrip(villa_id uint64, request *http.Request, resp_data interface{}, options *callOptions) (int, error) {
+	policy := api.retry_policy
+	if options != nil {
+		policy = options.retry
+	}
+	endpoint := endpointName(request)
+	var http_status int
+	var data []byte
+	var err error
+	for attempt := 1; ; attempt++ {
+		var header http.Header
+		http_status, header, data, err = api.requestOnce(villa_id, request, options)
+		if policy == nil {
+			break
+		}
+		var retcode struct {
+			Retcode int `json:"retcode"`
+		}
+		if data != nil {
+			json.Unmarshal(data, &retcode)
+		}
+		info := RetryAttempt{Endpoint: endpoint, Method: request.Method, VillaID: villa_id, Attempt: attempt,
+			HttpStatus: http_status, Retcode: retcode.Retcode, Err: err}
+		info.WillRetry = attempt < policy.MaxAttempts && request.Context().Err() == nil &&
+			policy.allowMethod(request.Method, endpoint) && policy.shouldRetry(http_status, retcode.Retcode, err) &&
+			(request.Body == nil || request.GetBody != nil)
+		if info.WillRetry {
+			info.Delay = policy.delay(attempt, header)
+		}
+		if policy.OnAttempt != nil {
+			policy.OnAttempt(info)
+		}
+		if !info.WillRetry {
+			break
+		}
+		select {
+		case <-time.After(info.Delay):
+		case <-request.Context().Done():
+			return HttpStatusLocalError, request.Context().Err()
+		}
+		if request, err = cloneRequest(request); err != nil {
+			return HttpStatusLocalError, err
+		}
 	}
-	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return resp.StatusCode, err
+		return http_status, err
 	}
+	return api.decodeResponse(endpoint, http_status, data, resp_data)
+}
 
+// 将json响应解析到resp_data，APIError优先于解析错误返回
+func (api *ApiBase) decodeResponse(endpoint string, http_status int, data []byte, resp_data interface{}) (int, error) {
 	s := reflect.ValueOf(resp_data)
 	reflect.Indirect(s).FieldByName("APIBaseModel").FieldByName("RawData").SetString(string(data))
 
-	err = json.Unmarshal(data, resp_data)
+	err := json.Unmarshal(data, resp_data)
+	if api_err := newAPIError(endpoint, http_status, data); api_err != nil {
+		return http_status, api_err
+	}
 	if err != nil {
-		var v map[string]interface{}
-		_err := json.Unmarshal(data, &v)
-		if _err != nil {
-			return resp.StatusCode, _err
+		return http_status, fmt.Errorf("decode response of %s: %w", endpoint, err)
+	}
+	return http_status, nil
+}
+
+// http状态码不为2xx或retcode不为0时返回APIError，否则返回nil
+func newAPIError(endpoint string, http_status int, data []byte) *APIError {
+	var base struct {
+		Retcode int    `json:"retcode"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(data, &base)
+	if http_status >= 200 && http_status < 300 && base.Retcode == RetcodeOK {
+		return nil
+	}
+	return &APIError{HttpStatus: http_status, Retcode: base.Retcode, Message: base.Message, Endpoint: endpoint, RawBody: string(data)}
+}
+
+// 发送一次请求并读取完整的json响应
+func (api *ApiBase) requestOnce(villa_id uint64, request *http.Request, options *callOptions) (int, http.Header, []byte, error) {
+	if options != nil {
+		options.attempts++
+		if options.limiter != nil {
+			if err := options.limiter.wait(request.Context(), endpointName(request), villa_id); err != nil {
+				return HttpStatusLocalError, nil, nil, err
+			}
 		}
-		fmt.Println(err, "on decoding data:\n", v)
 	}
-	return resp.StatusCode, err
+	resp, err := api.request(villa_id, request, options == nil || !options.external)
+	if err != nil {
+		return HttpStatusLocalError, nil, nil, err
+	}
+	return readJSONResponse(request, resp)
+}
+
+func readJSONResponse(request *http.Request, resp *http.Response) (int, http.Header, []byte, error) {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, resp.Header, nil, err
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return resp.StatusCode, resp.Header, nil, &APIError{HttpStatus: resp.StatusCode, Message: http.StatusText(resp.StatusCode),
+				Endpoint: endpointName(request), RawBody: string(data)}
+		}
+		return resp.StatusCode, resp.Header, nil, errors.New("response Content-Type is not application/json")
+	}
+	return resp.StatusCode, resp.Header, data, nil
+}
+
+// 复制请求及请求体，用于重试
+func cloneRequest(request *http.Request) (*http.Request, error) {
+	_request := request.Clone(request.Context())
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		_request.Body = body
+	}
+	return _request, nil
 }
 
 func (api *ApiBase) Request(villa_id uint64, request *http.Request) (*http.Response, error) {
+	return api.request(villa_id, request, true)
+}
+
+// 经过限流器并附带默认请求头发送请求，credentials为true时附带x-rpc-bot_*鉴权请求头
+func (api *ApiBase) request(villa_id uint64, request *http.Request, credentials bool) (*http.Response, error) {
+	if api.rate_limiter != nil {
+		if err := api.rate_limiter.wait(request.Context(), endpointName(request), villa_id); err != nil {
+			return nil, err
+		}
+	}
+	request.Header.Set("User-Agent", "github.com/GLGDLY/mhy_botsdk"+base.VERSION)
+	for k, v := range api.default_headers {
+		request.Header[k] = v
+	}
+	if !credentials {
+		return api.session.Do(request)
+	}
 	request.Header.Set("x-rpc-bot_id", api.Base.ID)
 	request.Header.Set("x-rpc-bot_secret", api.Base.EncodedSecret)
 	request.Header.Set("x-rpc-bot_villa_id", utils.String(villa_id))
-	request.Header.Set("User-Agent", "github.com/GLGDLY/mhy_botsdk"+base.VERSION)
 	return api.session.Do(request)
 }
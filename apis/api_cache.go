@@ -0,0 +1,199 @@
+package apis
+
+import (
+	"container/list"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/GLGDLY/mhy_botsdk/utils"
+)
+
+/* 成员、房间、身份组列表及别野信息的缓存，通过 ApiBase.SetCache 或 Bot.SetAPICache 启用（默认不启用）
+ *
+ * 启用后 GetMember、GetRoom、GetVillaMemberRoles、GetVilla 会优先返回未过期的缓存（http状态码为200），
+ * 通过SDK调用身份组、房间、成员的修改接口后会自动使对应的缓存失效，
+ * 机器人收到 JoinVilla、CreateRobot、DeleteRobot 事件时也会自动使对应的缓存失效；
+ * 返回的缓存与其他调用共享底层数据，请不要修改其中的切片 */
+
+// 缓存的统计信息
+type CacheStats struct {
+	Entries   int    // 当前缓存的条目数
+	Hits      uint64 // 命中次数
+	Misses    uint64 // 未命中（包括已过期）次数
+	Evictions uint64 // 因超出容量被淘汰的条目数
+}
+
+type cacheEntry struct {
+	key       string
+	villa_id  uint64
+	value     interface{}
+	expire_at time.Time
+}
+
+type Cache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	max_entries int
+	entries     map[string]*list.Element
+	lru         *list.List // 最近使用的在前
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+}
+
+// 创建缓存，ttl为每个条目的有效时间，max_entries为最大条目数（<=0为不限制），超出时淘汰最久未使用的条目
+func NewCache(ttl time.Duration, max_entries int) *Cache {
+	return &Cache{
+		ttl:         ttl,
+		max_entries: max_entries,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+func cacheKeyMember(villa_id, uid uint64) string {
+	return "member:" + utils.String(villa_id) + ":" + utils.String(uid)
+}
+
+func cacheKeyRoom(villa_id, room_id uint64) string {
+	return "room:" + utils.String(villa_id) + ":" + utils.String(room_id)
+}
+
+func cacheKeyRoles(villa_id uint64) string {
+	return "roles:" + utils.String(villa_id)
+}
+
+func cacheKeyVilla(villa_id uint64) string {
+	return "villa:" + utils.String(villa_id)
+}
+
+func (c *Cache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expire_at) {
+		c.removeElement(elem)
+		c.misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits++
+	return entry.value, true
+}
+
+func (c *Cache) set(key string, villa_id uint64, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.value, entry.expire_at = value, time.Now().Add(c.ttl)
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, villa_id: villa_id, value: value, expire_at: time.Now().Add(c.ttl)})
+	for c.max_entries > 0 && c.lru.Len() > c.max_entries {
+		c.removeElement(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// 须在持有锁时调用
+func (c *Cache) removeElement(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+func (c *Cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// 移除该别野中key以prefix开头的所有缓存
+func (c *Cache) removeVilla(villa_id uint64, prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.entries {
+		if elem.Value.(*cacheEntry).villa_id == villa_id && strings.HasPrefix(key, prefix) {
+			c.removeElement(elem)
+		}
+	}
+}
+
+func (c *Cache) InvalidateMember(villa_id, uid uint64) {
+	c.remove(cacheKeyMember(villa_id, uid))
+}
+
+func (c *Cache) InvalidateRoom(villa_id, room_id uint64) {
+	c.remove(cacheKeyRoom(villa_id, room_id))
+}
+
+func (c *Cache) InvalidateRoles(villa_id uint64) {
+	c.remove(cacheKeyRoles(villa_id))
+}
+
+func (c *Cache) InvalidateVillaInfo(villa_id uint64) {
+	c.remove(cacheKeyVilla(villa_id))
+}
+
+// 使别野的所有缓存失效
+func (c *Cache) InvalidateVilla(villa_id uint64) {
+	c.removeVilla(villa_id, "")
+}
+
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Entries: c.lru.Len(), Hits: c.hits, Misses: c.misses, Evictions: c.evictions}
+}
+
+// 设置API的缓存，传入nil则不缓存（默认）；同一个缓存可在多个ApiBase之间共享
+func (api *ApiBase) SetCache(cache *Cache) {
+	api.cache = cache
+}
+
+func (api *ApiBase) GetCache() *Cache {
+	return api.cache
+}
+
+// 以缓存的值填充resp_data，resp_data须为Model的指针
+func (api *ApiBase) cacheLoad(key string, resp_data interface{}) bool {
+	if api.cache == nil {
+		return false
+	}
+	value, ok := api.cache.get(key)
+	if !ok {
+		return false
+	}
+	reflect.ValueOf(resp_data).Elem().Set(reflect.ValueOf(value))
+	return true
+}
+
+func (api *ApiBase) cacheStore(key string, villa_id uint64, value interface{}, err error) {
+	if api.cache != nil && err == nil {
+		api.cache.set(key, villa_id, value)
+	}
+}
+
+// 修改类请求后执行invalidate，不论结果如何（失败的请求仍可能已生效）
+func (api *ApiBase) cacheInvalidate(invalidate func(c *Cache)) {
+	if api.cache != nil {
+		invalidate(api.cache)
+	}
+}
@@ -0,0 +1,71 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 请求未能发出或响应无法读取（网络错误、ctx取消、客户端限流等）时返回的http状态码
+const HttpStatusLocalError = 600
+
+// 开放平台常见的retcode
+const (
+	RetcodeOK                          = 0
+	RetcodeServerError                 = -502     // 服务器内部错误
+	RetcodeInvalidRequest              = -1       // 请求参数错误
+	RetcodeNoPermission                = 10318001 // 权限错误
+	RetcodeBotNotAdded                 = 10322002 // 机器人未被添加到别野
+	RetcodeBotNoPermission             = 10322003 // 机器人没有该接口的权限
+	RetcodeInvalidMemberBotAccessToken = 10322004 // 不合法的 bot_member_access_token
+	RetcodeBotAuthFailed               = 10322005 // 机器人鉴权失败
+	RetcodeUnsupportedMsgType          = 10322006 // 不支持的消息类型
+)
+
+// 可用于 errors.Is(err, apis.ErrXxx) 判断的常见错误
+var (
+	ErrRateLimited   = errors.New("rate limited")     // http 429，或客户端限流器拒绝请求（ErrRateLimitExceeded）
+	ErrNoPermission  = errors.New("no permission")    // http 403，或retcode为RetcodeNoPermission、RetcodeBotNoPermission
+	ErrInvalidParams = errors.New("invalid params")   // http 400，或retcode为RetcodeInvalidRequest
+	ErrBotNotAdded   = errors.New("bot not added")    // retcode为RetcodeBotNotAdded
+	ErrServerError   = errors.New("api server error") // http 5xx，或retcode为RetcodeServerError
+)
+
+/* 开放平台返回的错误，当http状态码不为2xx或retcode不为0时，API方法会返回 *APIError，
+ * 同时仍会返回已解析的Model及http状态码，可通过 errors.As 获取详细信息，或通过 errors.Is 与上方的错误比较 */
+type APIError struct {
+	HttpStatus int    // http状态码
+	Retcode    int    // 返回的retcode，无法解析时为0
+	Message    string // 返回的message
+	Endpoint   string // 接口名称，如"sendMessage"
+	RawBody    string // 原始的响应内容
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api %s failed, http status: %d, retcode: %d, message: %s", e.Endpoint, e.HttpStatus, e.Retcode, e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.HttpStatus == http.StatusTooManyRequests
+	case ErrNoPermission:
+		return e.HttpStatus == http.StatusForbidden || e.Retcode == RetcodeNoPermission || e.Retcode == RetcodeBotNoPermission
+	case ErrInvalidParams:
+		return e.HttpStatus == http.StatusBadRequest || e.Retcode == RetcodeInvalidRequest
+	case ErrBotNotAdded:
+		return e.Retcode == RetcodeBotNotAdded
+	case ErrServerError:
+		return e.HttpStatus >= 500 && e.HttpStatus < 600 || e.Retcode == RetcodeServerError
+	}
+	return false
+}
+
+// 获取err中的 *APIError，err不包含 *APIError 时返回nil
+func AsAPIError(err error) *APIError {
+	var api_err *APIError
+	if errors.As(err, &api_err) {
+		return api_err
+	}
+	return nil
+}
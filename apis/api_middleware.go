@@ -0,0 +1,75 @@
+package apis
+
+import (
+	"io"
+	"net/http"
+)
+
+// 经过中间件的一次API调用
+type APICall struct {
+	Endpoint    string        // 接口名称，如"sendMessage"
+	Method      string        // http方法
+	VillaID     uint64        // 请求的别野id
+	Request     *http.Request // 将要发出的请求，鉴权相关的x-rpc-bot_*请求头会在之后添加（OSS上传的请求不附带）
+	RequestBody []byte        // 请求体的副本，没有请求体时为nil
+	// 用于接收响应的Model指针（如 *api_models.SendMessageModel），next返回后即为解码后的响应；
+	// 中间件不调用next而直接返回时，可自行填充该Model以模拟响应
+	Response interface{}
+
+	options *callOptions
+}
+
+// 执行API调用，返回http状态码及错误，与 RequestHandler 的返回值相同
+type RoundTrip func(call *APICall) (int, error)
+
+// API请求中间件，调用next即执行后续的中间件及实际请求（包括重试），可在前后加入日志、统计、故障注入等逻辑
+type Middleware func(next RoundTrip) RoundTrip
+
+/* 添加API请求中间件，对所有API方法均有效（命中 SetCache 缓存的调用除外），多个中间件按添加顺序由外到内执行，例如：
+ *
+ *	api.Use(func(next apis.RoundTrip) apis.RoundTrip {
+ *		return func(call *apis.APICall) (int, error) {
+ *			http_status, err := next(call)
+ *			log.Println(call.Endpoint, call.VillaID, http_status, err)
+ *			return http_status, err
+ *		}
+ *	})
+ *
+ * 可在请求进行中调用，只对之后开始的请求生效 */
+func (api *ApiBase) Use(middlewares ...Middleware) {
+	api.middlewares_mu.Lock()
+	defer api.middlewares_mu.Unlock()
+	// 写时复制，旧的切片可能仍被进行中的请求使用
+	_middlewares := make([]Middleware, 0, len(api.middlewares)+len(middlewares))
+	api.middlewares = append(append(_middlewares, api.middlewares...), middlewares...)
+}
+
+func (api *ApiBase) getMiddlewares() []Middleware {
+	api.middlewares_mu.RLock()
+	defer api.middlewares_mu.RUnlock()
+	return api.middlewares
+}
+
+func (api *ApiBase) runMiddlewares(middlewares []Middleware, villa_id uint64, request *http.Request, resp_data interface{}, options *callOptions) (int, error) {
+	call := &APICall{
+		Endpoint: endpointName(request),
+		Method:   request.Method,
+		VillaID:  villa_id,
+		Request:  request,
+		Response: resp_data,
+		options:  options,
+	}
+	if request.GetBody != nil {
+		if body, err := request.GetBody(); err == nil {
+			call.RequestBody, _ = io.ReadAll(body)
+			body.Close()
+		}
+	}
+	var handler RoundTrip = func(call *APICall) (int, error) {
+		return api.roundTrip(call.VillaID, call.Request, call.Response, call.options)
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler(call)
+}
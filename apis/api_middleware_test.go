@@ -0,0 +1,48 @@
+package apis
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUseWhileRequesting(t *testing.T) {
+	server, _ := newRetryServer(func(n int32, w http.ResponseWriter) int { return http.StatusOK })
+	defer server.Close()
+	api, _ := newTestApi(server.URL, nil)
+	var calls int32
+	counting := func(next RoundTrip) RoundTrip {
+		return func(call *APICall) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			return next(call)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				api.GetVilla(1)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				api.Use(counting)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(api.getMiddlewares()); n != 40 {
+		t.Errorf("%d middlewares after 40 concurrent Use, some are lost", n)
+	}
+	atomic.StoreInt32(&calls, 0)
+	if _, _, err := api.GetVilla(1); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 40 {
+		t.Errorf("request passed %d middlewares, want 40", calls)
+	}
+}
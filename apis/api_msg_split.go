@@ -0,0 +1,70 @@
+package apis
+
+import (
+	"context"
+	"time"
+
+	models "github.com/GLGDLY/mhy_botsdk/api_models"
+)
+
+// 超长文本消息的拆分策略，通过 ApiBase.SetMessageSplitPolicy 或 Bot.SetAPIMessageSplitPolicy 设置
+type MessageSplitPolicy struct {
+	MaxLength int           // 每条消息的最大长度（UTF-16编码单元），<=0时为 models.MsgTextMaxLength
+	Delay     time.Duration // 相邻两条消息之间的发送间隔
+	// 每条消息发送后的回调，index从0开始，可用于获取每条消息的bot_msg_id
+	OnSent func(index, total int, resp models.SendMessageModel)
+}
+
+// 默认的拆分策略：按平台上限拆分，每条消息间隔300毫秒
+func DefaultMessageSplitPolicy() *MessageSplitPolicy {
+	return &MessageSplitPolicy{MaxLength: models.MsgTextMaxLength, Delay: 300 * time.Millisecond}
+}
+
+// 设置超长文本消息的拆分策略，SendMessage、SendMessageCustomize 等发送的文本超长时会按顺序拆分为多条消息发送，
+// 返回最后一条消息的结果；传入nil则不拆分（默认），超长的消息会直接发送并由服务器返回错误
+func (api *ApiBase) SetMessageSplitPolicy(policy *MessageSplitPolicy) {
+	api.split_policy = policy
+}
+
+type sendBuiltFunc func(ctx context.Context, villa_id uint64, msg models.MsgInputModel) (models.SendMessageModel, int, error)
+
+// 通过send发送msg，若设置了拆分策略则先拆分为多段，遇到错误即停止
+func (api *ApiBase) sendChunks(ctx context.Context, villa_id uint64, msg models.MsgInputModel, send sendBuiltFunc) (models.SendMessageModel, int, error) {
+	policy := api.split_policy
+	if policy == nil {
+		return send(ctx, villa_id, msg)
+	}
+	chunks, err := models.SplitTextMsg(msg, policy.MaxLength)
+	if err != nil {
+		return models.SendMessageModel{}, HttpStatusLocalError, err
+	}
+	var resp_data models.SendMessageModel
+	var http_status int
+	for i, chunk := range chunks {
+		if i > 0 && policy.Delay > 0 {
+			if err := sleepCtx(ctx, policy.Delay); err != nil {
+				return resp_data, HttpStatusLocalError, err
+			}
+		}
+		resp_data, http_status, err = send(ctx, villa_id, chunk)
+		if err != nil {
+			return resp_data, http_status, err
+		}
+		if policy.OnSent != nil {
+			policy.OnSent(i, len(chunks), resp_data)
+		}
+	}
+	return resp_data, http_status, nil
+}
+
+// 等待d或直至ctx结束
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
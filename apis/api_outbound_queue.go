@@ -0,0 +1,261 @@
+package apis
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	models "github.com/GLGDLY/mhy_botsdk/api_models"
+)
+
+/* 发送消息的队列，保证同一房间（villa_id, room_id）的消息按加入队列的顺序依次发送，不同房间之间互不阻塞
+ *
+ * 通过 ApiBase.SetOutboundQueue 或 Bot.SetAPIOutboundQueue 启用后，SendMessage、SendMessageCustomize 及
+ * EventSendMessage.Reply 等均会经过队列发送并等待结果；也可通过 Send 异步发送，并通过返回的 SendFuture 获取结果 */
+
+var (
+	ErrOutboundQueueClosed = errors.New("outbound queue closed")
+	ErrOutboundQueueFull   = errors.New("outbound queue full")
+)
+
+type OutboundQueueOptions struct {
+	RateLimiter *RateLimiter // 每次尝试发送前的限流（接口名为"sendMessage"），nil为不限流；与ApiBase的限流器同时使用时会叠加
+	Retry       *RetryPolicy // 发送失败时的重试策略（取代ApiBase的重试策略，两者不会叠加），nil为不重试；RetryPOST等方法限制对队列无效，网络错误时重试可能导致消息重复
+	MaxPending  int          // 每个房间最多等待发送的消息数，超出时 Send 直接返回 ErrOutboundQueueFull，<=0为不限制
+	// 每条消息发送完成（成功或最终失败）后的回调，在发送该房间消息的goroutine中调用，应尽快返回
+	OnResult func(villa_id, room_id uint64, result SendResult)
+}
+
+// 队列中消息的发送结果
+type SendResult struct {
+	Resp       models.SendMessageModel
+	HttpStatus int
+	Err        error
+	Attempts   int // 发送的尝试次数，拆分为多条的消息为所有尝试次数之和
+}
+
+// 异步发送的结果
+type SendFuture struct {
+	done      chan struct{}
+	mu        sync.Mutex
+	result    SendResult
+	callbacks []func(SendResult)
+}
+
+func newSendFuture() *SendFuture {
+	return &SendFuture{done: make(chan struct{})}
+}
+
+func (f *SendFuture) complete(result SendResult) *SendFuture {
+	f.mu.Lock()
+	f.result = result
+	callbacks := f.callbacks
+	f.callbacks = nil
+	close(f.done)
+	f.mu.Unlock()
+	for _, callback := range callbacks {
+		callback(result)
+	}
+	return f
+}
+
+// 发送完成时关闭的channel
+func (f *SendFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// 等待发送完成并返回结果
+func (f *SendFuture) Result() SendResult {
+	<-f.done
+	return f.result
+}
+
+// 等待发送完成，ctx结束时提前返回ctx的错误（消息仍可能在之后发送）
+func (f *SendFuture) Wait(ctx context.Context) (models.SendMessageModel, int, error) {
+	select {
+	case <-f.done:
+		return f.result.Resp, f.result.HttpStatus, f.result.Err
+	case <-ctx.Done():
+		return models.SendMessageModel{}, HttpStatusLocalError, ctx.Err()
+	}
+}
+
+// 添加发送完成后的回调，已完成时立即在当前goroutine中调用
+func (f *SendFuture) Then(callback func(result SendResult)) *SendFuture {
+	f.mu.Lock()
+	select {
+	case <-f.done:
+		f.mu.Unlock()
+		callback(f.result)
+	default:
+		f.callbacks = append(f.callbacks, callback)
+		f.mu.Unlock()
+	}
+	return f
+}
+
+type outboundRoom struct {
+	villa_id uint64
+	room_id  uint64
+}
+
+type outboundItem struct {
+	key    outboundRoom
+	ctx    context.Context
+	msg    models.MsgInputModel
+	future *SendFuture
+}
+
+type OutboundQueue struct {
+	api     *ApiBase
+	options OutboundQueueOptions
+	mu      sync.Mutex
+	rooms   map[outboundRoom][]*outboundItem // 有发送goroutine运行中的房间的待发送消息
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// 创建使用api发送消息的队列，需再通过 ApiBase.SetOutboundQueue 使 SendMessage 等经过该队列
+func NewOutboundQueue(api *ApiBase, options OutboundQueueOptions) *OutboundQueue {
+	return &OutboundQueue{api: api, options: options, rooms: make(map[outboundRoom][]*outboundItem)}
+}
+
+// 使 SendMessage、SendMessageCustomize 等经过队列发送，传入nil则直接发送（默认）；q需由 NewOutboundQueue(api, ...) 创建
+func (api *ApiBase) SetOutboundQueue(q *OutboundQueue) {
+	api.outbound_queue = q
+}
+
+func (api *ApiBase) GetOutboundQueue() *OutboundQueue {
+	return api.outbound_queue
+}
+
+// 将消息加入队列并立即返回，图片的上传及消息的构造在当前goroutine中完成
+func (q *OutboundQueue) Send(villa_id uint64, room_id uint64, _msg models.MsgBuilder) *SendFuture {
+	return q.SendCtx(context.Background(), villa_id, room_id, _msg)
+}
+
+// ctx在消息开始发送前结束时，该消息不会被发送
+func (q *OutboundQueue) SendCtx(ctx context.Context, villa_id uint64, room_id uint64, _msg models.MsgBuilder) *SendFuture {
+	if http_status, err := q.api.prepareImageMessage(ctx, villa_id, _msg); err != nil {
+		return newSendFuture().complete(SendResult{HttpStatus: http_status, Err: err})
+	}
+	msg, err := _msg.Build(room_id)
+	if err != nil {
+		return newSendFuture().complete(SendResult{HttpStatus: HttpStatusLocalError, Err: err})
+	}
+	return q.enqueue(ctx, villa_id, room_id, msg).future
+}
+
+// 将消息加入队列并等待结果；ctx在消息开始发送前结束时，将其从队列中移除
+func (q *OutboundQueue) sendWait(ctx context.Context, villa_id uint64, room_id uint64, msg models.MsgInputModel) (models.SendMessageModel, int, error) {
+	item := q.enqueue(ctx, villa_id, room_id, msg)
+	select {
+	case <-item.future.done:
+	case <-ctx.Done():
+		if q.remove(item) {
+			item.future.complete(SendResult{HttpStatus: HttpStatusLocalError, Err: ctx.Err()})
+		}
+		<-item.future.done // 正在发送的消息会因ctx中断，等待其结束
+	}
+	result := item.future.result
+	return result.Resp, result.HttpStatus, result.Err
+}
+
+func (q *OutboundQueue) enqueue(ctx context.Context, villa_id uint64, room_id uint64, msg models.MsgInputModel) *outboundItem {
+	key := outboundRoom{villa_id: villa_id, room_id: room_id}
+	item := &outboundItem{key: key, ctx: ctx, msg: msg, future: newSendFuture()}
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		item.future.complete(SendResult{HttpStatus: HttpStatusLocalError, Err: ErrOutboundQueueClosed})
+		return item
+	}
+	pending, running := q.rooms[key]
+	if q.options.MaxPending > 0 && len(pending) >= q.options.MaxPending {
+		q.mu.Unlock()
+		item.future.complete(SendResult{HttpStatus: HttpStatusLocalError, Err: ErrOutboundQueueFull})
+		return item
+	}
+	q.rooms[key] = append(pending, item)
+	if !running {
+		q.wg.Add(1)
+		go q.drain(key)
+	}
+	q.mu.Unlock()
+	return item
+}
+
+// 消息仍在队列中等待时将其移除
+func (q *OutboundQueue) remove(item *outboundItem) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := q.rooms[item.key]
+	for i, v := range pending {
+		if v == item {
+			q.rooms[item.key] = append(pending[:i:i], pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 逐条发送该房间的消息，队列为空时退出
+func (q *OutboundQueue) drain(key outboundRoom) {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		pending := q.rooms[key]
+		if len(pending) == 0 {
+			delete(q.rooms, key)
+			q.mu.Unlock()
+			return
+		}
+		item := pending[0]
+		pending[0] = nil
+		q.rooms[key] = pending[1:]
+		q.mu.Unlock()
+
+		result := q.deliver(key, item)
+		if q.options.OnResult != nil {
+			q.options.OnResult(key.villa_id, key.room_id, result)
+		}
+		item.future.complete(result)
+	}
+}
+
+func (q *OutboundQueue) deliver(key outboundRoom, item *outboundItem) SendResult {
+	if err := item.ctx.Err(); err != nil {
+		return SendResult{HttpStatus: HttpStatusLocalError, Err: err}
+	}
+	// 使用队列的重试策略替换ApiBase的重试策略，避免重试叠加
+	options := &callOptions{limiter: q.options.RateLimiter}
+	if q.options.Retry != nil {
+		policy := *q.options.Retry
+		policy.RetryPOST = true
+		options.retry = &policy
+	}
+	send := func(ctx context.Context, villa_id uint64, msg models.MsgInputModel) (models.SendMessageModel, int, error) {
+		return q.api.sendBuiltMessageWith(ctx, villa_id, msg, options)
+	}
+	resp, http_status, err := q.api.sendChunks(item.ctx, key.villa_id, item.msg, send)
+	return SendResult{Resp: resp, HttpStatus: http_status, Err: err, Attempts: options.attempts}
+}
+
+// 等待发送的消息数
+func (q *OutboundQueue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	count := 0
+	for _, pending := range q.rooms {
+		count += len(pending)
+	}
+	return count
+}
+
+// 停止接受新的消息，并等待已加入队列的消息发送完成
+func (q *OutboundQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.wg.Wait()
+}
@@ -0,0 +1,174 @@
+package apis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	models "github.com/GLGDLY/mhy_botsdk/api_models"
+)
+
+func textMsg(t *testing.T, text string) models.MsgBuilder {
+	msg, err := models.NewMsg(models.MsgTypeText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.AppendText(text)
+	return msg
+}
+
+func TestOutboundQueueRetry(t *testing.T) {
+	server, count := newRetryServer(func(n int32, w http.ResponseWriter) int {
+		if n == 1 {
+			w.Header().Set("Retry-After", "1")
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	})
+	defer server.Close()
+	api_policy := fastRetryPolicy()
+	api_policy.RetryPOST = true
+	api, api_attempts := newTestApi(server.URL, api_policy)
+	q := NewOutboundQueue(api, OutboundQueueOptions{Retry: fastRetryPolicy()})
+	api.SetOutboundQueue(q)
+	defer q.Close()
+
+	start := time.Now()
+	result := q.Send(1, 2, textMsg(t, "hi")).Result()
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if time.Since(start) < time.Second {
+		t.Errorf("Retry-After is not respected, elapsed %v", time.Since(start))
+	}
+	if *count != 2 || result.Attempts != 2 {
+		t.Errorf("requests = %d, attempts = %d, want 2", *count, result.Attempts)
+	}
+	if len(*api_attempts) != 0 {
+		t.Errorf("retry policy of ApiBase is used by the queue: %+v", *api_attempts)
+	}
+}
+
+func TestOutboundQueueNoStackedRetry(t *testing.T) {
+	server, count := newRetryServer(func(n int32, w http.ResponseWriter) int { return http.StatusServiceUnavailable })
+	defer server.Close()
+	api_policy := fastRetryPolicy()
+	api_policy.RetryPOST = true
+	api, _ := newTestApi(server.URL, api_policy)
+	q := NewOutboundQueue(api, OutboundQueueOptions{Retry: &RetryPolicy{MaxAttempts: 2}})
+	api.SetOutboundQueue(q)
+	defer q.Close()
+
+	_, status, err := api.SendMessageCustomize(1, 2, textMsg(t, "hi"))
+	if status != http.StatusServiceUnavailable || err == nil {
+		t.Fatalf("SendMessageCustomize() = %d, %v", status, err)
+	}
+	if *count != 2 {
+		t.Errorf("requests = %d, want 2 attempts of the queue policy only", *count)
+	}
+}
+
+// server blocking sendMessage until release is closed, and recording the sent texts
+type blockingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	texts    []string
+	received chan struct{}
+	release  chan struct{}
+}
+
+func newBlockingServer() *blockingServer {
+	server := &blockingServer{received: make(chan struct{}, 16), release: make(chan struct{})}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			MsgContent string `json:"msg_content"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		var content struct {
+			Content struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		}
+		json.Unmarshal([]byte(body.MsgContent), &content)
+		server.mu.Lock()
+		server.texts = append(server.texts, content.Content.Text)
+		server.mu.Unlock()
+		server.received <- struct{}{}
+		<-server.release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"retcode":0,"message":"","data":{"bot_msg_id":"x"}}`))
+	}))
+	return server
+}
+
+func TestOutboundQueueCancelRemoves(t *testing.T) {
+	server := newBlockingServer()
+	defer server.Close()
+	api, _ := newTestApi(server.URL, nil)
+	q := NewOutboundQueue(api, OutboundQueueOptions{})
+	api.SetOutboundQueue(q)
+
+	first := q.Send(1, 2, textMsg(t, "first"))
+	<-server.received
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := api.SendMessageCustomizeCtx(ctx, 1, 2, textMsg(t, "cancelled"))
+		done <- err
+	}()
+	for q.Pending() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("SendMessageCustomizeCtx() error = %v, want context.Canceled", err)
+	}
+	if n := q.Pending(); n != 0 {
+		t.Errorf("cancelled message is still queued, pending = %d", n)
+	}
+	close(server.release)
+	if result := first.Result(); result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if _, _, err := api.SendMessageCustomize(1, 2, textMsg(t, "last")); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+	if want := []string{"first", "last"}; len(server.texts) != 2 || server.texts[0] != want[0] || server.texts[1] != want[1] {
+		t.Errorf("sent %q, want %q", server.texts, want)
+	}
+}
+
+func TestOutboundQueueOrder(t *testing.T) {
+	server := newBlockingServer()
+	close(server.release)
+	defer server.Close()
+	api, _ := newTestApi(server.URL, nil)
+	q := NewOutboundQueue(api, OutboundQueueOptions{MaxPending: 10})
+	var futures []*SendFuture
+	for _, text := range []string{"a", "b", "c", "d"} {
+		futures = append(futures, q.Send(1, 2, textMsg(t, text)))
+	}
+	for _, future := range futures {
+		if _, _, err := future.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Close()
+	if got := q.Send(1, 2, textMsg(t, "e")).Result().Err; !errors.Is(got, ErrOutboundQueueClosed) {
+		t.Errorf("Send() after Close error = %v", got)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for i, want := range []string{"a", "b", "c", "d"} {
+		if server.texts[i] != want {
+			t.Errorf("sent %q, want in order a b c d", server.texts)
+			break
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/GLGDLY/mhy_botsdk/utils"
+)
+
+// 客户端限流器在fail-fast模式下拒绝请求时返回的错误，errors.Is(err, ErrRateLimited) 同样成立
+var ErrRateLimitExceeded = fmt.Errorf("%w: rate limit exceeded (client-side)", ErrRateLimited)
+
+type RateLimitMode uint8
+
+const (
+	RateLimitWait     RateLimitMode = 0 // 令牌不足时阻塞等待，直到获得令牌或ctx结束
+	RateLimitFailFast RateLimitMode = 1 // 令牌不足时直接返回ErrRateLimitExceeded
+)
+
+type tokenBucket struct {
+	rate   float64 // 每秒补充的令牌数
+	burst  float64
+	tokens float64 // 有等待中的预留令牌时可为负数
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (b *tokenBucket) advance(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// 令牌余额恢复为非负数所需的等待时间
+func (b *tokenBucket) debt() time.Duration {
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type rateLimitConfig struct {
+	rate  float64
+	burst int
+}
+
+// 限流器的统计信息
+type RateLimiterStats struct {
+	Waiting      int            // 当前正在等待令牌的请求数量
+	WaitingByKey map[string]int // 按限流桶统计的等待数量，key为"global"、"endpoint:<接口名>"或"villa:<别野id>"
+	Passed       uint64         // 已放行的请求总数
+	Rejected     uint64         // fail-fast模式下被拒绝或等待期间被取消的请求总数
+}
+
+// 基于令牌桶的客户端限流器，可同时设置全局、按接口、按别野的限制，请求需要同时取得所有适用桶的令牌才会发出
+type RateLimiter struct {
+	mu               sync.Mutex
+	mode             RateLimitMode
+	global           *rateLimitConfig
+	endpoints        map[string]rateLimitConfig
+	villa            *rateLimitConfig
+	buckets          map[string]*tokenBucket
+	waiting          map[string]int
+	waiting_total    int
+	passed, rejected uint64
+}
+
+func NewRateLimiter(mode RateLimitMode) *RateLimiter {
+	return &RateLimiter{
+		mode:      mode,
+		endpoints: make(map[string]rateLimitConfig),
+		buckets:   make(map[string]*tokenBucket),
+		waiting:   make(map[string]int),
+	}
+}
+
+// 设置所有请求共享的限制，rate为每秒请求数，burst为允许的突发数量；rate<=0时取消该限制
+func (l *RateLimiter) SetGlobalLimit(rate float64, burst int) *RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, "global")
+	if rate <= 0 {
+		l.global = nil
+	} else {
+		l.global = &rateLimitConfig{rate: rate, burst: burst}
+	}
+	return l
+}
+
+// 设置单个接口的限制，endpoint为接口名（如"sendMessage"）；rate<=0时取消该限制
+func (l *RateLimiter) SetEndpointLimit(endpoint string, rate float64, burst int) *RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, "endpoint:"+endpoint)
+	if rate <= 0 {
+		delete(l.endpoints, endpoint)
+	} else {
+		l.endpoints[endpoint] = rateLimitConfig{rate: rate, burst: burst}
+	}
+	return l
+}
+
+// 设置每个别野（x-rpc-bot_villa_id）各自的限制；rate<=0时取消该限制
+func (l *RateLimiter) SetVillaLimit(rate float64, burst int) *RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k := range l.buckets {
+		if strings.HasPrefix(k, "villa:") {
+			delete(l.buckets, k)
+		}
+	}
+	if rate <= 0 {
+		l.villa = nil
+	} else {
+		l.villa = &rateLimitConfig{rate: rate, burst: burst}
+	}
+	return l
+}
+
+func (l *RateLimiter) SetMode(mode RateLimitMode) *RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mode = mode
+	return l
+}
+
+func (l *RateLimiter) Stats() RateLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := RateLimiterStats{Waiting: l.waiting_total, WaitingByKey: make(map[string]int), Passed: l.passed, Rejected: l.rejected}
+	for k, v := range l.waiting {
+		stats.WaitingByKey[k] = v
+	}
+	return stats
+}
+
+// 适用于该请求的令牌桶，须在持有锁时调用
+func (l *RateLimiter) bucketsFor(endpoint string, villa_id uint64) map[string]*tokenBucket {
+	ret := make(map[string]*tokenBucket)
+	get := func(key string, config rateLimitConfig) {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = newTokenBucket(config.rate, config.burst)
+			l.buckets[key] = b
+		}
+		ret[key] = b
+	}
+	if l.global != nil {
+		get("global", *l.global)
+	}
+	if config, ok := l.endpoints[endpoint]; ok {
+		get("endpoint:"+endpoint, config)
+	}
+	if l.villa != nil {
+		get("villa:"+utils.String(villa_id), *l.villa)
+	}
+	return ret
+}
+
+// 从每个适用的令牌桶中取出一个令牌，必要时等待
+func (l *RateLimiter) wait(ctx context.Context, endpoint string, villa_id uint64) error {
+	l.mu.Lock()
+	buckets := l.bucketsFor(endpoint, villa_id)
+	now := time.Now()
+	for _, b := range buckets {
+		b.advance(now)
+	}
+	if l.mode == RateLimitFailFast {
+		for _, b := range buckets {
+			if b.tokens < 1 {
+				l.rejected++
+				l.mu.Unlock()
+				return ErrRateLimitExceeded
+			}
+		}
+	}
+	var delay time.Duration
+	for _, b := range buckets {
+		b.tokens--
+		if d := b.debt(); d > delay {
+			delay = d
+		}
+	}
+	if delay == 0 {
+		l.passed++
+		l.mu.Unlock()
+		return nil
+	}
+	for k := range buckets {
+		l.waiting[k]++
+	}
+	l.waiting_total++
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, b := range buckets {
+		if l.waiting[k]--; l.waiting[k] <= 0 {
+			delete(l.waiting, k)
+		}
+		if err != nil { // 归还预留的令牌
+			b.advance(time.Now())
+			b.tokens = math.Min(b.burst, b.tokens+1)
+		}
+	}
+	l.waiting_total--
+	if err != nil {
+		l.rejected++
+		return err
+	}
+	l.passed++
+	return nil
+}
+
+// 设置API请求的客户端限流器，传入nil则不限流（默认）；同一个限流器可在多个ApiBase之间共享
+func (api *ApiBase) SetRateLimiter(limiter *RateLimiter) {
+	api.rate_limiter = limiter
+}
+
+func (api *ApiBase) GetRateLimiter() *RateLimiter {
+	return api.rate_limiter
+}
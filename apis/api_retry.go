@@ -0,0 +1,139 @@
+package apis
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 每次请求尝试结束后传给 RetryPolicy.OnAttempt 的信息
+type RetryAttempt struct {
+	Endpoint   string        // 接口名称，如"sendMessage"
+	Method     string        // http方法
+	VillaID    uint64        // 请求的别野id
+	Attempt    int           // 第几次尝试，从1开始
+	HttpStatus int           // 本次尝试的http状态码，请求未发出时为HttpStatusLocalError
+	Retcode    int           // 本次尝试返回的retcode，无法解析时为0
+	Err        error         // 本次尝试的错误
+	WillRetry  bool          // 是否将进行下一次尝试
+	Delay      time.Duration // 下一次尝试前的等待时间
+}
+
+// API请求的重试策略，通过 ApiBase.SetRetryPolicy 或 Bot.SetAPIRetryPolicy 设置
+type RetryPolicy struct {
+	MaxAttempts        int           // 最大尝试次数（包括第一次请求），小于等于1时不重试
+	BaseDelay          time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	MaxDelay           time.Duration // 单次等待时间的上限（不限制Retry-After），0为不限制
+	Jitter             float64       // 随机抖动比例（0~1），等待时间会在 [delay*(1-Jitter), delay] 之间随机
+	RespectRetryAfter  bool          // 是否遵循响应中的Retry-After请求头
+	RetryPOST          bool          // 是否重试所有POST请求，POST请求（如sendMessage）重试可能导致重复执行，默认只重试GET请求
+	RetryPOSTEndpoints []string      // 仅对指定的POST接口进行重试，如 []string{"sendMessage"}
+	// 自定义是否需要重试，为nil时对网络错误（不包括客户端限流器的拒绝及ctx取消）、429及5xx状态码进行重试
+	ShouldRetry func(http_status int, retcode int, err error) bool
+	OnAttempt   func(attempt RetryAttempt) // 每次尝试结束后的回调，可用于记录日志或统计
+}
+
+// 默认的重试策略：最多尝试3次，从200毫秒开始指数退避，遵循Retry-After，只重试GET请求
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:       3,
+		BaseDelay:         200 * time.Millisecond,
+		MaxDelay:          5 * time.Second,
+		Jitter:            0.2,
+		RespectRetryAfter: true,
+	}
+}
+
+// 设置API请求的重试策略，传入nil则不重试（默认）
+func (api *ApiBase) SetRetryPolicy(policy *RetryPolicy) {
+	api.retry_policy = policy
+}
+
+func defaultShouldRetry(http_status int, retcode int, err error) bool {
+	if http_status == http.StatusTooManyRequests || http_status >= 500 && http_status < 600 {
+		return true
+	}
+	if http_status != HttpStatusLocalError || err == nil {
+		return false
+	}
+	// 只重试收到响应前的网络错误，不重试客户端限流、取消及本地错误
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var url_err *url.Error
+	return errors.As(err, &url_err)
+}
+
+func (policy *RetryPolicy) allowMethod(method, endpoint string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if policy.RetryPOST {
+		return true
+	}
+	for _, v := range policy.RetryPOSTEndpoints {
+		if v == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *RetryPolicy) shouldRetry(http_status int, retcode int, err error) bool {
+	if policy.ShouldRetry != nil {
+		return policy.ShouldRetry(http_status, retcode, err)
+	}
+	return defaultShouldRetry(http_status, retcode, err)
+}
+
+// 下一次尝试前的等待时间，attempt从1开始
+func (policy *RetryPolicy) delay(attempt int, header http.Header) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && (policy.MaxDelay == 0 || delay < policy.MaxDelay); i++ {
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if policy.Jitter > 0 && delay > 0 {
+		delay -= time.Duration(rand.Float64() * policy.Jitter * float64(delay))
+	}
+	if policy.RespectRetryAfter && header != nil {
+		if retry_after, ok := parseRetryAfter(header.Get("Retry-After")); ok && retry_after > delay {
+			delay = retry_after
+		}
+	}
+	return delay
+}
+
+// Retry-After可为秒数或http日期
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+// 请求的接口名称，如"sendMessage"；OSS上传等非开放API的请求为host+path
+func endpointName(request *http.Request) string {
+	if i := strings.Index(request.URL.Path, api_path_prefix); i >= 0 {
+		return request.URL.Path[i+len(api_path_prefix):]
+	}
+	return request.URL.Host + request.URL.Path
+}
@@ -0,0 +1,212 @@
+package apis
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	models "github.com/GLGDLY/mhy_botsdk/models"
+)
+
+// server replying to every request with the status returned by reply, and recording the number of requests
+func newRetryServer(reply func(n int32, w http.ResponseWriter) int) (*httptest.Server, *int32) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&count, 1)
+		w.Header().Set("Content-Type", "application/json")
+		status := reply(n, w)
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"retcode":0,"message":"","data":{}}`))
+		} else {
+			w.Write([]byte(`{"retcode":-1,"message":"error","data":{}}`))
+		}
+	}))
+	return server, &count
+}
+
+func newTestApi(base_url string, policy *RetryPolicy) (*ApiBase, *[]RetryAttempt) {
+	api := MakeAPIBase(models.BotBase{ID: "bot_test"}, 5*time.Second)
+	api.SetBaseURL(base_url)
+	var mu sync.Mutex
+	attempts := &[]RetryAttempt{}
+	if policy != nil {
+		policy.OnAttempt = func(attempt RetryAttempt) {
+			mu.Lock()
+			defer mu.Unlock()
+			*attempts = append(*attempts, attempt)
+		}
+	}
+	api.SetRetryPolicy(policy)
+	return api, attempts
+}
+
+func fastRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, RespectRetryAfter: true}
+}
+
+func TestRetryServerErrors(t *testing.T) {
+	server, count := newRetryServer(func(n int32, w http.ResponseWriter) int {
+		if n < 3 {
+			return http.StatusBadGateway
+		}
+		return http.StatusOK
+	})
+	defer server.Close()
+	api, attempts := newTestApi(server.URL, fastRetryPolicy())
+	if _, _, err := api.GetVilla(1); err != nil {
+		t.Fatal(err)
+	}
+	if *count != 3 || len(*attempts) != 3 || (*attempts)[2].WillRetry {
+		t.Errorf("requests = %d, attempts = %+v", *count, *attempts)
+	}
+}
+
+func TestRetryAfterOn429(t *testing.T) {
+	server, count := newRetryServer(func(n int32, w http.ResponseWriter) int {
+		if n == 1 {
+			w.Header().Set("Retry-After", "1")
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	})
+	defer server.Close()
+	api, attempts := newTestApi(server.URL, fastRetryPolicy())
+	start := time.Now()
+	if _, _, err := api.GetVilla(1); err != nil {
+		t.Fatal(err)
+	}
+	if *count != 2 {
+		t.Errorf("requests = %d, want 2", *count)
+	}
+	if (*attempts)[0].Delay < time.Second || time.Since(start) < time.Second {
+		t.Errorf("Retry-After is not respected, delay = %v", (*attempts)[0].Delay)
+	}
+}
+
+func TestRetryPOSTOnlyWhenAllowed(t *testing.T) {
+	server, count := newRetryServer(func(n int32, w http.ResponseWriter) int {
+		return http.StatusServiceUnavailable
+	})
+	defer server.Close()
+	api, _ := newTestApi(server.URL, fastRetryPolicy())
+	if _, _, err := api.SendMessage(1, 2, "hi"); err == nil {
+		t.Fatal("SendMessage() returned no error")
+	}
+	if *count != 1 {
+		t.Errorf("POST is retried by default, requests = %d", *count)
+	}
+	policy := fastRetryPolicy()
+	policy.RetryPOSTEndpoints = []string{"sendMessage"}
+	api, _ = newTestApi(server.URL, policy)
+	api.SendMessage(1, 2, "hi")
+	if *count != 4 {
+		t.Errorf("requests = %d after retrying sendMessage, want 4", *count)
+	}
+}
+
+func TestRetryTransportError(t *testing.T) {
+	server, _ := newRetryServer(func(n int32, w http.ResponseWriter) int { return http.StatusOK })
+	server.Close() // connection refused
+	api, attempts := newTestApi(server.URL, fastRetryPolicy())
+	_, status, err := api.GetVilla(1)
+	if err == nil || status != HttpStatusLocalError {
+		t.Fatalf("GetVilla() = %d, %v", status, err)
+	}
+	if len(*attempts) != 3 {
+		t.Errorf("transport error is tried %d times, want 3", len(*attempts))
+	}
+}
+
+func TestRetryNotOnRateLimitRejection(t *testing.T) {
+	server, count := newRetryServer(func(n int32, w http.ResponseWriter) int { return http.StatusOK })
+	defer server.Close()
+	api, attempts := newTestApi(server.URL, fastRetryPolicy())
+	api.SetRateLimiter(NewRateLimiter(RateLimitFailFast).SetGlobalLimit(0.001, 1))
+	if _, _, err := api.GetVilla(1); err != nil {
+		t.Fatal(err)
+	}
+	_, status, err := api.GetVilla(1)
+	if !errors.Is(err, ErrRateLimitExceeded) || !errors.Is(err, ErrRateLimited) || status != HttpStatusLocalError {
+		t.Fatalf("GetVilla() = %d, %v, want ErrRateLimitExceeded", status, err)
+	}
+	if *count != 1 {
+		t.Errorf("rejected request reached the server, requests = %d", *count)
+	}
+	if len(*attempts) != 2 || (*attempts)[1].WillRetry {
+		t.Errorf("rejection by the rate limiter is retried: %+v", *attempts)
+	}
+	if stats := api.GetRateLimiter().Stats(); stats.Passed != 1 || stats.Rejected != 1 {
+		t.Errorf("rate limiter stats = %+v", stats)
+	}
+}
+
+func TestRetryNotOnContextDone(t *testing.T) {
+	server, count := newRetryServer(func(n int32, w http.ResponseWriter) int { return http.StatusOK })
+	defer server.Close()
+	api, attempts := newTestApi(server.URL, fastRetryPolicy())
+	api.SetRateLimiter(NewRateLimiter(RateLimitWait).SetGlobalLimit(1, 1))
+	api.GetVilla(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err := api.GetVillaCtx(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetVillaCtx() error = %v, want context.DeadlineExceeded", err)
+	}
+	if *count != 1 || len(*attempts) != 2 || (*attempts)[1].WillRetry {
+		t.Errorf("requests = %d, attempts = %+v", *count, *attempts)
+	}
+}
+
+func TestRetryWaitsForRateLimiter(t *testing.T) {
+	server, count := newRetryServer(func(n int32, w http.ResponseWriter) int {
+		if n == 1 {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	})
+	defer server.Close()
+	api, _ := newTestApi(server.URL, fastRetryPolicy())
+	api.SetRateLimiter(NewRateLimiter(RateLimitWait).SetGlobalLimit(10, 1))
+	start := time.Now()
+	if _, _, err := api.GetVilla(1); err != nil {
+		t.Fatal(err)
+	}
+	if *count != 2 {
+		t.Errorf("requests = %d, want 2", *count)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("retry does not wait for the rate limiter, elapsed %v", elapsed)
+	}
+	if stats := api.GetRateLimiter().Stats(); stats.Passed != 2 {
+		t.Errorf("rate limiter passed %d requests, want 2", stats.Passed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	cases := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{" 0 ", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := parseRetryAfter(c.value)
+		if got != c.want || ok != c.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", c.value, got, ok, c.want, c.ok)
+		}
+	}
+	if got, ok := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); !ok || got < 59*time.Minute {
+		t.Errorf("parseRetryAfter(http date) = %v, %v", got, ok)
+	}
+}
@@ -0,0 +1,674 @@
+package apistub
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	api_models "github.com/GLGDLY/mhy_botsdk/api_models"
+	apis "github.com/GLGDLY/mhy_botsdk/apis"
+	utils "github.com/GLGDLY/mhy_botsdk/utils"
+)
+
+const api_prefix = "/vila/api/bot/platform/"
+const oss_upload_path = "/oss/upload"
+
+type handlerFunc func(villa *Villa, params requestParams) (interface{}, int, string)
+
+func (srv *Server) handlers() map[string]handlerFunc {
+	return map[string]handlerFunc{
+		"checkMemberBotAccessToken": srv.checkMemberBotAccessToken,
+		"getVilla":                  srv.getVilla,
+		"getMember":                 srv.getMember,
+		"getVillaMembers":           srv.getVillaMembers,
+		"deleteVillaMember":         srv.deleteVillaMember,
+		"pinMessage":                srv.pinMessage,
+		"recallMessage":             srv.recallMessage,
+		"sendMessage":               srv.sendMessage,
+		"createGroup":               srv.createGroup,
+		"editGroup":                 srv.editGroup,
+		"deleteGroup":               srv.deleteGroup,
+		"getGroupList":              srv.getGroupList,
+		"editRoom":                  srv.editRoom,
+		"deleteRoom":                srv.deleteRoom,
+		"getRoom":                   srv.getRoom,
+		"getVillaGroupRoomList":     srv.getVillaGroupRoomList,
+		"operateMemberToRole":       srv.operateMemberToRole,
+		"createMemberRole":          srv.createMemberRole,
+		"editMemberRole":            srv.editMemberRole,
+		"deleteMemberRole":          srv.deleteMemberRole,
+		"getMemberRoleInfo":         srv.getMemberRoleInfo,
+		"getVillaMemberRoles":       srv.getVillaMemberRoles,
+		"getAllEmoticons":           srv.getAllEmoticons,
+		"audit":                     srv.audit,
+		"transferImage":             srv.transferImage,
+		"getUploadImageParams":      srv.getUploadImageParams,
+	}
+}
+
+/* request parsing */
+
+type requestParams struct {
+	bot_id string
+	header http.Header
+	values map[string]interface{}
+}
+
+// merge query string and json body, as some GET endpoints of the sdk send their params in body
+func parseParams(r *http.Request) (requestParams, error) {
+	params := requestParams{bot_id: r.Header.Get("x-rpc-bot_id"), header: r.Header, values: map[string]interface{}{}}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return params, err
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&params.values); err != nil {
+			return params, err
+		}
+	}
+	for k, v := range r.URL.Query() {
+		if len(v) > 0 {
+			params.values[k] = v[0]
+		}
+	}
+	return params, nil
+}
+
+func (p requestParams) has(key string) bool {
+	_, ok := p.values[key]
+	return ok
+}
+
+func (p requestParams) string(key string) string {
+	v, ok := p.values[key]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return utils.String(v)
+}
+
+func (p requestParams) uint64(key string) uint64 {
+	n, _ := strconv.ParseUint(p.string(key), 10, 64)
+	return n
+}
+
+func (p requestParams) int64(key string) int64 {
+	n, _ := strconv.ParseInt(p.string(key), 10, 64)
+	return n
+}
+
+func (p requestParams) bool(key string) bool {
+	v, ok := p.values[key].(bool)
+	return ok && v
+}
+
+func (p requestParams) strings(key string) []string {
+	ret := []string{}
+	if list, ok := p.values[key].([]interface{}); ok {
+		for _, v := range list {
+			ret = append(ret, utils.String(v))
+		}
+	}
+	return ret
+}
+
+/* response writing */
+
+func writeJSON(w http.ResponseWriter, http_status int, retcode int, message string, data interface{}) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	w.Header().Set("Content-Type", "application/json") // the sdk requires exactly "application/json"
+	w.WriteHeader(http_status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"retcode": retcode, "message": message, "data": data})
+}
+
+func (srv *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == oss_upload_path {
+		srv.ossUpload(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, api_prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	endpoint := strings.TrimPrefix(r.URL.Path, api_prefix)
+	handler, ok := srv.handlers()[endpoint]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.requests[endpoint]++
+
+	if inj := srv.injected[endpoint]; inj != nil && inj.times > 0 {
+		inj.times--
+		writeJSON(w, inj.http_status, inj.retcode, inj.message, nil)
+		return
+	}
+
+	params, err := parseParams(r)
+	if err != nil {
+		writeJSON(w, http.StatusOK, apis.RetcodeInvalidRequest, "invalid request body: "+err.Error(), nil)
+		return
+	}
+	villa_id, _ := strconv.ParseUint(r.Header.Get("x-rpc-bot_villa_id"), 10, 64)
+	villa, ok := srv.villas[villa_id]
+	if !ok {
+		writeJSON(w, http.StatusOK, apis.RetcodeBotNotAdded, "bot not added to villa", nil)
+		return
+	}
+	data, retcode, message := handler(villa, params)
+	if message == "" && retcode == 0 {
+		message = "OK"
+	}
+	writeJSON(w, http.StatusOK, retcode, message, data)
+}
+
+func invalid(message string) (interface{}, int, string) {
+	return nil, apis.RetcodeInvalidRequest, message
+}
+
+func success(data interface{}) (interface{}, int, string) {
+	return data, 0, ""
+}
+
+/* model converting */
+
+func (villa *Villa) roleModel(role *Role) api_models.MemberRoleModel {
+	return api_models.MemberRoleModel{
+		ID:        role.ID,
+		Name:      role.Name,
+		VillaID:   villa.ID,
+		Color:     role.Color,
+		RoleType:  role.RoleType,
+		IsAllRoom: role.IsAllRoom,
+		RoomIDs:   api_models.Uint64StringSlice(append([]uint64{}, role.RoomIDs...)),
+	}
+}
+
+func (villa *Villa) memberModel(member *Member) api_models.MemberModel {
+	var ret api_models.MemberModel
+	ret.Basic.UID = utils.String(member.UID)
+	ret.Basic.Nickname = member.Nickname
+	ret.Basic.Introduce = member.Introduce
+	ret.Basic.AvatarURL = member.AvatarURL
+	ret.JoinedAt = utils.String(member.JoinedAt)
+	ret.RoleIDList = []string{}
+	ret.RoleList = []api_models.MemberRoleModel{}
+	for _, role_id := range member.RoleIDs {
+		if role, ok := villa.Roles[role_id]; ok {
+			ret.RoleIDList = append(ret.RoleIDList, utils.String(role_id))
+			ret.RoleList = append(ret.RoleList, villa.roleModel(role))
+		}
+	}
+	return ret
+}
+
+func (villa *Villa) memberNum(role_id uint64) int {
+	num := 0
+	for _, member := range villa.Members {
+		for _, id := range member.RoleIDs {
+			if id == role_id {
+				num++
+				break
+			}
+		}
+	}
+	return num
+}
+
+/* MemberAccess */
+
+func (srv *Server) checkMemberBotAccessToken(villa *Villa, params requestParams) (interface{}, int, string) {
+	// token format for the stub: "<uid>"
+	uid, err := strconv.ParseUint(params.string("token"), 10, 64)
+	member, ok := villa.Members[uid]
+	if err != nil || !ok {
+		return nil, 10322004, "invalid member bot access token"
+	}
+	return success(map[string]interface{}{
+		"access_info": map[string]interface{}{
+			"uid":                 utils.String(uid),
+			"villa_id":            utils.String(villa.ID),
+			"member_access_token": params.string("token"),
+			"bot_tpl_id":          params.bot_id,
+		},
+		"member": villa.memberModel(member),
+	})
+}
+
+/* Villa */
+
+func (srv *Server) getVilla(villa *Villa, params requestParams) (interface{}, int, string) {
+	return success(map[string]interface{}{
+		"villa": map[string]interface{}{
+			"villa_id":         utils.String(villa.ID),
+			"name":             villa.Name,
+			"villa_avatar_url": villa.AvatarURL,
+			"owner_uid":        utils.String(villa.OwnerUID),
+			"is_official":      false,
+			"introduce":        villa.Introduce,
+			"category_id":      0,
+			"tags":             []string{},
+		},
+	})
+}
+
+/* Member */
+
+func (srv *Server) getMember(villa *Villa, params requestParams) (interface{}, int, string) {
+	member, ok := villa.Members[params.uint64("uid")]
+	if !ok {
+		return invalid("member not found")
+	}
+	return success(map[string]interface{}{"member": villa.memberModel(member)})
+}
+
+func (srv *Server) getVillaMembers(villa *Villa, params requestParams) (interface{}, int, string) {
+	offset, _ := strconv.Atoi(params.string("offset"))
+	size := params.uint64("size")
+	uids := sortedKeys(villa.Members)
+	list := []api_models.MemberModel{}
+	next_offset := ""
+	for i := offset; i < len(uids); i++ {
+		if uint64(len(list)) >= size {
+			next_offset = strconv.Itoa(i)
+			break
+		}
+		list = append(list, villa.memberModel(villa.Members[uids[i]]))
+	}
+	return success(map[string]interface{}{"list": list, "next_offset_str": next_offset})
+}
+
+func (srv *Server) deleteVillaMember(villa *Villa, params requestParams) (interface{}, int, string) {
+	uid := params.uint64("uid")
+	if _, ok := villa.Members[uid]; !ok {
+		return invalid("member not found")
+	}
+	delete(villa.Members, uid)
+	return success(nil)
+}
+
+/* Message */
+
+func (srv *Server) findMessage(villa *Villa, msg_uid string, room_id uint64) *SentMessage {
+	for i := range srv.messages {
+		msg := &srv.messages[i]
+		if msg.VillaID == villa.ID && msg.RoomID == room_id && msg.BotMsgID == msg_uid {
+			return msg
+		}
+	}
+	return nil
+}
+
+func (srv *Server) sendMessage(villa *Villa, params requestParams) (interface{}, int, string) {
+	room_id := params.uint64("room_id")
+	if _, ok := villa.Rooms[room_id]; !ok {
+		return invalid("room not found")
+	}
+	object_name := params.string("object_name")
+	switch api_models.MsgContentType(object_name) {
+	case api_models.MsgTypeText, api_models.MsgTypeImage, api_models.MsgTypePost:
+	default:
+		return nil, 10322006, "unsupported msg type"
+	}
+	content := params.string("msg_content")
+	if !json.Valid([]byte(content)) {
+		return invalid("msg_content is not a valid json string")
+	}
+	bot_msg_id := fmt.Sprintf("stub_msg_%d", srv.newID())
+	srv.messages = append(srv.messages, SentMessage{
+		BotID:      params.bot_id,
+		VillaID:    villa.ID,
+		RoomID:     room_id,
+		ObjectName: object_name,
+		Content:    content,
+		BotMsgID:   bot_msg_id,
+		SendAt:     time.Now().UnixMilli(),
+	})
+	return success(map[string]interface{}{"bot_msg_id": bot_msg_id})
+}
+
+func (srv *Server) pinMessage(villa *Villa, params requestParams) (interface{}, int, string) {
+	msg := srv.findMessage(villa, params.string("msg_uid"), params.uint64("room_id"))
+	if msg == nil {
+		return invalid("message not found")
+	}
+	msg.Pinned = !params.bool("is_cancel")
+	return success(nil)
+}
+
+func (srv *Server) recallMessage(villa *Villa, params requestParams) (interface{}, int, string) {
+	msg := srv.findMessage(villa, params.string("msg_uid"), params.uint64("room_id"))
+	if msg == nil {
+		return invalid("message not found")
+	}
+	msg.Recalled = true
+	return success(nil)
+}
+
+/* Room */
+
+func (srv *Server) createGroup(villa *Villa, params requestParams) (interface{}, int, string) {
+	if params.string("group_name") == "" {
+		return invalid("group_name is required")
+	}
+	group := &Group{ID: srv.newID(), Name: params.string("group_name")}
+	villa.Groups[group.ID] = group
+	return success(map[string]interface{}{"group_id": utils.String(group.ID)})
+}
+
+func (srv *Server) editGroup(villa *Villa, params requestParams) (interface{}, int, string) {
+	group, ok := villa.Groups[params.uint64("group_id")]
+	if !ok {
+		return invalid("group not found")
+	}
+	group.Name = params.string("group_name")
+	return success(nil)
+}
+
+func (srv *Server) deleteGroup(villa *Villa, params requestParams) (interface{}, int, string) {
+	group_id := params.uint64("group_id")
+	if _, ok := villa.Groups[group_id]; !ok {
+		return invalid("group not found")
+	}
+	delete(villa.Groups, group_id)
+	for room_id, room := range villa.Rooms {
+		if room.GroupID == group_id {
+			delete(villa.Rooms, room_id)
+		}
+	}
+	return success(nil)
+}
+
+func (srv *Server) getGroupList(villa *Villa, params requestParams) (interface{}, int, string) {
+	list := []map[string]interface{}{}
+	for _, group_id := range sortedKeys(villa.Groups) {
+		list = append(list, map[string]interface{}{"group_id": utils.String(group_id), "group_name": villa.Groups[group_id].Name})
+	}
+	return success(map[string]interface{}{"list": list})
+}
+
+func (srv *Server) editRoom(villa *Villa, params requestParams) (interface{}, int, string) {
+	room, ok := villa.Rooms[params.uint64("room_id")]
+	if !ok {
+		return invalid("room not found")
+	}
+	room.Name = params.string("room_name")
+	return success(nil)
+}
+
+func (srv *Server) deleteRoom(villa *Villa, params requestParams) (interface{}, int, string) {
+	room_id := params.uint64("room_id")
+	if _, ok := villa.Rooms[room_id]; !ok {
+		return invalid("room not found")
+	}
+	delete(villa.Rooms, room_id)
+	return success(nil)
+}
+
+func (srv *Server) getRoom(villa *Villa, params requestParams) (interface{}, int, string) {
+	room, ok := villa.Rooms[params.uint64("room_id")]
+	if !ok {
+		return invalid("room not found")
+	}
+	return success(map[string]interface{}{
+		"room": map[string]interface{}{
+			"room_id":                  utils.String(room.ID),
+			"room_name":                room.Name,
+			"room_type":                room.Type,
+			"group_id":                 utils.String(room.GroupID),
+			"room_default_notify_type": room.DefaultNotifyType,
+			"send_msg_auth_range": map[string]interface{}{
+				"is_all_send_msg": room.IsAllSendMsg,
+				"roles":           api_models.Uint64StringSlice(append([]uint64{}, room.SendMsgRoles...)),
+			},
+		},
+	})
+}
+
+func (srv *Server) getVillaGroupRoomList(villa *Villa, params requestParams) (interface{}, int, string) {
+	list := []map[string]interface{}{}
+	for _, group_id := range sortedKeys(villa.Groups) {
+		room_list := []map[string]interface{}{}
+		for _, room_id := range sortedKeys(villa.Rooms) {
+			room := villa.Rooms[room_id]
+			if room.GroupID != group_id {
+				continue
+			}
+			room_list = append(room_list, map[string]interface{}{
+				"room_id":   utils.String(room.ID),
+				"room_name": room.Name,
+				"room_type": room.Type,
+				"group_id":  utils.String(room.GroupID),
+			})
+		}
+		list = append(list, map[string]interface{}{
+			"group_id":   utils.String(group_id),
+			"group_name": villa.Groups[group_id].Name,
+			"room_list":  room_list,
+		})
+	}
+	return success(map[string]interface{}{"list": list})
+}
+
+/* Role */
+
+func (srv *Server) operateMemberToRole(villa *Villa, params requestParams) (interface{}, int, string) {
+	role_id := params.uint64("role_id")
+	if _, ok := villa.Roles[role_id]; !ok {
+		return invalid("role not found")
+	}
+	member, ok := villa.Members[params.uint64("uid")]
+	if !ok {
+		return invalid("member not found")
+	}
+	role_ids := []uint64{}
+	for _, id := range member.RoleIDs {
+		if id != role_id {
+			role_ids = append(role_ids, id)
+		}
+	}
+	if params.bool("is_add") {
+		role_ids = append(role_ids, role_id)
+	}
+	member.RoleIDs = role_ids
+	return success(nil)
+}
+
+func (srv *Server) createMemberRole(villa *Villa, params requestParams) (interface{}, int, string) {
+	if params.string("name") == "" {
+		return invalid("name is required")
+	}
+	role := &Role{ID: srv.newID(), Name: params.string("name"), Color: params.string("color"), RoleType: RoleTypeCustom,
+		Permissions: params.strings("permissions"), IsAllRoom: true}
+	villa.Roles[role.ID] = role
+	return success(map[string]interface{}{"id": utils.String(role.ID)})
+}
+
+func (srv *Server) editMemberRole(villa *Villa, params requestParams) (interface{}, int, string) {
+	role, ok := villa.Roles[params.uint64("id")]
+	if !ok {
+		return invalid("role not found")
+	}
+	role.Name = params.string("name")
+	role.Color = params.string("color")
+	if params.has("permissions") {
+		role.Permissions = params.strings("permissions")
+	}
+	return success(nil)
+}
+
+func (srv *Server) deleteMemberRole(villa *Villa, params requestParams) (interface{}, int, string) {
+	role_id := params.uint64("id")
+	role, ok := villa.Roles[role_id]
+	if !ok {
+		return invalid("role not found")
+	}
+	if role.RoleType != RoleTypeCustom {
+		return invalid("only custom role can be deleted")
+	}
+	delete(villa.Roles, role_id)
+	return success(nil)
+}
+
+func (srv *Server) getMemberRoleInfo(villa *Villa, params requestParams) (interface{}, int, string) {
+	role, ok := villa.Roles[params.uint64("role_id")]
+	if !ok {
+		return invalid("role not found")
+	}
+	permissions := []map[string]interface{}{}
+	for _, p := range role.Permissions {
+		permissions = append(permissions, map[string]interface{}{"key": p, "name": p, "describe": ""})
+	}
+	return success(map[string]interface{}{
+		"role": map[string]interface{}{
+			"id":          utils.String(role.ID),
+			"name":        role.Name,
+			"color":       role.Color,
+			"villa_id":    utils.String(villa.ID),
+			"role_type":   role.RoleType,
+			"member_num":  utils.String(villa.memberNum(role.ID)),
+			"permissions": permissions,
+		},
+	})
+}
+
+func (srv *Server) getVillaMemberRoles(villa *Villa, params requestParams) (interface{}, int, string) {
+	list := []map[string]interface{}{}
+	for _, role_id := range sortedKeys(villa.Roles) {
+		role := villa.Roles[role_id]
+		list = append(list, map[string]interface{}{
+			"id":         utils.String(role.ID),
+			"name":       role.Name,
+			"color":      role.Color,
+			"role_type":  role.RoleType,
+			"villa_id":   utils.String(villa.ID),
+			"member_num": utils.String(villa.memberNum(role.ID)),
+		})
+	}
+	return success(map[string]interface{}{"list": list})
+}
+
+/* Emoticon */
+
+func (srv *Server) getAllEmoticons(villa *Villa, params requestParams) (interface{}, int, string) {
+	return success(map[string]interface{}{"list": []map[string]interface{}{
+		{"emoticon_id": "1", "describe_text": "好耶", "icon": "https://bbs-static.miyoushe.com/stub/emoticon_1.png"},
+	}})
+}
+
+/* Audit */
+
+func (srv *Server) audit(villa *Villa, params requestParams) (interface{}, int, string) {
+	if params.string("audit_content") == "" {
+		return invalid("audit_content is required")
+	}
+	record := AuditRecord{
+		AuditID:     fmt.Sprintf("stub_audit_%d", srv.newID()),
+		VillaID:     villa.ID,
+		Content:     params.string("audit_content"),
+		PassThrough: params.string("pass_through"),
+		UID:         params.uint64("uid"),
+		RoomID:      params.uint64("room_id"),
+	}
+	srv.audits = append(srv.audits, record)
+	return success(map[string]interface{}{"audit_id": record.AuditID})
+}
+
+/* Image */
+
+func (srv *Server) transferImage(villa *Villa, params requestParams) (interface{}, int, string) {
+	url := params.string("url")
+	if url == "" {
+		return invalid("url is required")
+	}
+	new_url := fmt.Sprintf("https://upload-bbs.miyoushe.com/stub/transfer/%d", srv.newID())
+	srv.images = append(srv.images, UploadedImage{VillaID: villa.ID, SourceURL: url, NewURL: new_url})
+	return success(map[string]interface{}{"new_url": new_url})
+}
+
+func (srv *Server) getUploadImageParams(villa *Villa, params requestParams) (interface{}, int, string) {
+	md5_str := params.string("md5")
+	ext := params.string("ext")
+	if md5_str == "" || ext == "" {
+		return invalid("md5 and ext are required")
+	}
+	key := fmt.Sprintf("stub/upload/%d/%s.%s", srv.newID(), md5_str, ext)
+	srv.pending_uploads[key] = villa.ID
+	return success(map[string]interface{}{
+		"type":          "oss",
+		"file_name":     md5_str + "." + ext,
+		"max_file_size": 10 << 20,
+		"params": map[string]interface{}{
+			"accessid":              "stub_access_id",
+			"callback":              "",
+			"callback_var":          map[string]interface{}{"x:extra": ""},
+			"dir":                   "stub/upload",
+			"expire":                time.Now().Add(time.Hour).Unix(),
+			"host":                  srv.URL + oss_upload_path,
+			"name":                  md5_str + "." + ext,
+			"policy":                "stub_policy",
+			"signature":             "stub_signature",
+			"x_oss_content_type":    "image/" + ext,
+			"object_acl":            "default",
+			"content_disposition":   "",
+			"key":                   key,
+			"success_action_status": "200",
+		},
+	})
+}
+
+func (srv *Server) ossUpload(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSON(w, http.StatusBadRequest, apis.RetcodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	key := r.FormValue("key")
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.requests["ossUpload"]++
+	villa_id, ok := srv.pending_uploads[key]
+	if !ok {
+		writeJSON(w, http.StatusForbidden, apis.RetcodeInvalidRequest, "unknown upload key", nil)
+		return
+	}
+	delete(srv.pending_uploads, key)
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apis.RetcodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apis.RetcodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	sum := md5.Sum(data)
+	if !strings.Contains(key, hex.EncodeToString(sum[:])) {
+		writeJSON(w, http.StatusBadRequest, apis.RetcodeInvalidRequest, "md5 mismatch", nil)
+		return
+	}
+	new_url := "https://upload-bbs.miyoushe.com/" + key
+	srv.images = append(srv.images, UploadedImage{VillaID: villa_id, Data: data, Ext: key[strings.LastIndex(key, ".")+1:],
+		NewURL: new_url, Header: r.Header.Clone()})
+	writeJSON(w, http.StatusOK, 0, "success", map[string]interface{}{"url": new_url, "secret_url": "", "object": key})
+}
@@ -0,0 +1,324 @@
+package apistub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	apis "github.com/GLGDLY/mhy_botsdk/apis"
+	models "github.com/GLGDLY/mhy_botsdk/models"
+)
+
+/* 用于测试的进程内大别野开放API模拟服务器
+ *
+ * 使用 NewServer() 创建服务器后，通过 AddVilla、AddMember、AddGroup、AddRoom、AddRole 等方法构建别野数据，
+ * 再将机器人的 API 根地址指向该服务器（bot.SetAPIBaseURL(srv.URL) 或直接使用 srv.NewApiBase()），
+ * 机器人发送的消息会被记录，可通过 SentMessages() 等方法断言机器人的回复内容 */
+
+const (
+	RoleTypeAllMember = "MEMBER_ROLE_TYPE_ALL_MEMBER"
+	RoleTypeAdmin     = "MEMBER_ROLE_TYPE_ADMIN"
+	RoleTypeOwner     = "MEMBER_ROLE_TYPE_OWNER"
+	RoleTypeCustom    = "MEMBER_ROLE_TYPE_CUSTOM"
+
+	RoomTypeChat = "BOT_PLATFORM_ROOM_TYPE_CHAT_ROOM"
+	RoomTypePost = "BOT_PLATFORM_ROOM_TYPE_POST_ROOM"
+)
+
+type Member struct {
+	UID       uint64
+	Nickname  string
+	Introduce string
+	AvatarURL string
+	RoleIDs   []uint64
+	JoinedAt  int64
+}
+
+type Role struct {
+	ID          uint64
+	Name        string
+	Color       string
+	RoleType    string
+	Permissions []string
+	IsAllRoom   bool
+	RoomIDs     []uint64
+}
+
+type Group struct {
+	ID   uint64
+	Name string
+}
+
+type Room struct {
+	ID                uint64
+	Name              string
+	Type              string
+	GroupID           uint64
+	DefaultNotifyType string
+	IsAllSendMsg      bool
+	SendMsgRoles      []uint64
+}
+
+type Villa struct {
+	ID        uint64
+	Name      string
+	AvatarURL string
+	OwnerUID  uint64
+	Introduce string
+	Members   map[uint64]*Member
+	Roles     map[uint64]*Role
+	Groups    map[uint64]*Group
+	Rooms     map[uint64]*Room
+}
+
+// 机器人通过sendMessage发送的消息记录
+type SentMessage struct {
+	BotID      string
+	VillaID    uint64
+	RoomID     uint64
+	ObjectName string
+	Content    string // 原始的msg_content json字符串
+	BotMsgID   string
+	SendAt     int64
+	Pinned     bool
+	Recalled   bool
+}
+
+// 将msg_content解析到v中
+func (m SentMessage) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(m.Content), v)
+}
+
+// 获取文本消息的文本内容，非文本消息返回空字符串
+func (m SentMessage) Text() string {
+	var content struct {
+		Content struct {
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if m.Decode(&content) != nil {
+		return ""
+	}
+	return content.Content.Text
+}
+
+// 机器人通过audit提交的审核记录
+type AuditRecord struct {
+	AuditID     string
+	VillaID     uint64
+	Content     string
+	PassThrough string
+	UID         uint64
+	RoomID      uint64
+}
+
+// 机器人上传的图片记录，transferImage只有SourceURL，getUploadImageParams+OSS上传只有Data
+type UploadedImage struct {
+	VillaID   uint64
+	SourceURL string
+	Data      []byte
+	Ext       string
+	NewURL    string
+	Header    http.Header // OSS上传请求的请求头，transferImage时为nil
+}
+
+type injectedError struct {
+	http_status int
+	retcode     int
+	message     string
+	times       int
+}
+
+// 模拟开放API的服务器，内嵌的httptest.Server提供URL和Close等方法
+type Server struct {
+	*httptest.Server
+	mu              sync.Mutex
+	villas          map[uint64]*Villa
+	messages        []SentMessage
+	audits          []AuditRecord
+	images          []UploadedImage
+	pending_uploads map[string]uint64 // upload key: villa id
+	injected        map[string]*injectedError
+	requests        map[string]int // endpoint: count
+	next_id         uint64
+}
+
+// 创建并启动一个模拟服务器，使用完毕后需调用Close
+func NewServer() *Server {
+	srv := &Server{
+		villas:          make(map[uint64]*Villa),
+		pending_uploads: make(map[string]uint64),
+		injected:        make(map[string]*injectedError),
+		requests:        make(map[string]int),
+		next_id:         100000,
+	}
+	srv.Server = httptest.NewServer(http.HandlerFunc(srv.serveHTTP))
+	return srv
+}
+
+// 创建一个指向该服务器的ApiBase，用于在不创建Bot的情况下直接测试API调用
+func (srv *Server) NewApiBase(base models.BotBase) *apis.ApiBase {
+	api := apis.MakeAPIBase(base, 10*time.Second)
+	api.SetBaseURL(srv.URL)
+	return api
+}
+
+func (srv *Server) newID() uint64 {
+	srv.next_id++
+	return srv.next_id
+}
+
+// 添加一个别野，并自动创建“全体成员”身份组，返回该身份组的id
+func (srv *Server) AddVilla(villa_id uint64, name string, owner_uid uint64) uint64 {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	villa := &Villa{
+		ID:       villa_id,
+		Name:     name,
+		OwnerUID: owner_uid,
+		Members:  make(map[uint64]*Member),
+		Roles:    make(map[uint64]*Role),
+		Groups:   make(map[uint64]*Group),
+		Rooms:    make(map[uint64]*Room),
+	}
+	all_member_role := &Role{ID: srv.newID(), Name: "全体成员", RoleType: RoleTypeAllMember, IsAllRoom: true}
+	villa.Roles[all_member_role.ID] = all_member_role
+	srv.villas[villa_id] = villa
+	return all_member_role.ID
+}
+
+// 添加一个身份组，返回身份组id
+func (srv *Server) AddRole(villa_id uint64, name, role_type string, permissions ...string) uint64 {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	villa := srv.mustVilla(villa_id)
+	role := &Role{ID: srv.newID(), Name: name, Color: "#6173AB", RoleType: role_type, Permissions: permissions, IsAllRoom: true}
+	villa.Roles[role.ID] = role
+	return role.ID
+}
+
+// 添加一个成员，成员会自动拥有“全体成员”身份组
+func (srv *Server) AddMember(villa_id, uid uint64, nickname string, role_ids ...uint64) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	villa := srv.mustVilla(villa_id)
+	for _, role := range villa.Roles {
+		if role.RoleType == RoleTypeAllMember {
+			role_ids = append([]uint64{role.ID}, role_ids...)
+			break
+		}
+	}
+	villa.Members[uid] = &Member{UID: uid, Nickname: nickname, RoleIDs: role_ids, JoinedAt: time.Now().Unix()}
+}
+
+// 添加一个分组，返回分组id
+func (srv *Server) AddGroup(villa_id uint64, name string) uint64 {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	villa := srv.mustVilla(villa_id)
+	group := &Group{ID: srv.newID(), Name: name}
+	villa.Groups[group.ID] = group
+	return group.ID
+}
+
+// 在指定分组添加一个聊天房间
+func (srv *Server) AddRoom(villa_id, group_id, room_id uint64, name string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	villa := srv.mustVilla(villa_id)
+	villa.Rooms[room_id] = &Room{ID: room_id, Name: name, Type: RoomTypeChat, GroupID: group_id,
+		DefaultNotifyType: "BOT_PLATFORM_DEFAULT_NOTIFY_TYPE_NOTIFY", IsAllSendMsg: true}
+}
+
+// 以回调的形式读取或修改别野数据
+func (srv *Server) UpdateVilla(villa_id uint64, f func(villa *Villa)) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	f(srv.mustVilla(villa_id))
+}
+
+func (srv *Server) mustVilla(villa_id uint64) *Villa {
+	villa, ok := srv.villas[villa_id]
+	if !ok {
+		panic("apistub: villa not found, please AddVilla first")
+	}
+	return villa
+}
+
+// 令指定接口（如"sendMessage"）的下times次请求返回错误，http_status为200时仅返回retcode错误，用于测试错误处理
+func (srv *Server) InjectError(endpoint string, times int, http_status int, retcode int, message string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.injected[endpoint] = &injectedError{http_status: http_status, retcode: retcode, message: message, times: times}
+}
+
+// 获取所有已发送的消息（按发送顺序）
+func (srv *Server) SentMessages() []SentMessage {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]SentMessage{}, srv.messages...)
+}
+
+// 获取发送到指定房间的消息（按发送顺序）
+func (srv *Server) SentMessagesTo(villa_id, room_id uint64) []SentMessage {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ret := []SentMessage{}
+	for _, msg := range srv.messages {
+		if msg.VillaID == villa_id && msg.RoomID == room_id {
+			ret = append(ret, msg)
+		}
+	}
+	return ret
+}
+
+// 获取最后一条已发送的消息，没有消息时ok为false
+func (srv *Server) LastMessage() (msg SentMessage, ok bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.messages) == 0 {
+		return SentMessage{}, false
+	}
+	return srv.messages[len(srv.messages)-1], true
+}
+
+func (srv *Server) Audits() []AuditRecord {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]AuditRecord{}, srv.audits...)
+}
+
+func (srv *Server) UploadedImages() []UploadedImage {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]UploadedImage{}, srv.images...)
+}
+
+// 获取指定接口被请求的次数
+func (srv *Server) RequestCount(endpoint string) int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.requests[endpoint]
+}
+
+// 清空已记录的消息、审核、图片和请求次数，保留别野数据
+func (srv *Server) ResetRecords() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.messages = nil
+	srv.audits = nil
+	srv.images = nil
+	srv.requests = make(map[string]int)
+}
+
+func sortedKeys[V any](m map[uint64]V) []uint64 {
+	keys := make([]uint64, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -0,0 +1,150 @@
+package apistub
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	apis "github.com/GLGDLY/mhy_botsdk/apis"
+	models "github.com/GLGDLY/mhy_botsdk/models"
+)
+
+// server with villa 1, room 2 and member 3
+func newTestServer(t *testing.T) (*Server, *apis.ApiBase) {
+	srv := NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddVilla(1, "villa", 3)
+	group_id := srv.AddGroup(1, "group")
+	srv.AddRoom(1, group_id, 2, "room")
+	srv.AddMember(1, 3, "alice")
+	return srv, srv.NewApiBase(models.BotBase{ID: "bot_stub", Secret: "secret"})
+}
+
+func TestSendMessageRoundTrip(t *testing.T) {
+	srv, api := newTestServer(t)
+	res, status, err := api.SendMessage(1, 2, "hello <@3>")
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("SendMessage() = %d, %v", status, err)
+	}
+	msgs := srv.SentMessages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.BotID != "bot_stub" || msg.VillaID != 1 || msg.RoomID != 2 || msg.ObjectName != "MHY:Text" {
+		t.Errorf("sent message = %+v", msg)
+	}
+	if msg.BotMsgID == "" || msg.BotMsgID != res.Data.BotMsgId {
+		t.Errorf("bot_msg_id = %q, sent message has %q", res.Data.BotMsgId, msg.BotMsgID)
+	}
+	if msg.Text() != "hello @alice" {
+		t.Errorf("text = %q, want the mention resolved by getMember", msg.Text())
+	}
+	var content struct {
+		Content struct {
+			Entities []struct {
+				Entity struct {
+					Type string `json:"type"`
+					UID  string `json:"user_id"`
+				} `json:"entity"`
+			} `json:"entities"`
+		} `json:"content"`
+	}
+	if err := msg.Decode(&content); err != nil {
+		t.Fatal(err)
+	}
+	if len(content.Content.Entities) != 1 || content.Content.Entities[0].Entity.UID != "3" {
+		t.Errorf("entities = %+v, want a mention of user 3", content.Content.Entities)
+	}
+	if srv.RequestCount("getMember") != 1 || srv.RequestCount("sendMessage") != 1 {
+		t.Errorf("getMember requested %d times, sendMessage %d times", srv.RequestCount("getMember"), srv.RequestCount("sendMessage"))
+	}
+	if _, _, err := api.SendMessage(1, 99, "hi"); err == nil {
+		t.Error("SendMessage() to a missing room returned no error")
+	}
+	if n := len(srv.SentMessagesTo(1, 2)); n != 1 {
+		t.Errorf("%d messages in room 2, want 1", n)
+	}
+}
+
+func TestGetMember(t *testing.T) {
+	_, api := newTestServer(t)
+	res, _, err := api.GetMember(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member := res.Data.Member; member.Basic.UID != "3" || member.Basic.Nickname != "alice" || len(member.RoleIDList) != 1 {
+		t.Errorf("member = %+v", member)
+	}
+	_, _, err = api.GetMember(1, 4)
+	var api_err *apis.APIError
+	if !errors.As(err, &api_err) || api_err.Retcode != apis.RetcodeInvalidRequest || !strings.Contains(api_err.Message, "member not found") {
+		t.Errorf("GetMember() of a missing member error = %v", err)
+	}
+	_, _, err = api.GetMember(9, 3)
+	if !errors.As(err, &api_err) || api_err.Retcode != apis.RetcodeBotNotAdded {
+		t.Errorf("GetMember() in a missing villa error = %v", err)
+	}
+}
+
+func TestInjectError(t *testing.T) {
+	srv, api := newTestServer(t)
+	srv.InjectError("sendMessage", 1, http.StatusOK, apis.RetcodeInvalidRequest, "injected")
+	if _, _, err := api.SendMessage(1, 2, "first"); err == nil {
+		t.Fatal("SendMessage() returned no injected error")
+	}
+	if _, _, err := api.SendMessage(1, 2, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := srv.LastMessage(); !ok || msg.Text() != "second" || len(srv.SentMessages()) != 1 {
+		t.Errorf("last message = %+v, %v", msg, ok)
+	}
+}
+
+func TestUploadImageDefaultHeaders(t *testing.T) {
+	srv, api := newTestServer(t)
+	api.SetDefaultHeader("X-Trace-Id", "trace-1")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 3))); err != nil {
+		t.Fatal(err)
+	}
+	res, _, err := api.UploadImageBytes(1, buf.Bytes(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := srv.UploadedImages()
+	if len(images) != 1 || images[0].NewURL != res.Data.NewURL || images[0].Ext != "png" {
+		t.Fatalf("uploaded images = %+v, response %+v", images, res.Data)
+	}
+	header := images[0].Header
+	if header.Get("X-Trace-Id") != "trace-1" {
+		t.Errorf("default header is not sent to the OSS host, headers = %v", header)
+	}
+	if header.Get("x-rpc-bot_id") != "" || header.Get("x-rpc-bot_secret") != "" {
+		t.Errorf("bot credentials are sent to the OSS host, headers = %v", header)
+	}
+}
+
+func TestGetVillaGroupRoomList(t *testing.T) {
+	srv, api := newTestServer(t)
+	group_id := srv.AddGroup(1, "other")
+	srv.AddRoom(1, group_id, 5, "room 5")
+	res, _, err := api.GetVillaGroupRoomList(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := res.Data.List
+	if len(list) != 2 || list[0].GroupName != "group" || list[1].GroupID != group_id {
+		t.Fatalf("list = %+v", list)
+	}
+	if rooms := list[1].RoomList; len(rooms) != 1 || rooms[0].RoomID != 5 || rooms[0].RoomName != "room 5" || rooms[0].GroupID != group_id {
+		t.Errorf("rooms of group %d = %+v", group_id, rooms)
+	}
+	if rooms := list[0].RoomList; len(rooms) != 1 || rooms[0].RoomID != 2 {
+		t.Errorf("rooms of the first group = %+v", rooms)
+	}
+}
@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -24,34 +25,31 @@ import (
 /* bot related */
 
 // internal
-func newBot(bot_id, bot_secret, bot_pubkey string) *Bot {
+func newBot(rt *Runtime, bot_id, bot_secret, bot_pubkey string) *Bot {
 	bot_pubkey = parsePubKey(bot_pubkey) // parse pubkey to appropriate format
 	bot_base := models.BotBase{ID: bot_id, Secret: bot_secret, PubKey: bot_pubkey, EncodedSecret: pubKeyEncryptSecret(bot_pubkey, bot_secret)}
 	_bot := Bot{
 		Base:                                 bot_base,
+		runtime:                              rt,
 		filter_manager:                       &filterManager{entries: make(map[string]time.Time)},
-		listeners_join_villa:                 []events.BotListenerJoinVilla{},
-		listeners_send_message:               []events.BotListenerSendMessage{},
-		listeners_create_robot:               []events.BotListenerCreateRobot{},
-		listeners_delete_robot:               []events.BotListenerDeleteRobot{},
-		listeners_add_quick_emoticon:         []events.BotListenerAddQuickEmoticon{},
-		listeners_audit_callback:             []events.BotListenerAuditCallback{},
+		shutdown:                             make(chan struct{}),
+		event_wg:                             &sync.WaitGroup{},
 		use_default_logger:                   false,
 		is_plugins_short_circuit_affect_main: false,
 		is_filter_self_msg:                   true,
 		is_verify_msg_signature:              true,
-		on_commands:                          []commands.OnCommand{},
-		preprocessors:                        []commands.Preprocessor{},
-		wait_for_command_registers:           []waitForCommandRegister{},
 		Api:                                  apis.MakeAPIBase(bot_base, 1*time.Minute),
 		Logger:                               logger.NewDefaultLogger(bot_id),
 	}
+	_bot.ctx, _bot.cancel_ctx = context.WithCancel(context.Background())
 	_bot.abstract_bot = &plugin.AbstractBot{
 		Api:            _bot.Api,
 		Logger:         _bot.Logger,
 		WaitForCommand: _bot.WaitForCommand,
 	}
 
+	_bot.scheduler = newJobScheduler(&_bot)
+
 	go _bot.filter_manager.loop()
 
 	return &_bot
@@ -67,21 +65,29 @@ func newBot(bot_id, bot_secret, bot_pubkey string) *Bot {
 //
 // 整体消息处理的运行与短路顺序为： [main]预处理器 -> [插件]预处理器 -> [插件]令处理器 -> [main]命令处理器 -> [main]事件监听器
 func NewBot(bot_id, bot_secret, bot_pubkey, path, addr string) *Bot {
-	_bot := newBot(bot_id, bot_secret, bot_pubkey)
+	return default_runtime.NewBot(bot_id, bot_secret, bot_pubkey, path, addr)
+}
+
+// 在该运行时中创建一个机器人实例，参数同 NewBot
+func (rt *Runtime) NewBot(bot_id, bot_secret, bot_pubkey, path, addr string) *Bot {
+	_bot := newBot(rt, bot_id, bot_secret, bot_pubkey)
 	// for normal http server bot
 	_bot.addr_key = addr
 	_bot.path_key = path
 
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
 	port_already_exists := false
 	var port_exists_svr_ptr *serverContext
 	port_path_already_exists := false
 
-	if other_svr_context_manager[addr] != nil {
+	if rt.other_servers[addr] != nil {
 		port_already_exists = true
-		port_exists_svr_ptr = other_svr_context_manager[addr]
-		delete(other_svr_context_manager, addr)
+		port_exists_svr_ptr = rt.other_servers[addr]
+		delete(rt.other_servers, addr)
 	} else {
-		for _, _bot_ctx := range bot_context_manager {
+		for _, _bot_ctx := range rt.bot_contexts {
 			if _bot_ctx.bot.Base.ID == bot_id {
 				panic(fmt.Sprintf("bot id of %s already exists", bot_id))
 			} else if _bot_ctx.bot.addr_key == addr {
@@ -97,21 +103,21 @@ func NewBot(bot_id, bot_secret, bot_pubkey, path, addr string) *Bot {
 		}
 	}
 
-	bot_context_manager[bot_id] = &botContext{bot: _bot}
+	rt.bot_contexts[bot_id] = &botContext{bot: _bot}
 
 	if port_path_already_exists {
 		port_exists_svr_ptr.bots[path] = append(port_exists_svr_ptr.bots[path], _bot)
 		_bot.svr = port_exists_svr_ptr.svr
-		bot_context_manager[bot_id].svr_ctx = port_exists_svr_ptr
+		rt.bot_contexts[bot_id].svr_ctx = port_exists_svr_ptr
 	} else if port_already_exists {
 		port_exists_svr_ptr.bots[path] = []*Bot{_bot}
 		_bot.svr = port_exists_svr_ptr.svr
-		bot_context_manager[bot_id].svr_ctx = port_exists_svr_ptr
+		rt.bot_contexts[bot_id].svr_ctx = port_exists_svr_ptr
 	} else {
 		_bots := map[string][]*Bot{path: {_bot}}
 		svr := gin.Default()
 		_bot.svr = svr
-		bot_context_manager[bot_id].svr_ctx = &serverContext{svr: svr, svr_addr: addr, is_running: false, wg: &sync.WaitGroup{}, bots: _bots, handles: map[string][]func(*gin.Context) bool{}}
+		rt.bot_contexts[bot_id].svr_ctx = &serverContext{svr: svr, svr_addr: addr, is_running: false, wg: &sync.WaitGroup{}, bots: _bots, handles: map[string][]func(*gin.Context) bool{}}
 	}
 
 	return _bot
@@ -127,9 +133,16 @@ func NewBot(bot_id, bot_secret, bot_pubkey, path, addr string) *Bot {
 //
 // 整体消息处理的运行与短路顺序为： [main]预处理器 -> [插件]预处理器 -> [插件]令处理器 -> [main]命令处理器 -> [main]事件监听器
 func NewWsBot(bot_id, bot_secret, bot_pubkey, ws_uri string) *Bot {
-	_bot := newBot(bot_id, bot_secret, bot_pubkey)
+	return default_runtime.NewWsBot(bot_id, bot_secret, bot_pubkey, ws_uri)
+}
+
+// 在该运行时中创建一个ws机器人实例，参数同 NewWsBot
+func (rt *Runtime) NewWsBot(bot_id, bot_secret, bot_pubkey, ws_uri string) *Bot {
+	_bot := newBot(rt, bot_id, bot_secret, bot_pubkey)
 	// for websocket client bot
-	bot_context_manager[bot_id] = &botContext{bot: _bot, ws_ctx: &wsContext{uri: ws_uri, is_running: false, wg: &sync.WaitGroup{}, bots: map[string][]*Bot{}}}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.bot_contexts[bot_id] = &botContext{bot: _bot, ws_ctx: &wsContext{uri: ws_uri, is_running: false, wg: &sync.WaitGroup{}, bots: map[string][]*Bot{}}}
 
 	return _bot
 }
@@ -139,6 +152,51 @@ func (_bot *Bot) SetAPITimeout(timeout time.Duration) {
 	_bot.Api.SetTimeout(timeout)
 }
 
+// 设置开放API的根地址，默认为 https://bbs-api.miyoushe.com ，可用于指向本地测试服务器
+func (_bot *Bot) SetAPIBaseURL(base_url string) {
+	_bot.Api.SetBaseURL(base_url)
+}
+
+// 设置API请求所使用的http.RoundTripper，默认为http.DefaultTransport
+func (_bot *Bot) SetAPITransport(transport http.RoundTripper) {
+	_bot.Api.SetTransport(transport)
+}
+
+// 设置API请求的重试策略（可使用 apis.DefaultRetryPolicy()），默认为nil不重试
+func (_bot *Bot) SetAPIRetryPolicy(policy *apis.RetryPolicy) {
+	_bot.Api.SetRetryPolicy(policy)
+}
+
+// 设置API请求的客户端限流器（使用 apis.NewRateLimiter() 创建），默认为nil不限流
+func (_bot *Bot) SetAPIRateLimiter(limiter *apis.RateLimiter) {
+	_bot.Api.SetRateLimiter(limiter)
+}
+
+// 设置成员、房间、身份组列表及别野信息的缓存（使用 apis.NewCache() 创建），默认为nil不缓存；相关事件会自动使缓存失效
+func (_bot *Bot) SetAPICache(cache *apis.Cache) {
+	_bot.Api.SetCache(cache)
+}
+
+// 设置超长文本消息的拆分策略（可使用 apis.DefaultMessageSplitPolicy()），默认为nil不拆分
+func (_bot *Bot) SetAPIMessageSplitPolicy(policy *apis.MessageSplitPolicy) {
+	_bot.Api.SetMessageSplitPolicy(policy)
+}
+
+// 使发送消息经过队列（使用 apis.NewOutboundQueue(bot.Api, ...) 创建），保证同一房间的消息按顺序发送，默认为nil直接发送
+func (_bot *Bot) SetAPIOutboundQueue(q *apis.OutboundQueue) {
+	_bot.Api.SetOutboundQueue(q)
+}
+
+// 添加API请求中间件，可获取每次调用的接口名、别野id、请求体及解码后的响应，详见 apis.ApiBase.Use
+func (_bot *Bot) UseAPI(middlewares ...apis.Middleware) {
+	_bot.Api.Use(middlewares...)
+}
+
+// 设置API请求都会附带的请求头，value为空字符串时移除该请求头
+func (_bot *Bot) SetAPIDefaultHeader(key, value string) {
+	_bot.Api.SetDefaultHeader(key, value)
+}
+
 // 设置bot的日志记录器，默认为os.Stdout+一个log档案
 func (_bot *Bot) SetLogger(logger logger.LoggerInterface) {
 	_bot.Logger = logger
@@ -157,14 +215,14 @@ func (_bot *Bot) SetPluginsShortCircuitAffectMain(is_affect bool) {
 // 设置是否启用某一插件
 func (_bot *Bot) SetPluginEnabled(plugin_name string, is_enable bool) {
 	if _bot.plugins == nil {
-		_bot.plugins = plugin.FetchPlugins() // load plugins from plugins context manager
+		_bot.plugins = _bot.runtime.plugins.Fetch() // load plugins from plugins context manager
 	}
 	_bot.plugins[plugin_name].IsEnable = is_enable
 }
 
 func (_bot *Bot) GetPluginNames() []string {
 	if _bot.plugins == nil {
-		_bot.plugins = plugin.FetchPlugins() // load plugins from plugins context manager
+		_bot.plugins = _bot.runtime.plugins.Fetch() // load plugins from plugins context manager
 	}
 	plugin_names := []string{}
 	for plugin_name := range _bot.plugins {
@@ -183,133 +241,138 @@ func (_bot *Bot) SetVerifyMsgSignature(is_verify bool) {
 	_bot.is_verify_msg_signature = is_verify
 }
 
-func (_bot *Bot) AddListenerJoinVilla(listener events.BotListenerJoinVilla) {
-	_bot.listeners_join_villa = append(_bot.listeners_join_villa, listener)
+// 返回的 Handle 可用于移除该监听器
+func (_bot *Bot) AddListenerJoinVilla(listener events.BotListenerJoinVilla) Handle {
+	return _bot.listeners_join_villa.add(listener)
+}
+
+// 返回的 Handle 可用于移除该监听器
+func (_bot *Bot) AddListenerSendMessage(listener events.BotListenerSendMessage) Handle {
+	return _bot.listeners_send_message.add(listener)
 }
 
-func (_bot *Bot) AddListenerSendMessage(listener events.BotListenerSendMessage) {
-	_bot.listeners_send_message = append(_bot.listeners_send_message, listener)
+// 返回的 Handle 可用于移除该监听器
+func (_bot *Bot) AddListenerCreateRobot(listener events.BotListenerCreateRobot) Handle {
+	return _bot.listeners_create_robot.add(listener)
 }
 
-func (_bot *Bot) AddListenerCreateRobot(listener events.BotListenerCreateRobot) {
-	_bot.listeners_create_robot = append(_bot.listeners_create_robot, listener)
+// 返回的 Handle 可用于移除该监听器
+func (_bot *Bot) AddListenerDeleteRobot(listener events.BotListenerDeleteRobot) Handle {
+	return _bot.listeners_delete_robot.add(listener)
 }
 
-func (_bot *Bot) AddListenerDeleteRobot(listener events.BotListenerDeleteRobot) {
-	_bot.listeners_delete_robot = append(_bot.listeners_delete_robot, listener)
+// 返回的 Handle 可用于移除该监听器
+func (_bot *Bot) AddListenerAddQuickEmoticon(listener events.BotListenerAddQuickEmoticon) Handle {
+	return _bot.listeners_add_quick_emoticon.add(listener)
 }
 
-func (_bot *Bot) AddListenerAddQuickEmoticon(listener events.BotListenerAddQuickEmoticon) {
-	_bot.listeners_add_quick_emoticon = append(_bot.listeners_add_quick_emoticon, listener)
+// 返回的 Handle 可用于移除该监听器
+func (_bot *Bot) AddListenerAuditCallback(listener events.BotListenerAuditCallback) Handle {
+	return _bot.listeners_audit_callback.add(listener)
 }
 
-func (_bot *Bot) AddListenerAuditCallback(listener events.BotListenerAuditCallback) {
-	_bot.listeners_audit_callback = append(_bot.listeners_audit_callback, listener)
+// 监听消息组件（如回传型按钮）被点击的事件，返回的 Handle 可用于移除该监听器
+func (_bot *Bot) AddListenerClickMsgComponent(listener events.BotListenerClickMsgComponent) Handle {
+	return _bot.listeners_click_msg_component.add(listener)
 }
 
 // 不对回调请求进行任何处理，直接返回到这里注册的监听器，允许用户自行处理回调请求（注意：将根据端口和路径发送回调请求，如使用同端口同路径多机器人，请自行分辨机器人）
-func (_bot *Bot) AddlistenerRawRequest(listener events.BotListenerRawRequest) {
-	_bot.listeners_raw_request = append(_bot.listeners_raw_request, listener)
+func (_bot *Bot) AddlistenerRawRequest(listener events.BotListenerRawRequest) Handle {
+	return _bot.listeners_raw_request.add(listener)
 }
 
+// compare listeners by function pointer, closures created by the same literal are regarded as the same
+func sameFunc(a, b interface{}) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+// Deprecated: 按函数指针比较，无法区分同一函数字面量创建的闭包，请使用 AddListenerJoinVilla 返回的 Handle
 func (_bot *Bot) RemoveListenerJoinVilla(listener events.BotListenerJoinVilla) {
-	for i, l := range _bot.listeners_join_villa {
-		if reflect.ValueOf(l).Pointer() == reflect.ValueOf(listener).Pointer() {
-			_bot.listeners_join_villa = append(_bot.listeners_join_villa[:i], _bot.listeners_join_villa[i+1:]...)
-			return
-		}
-	}
+	_bot.listeners_join_villa.removeFirst(func(l events.BotListenerJoinVilla) bool { return sameFunc(l, listener) })
 }
 
+// Deprecated: 按函数指针比较，无法区分同一函数字面量创建的闭包，请使用 AddListenerSendMessage 返回的 Handle
 func (_bot *Bot) RemoveListenerSendMessage(listener events.BotListenerSendMessage) {
-	for i, l := range _bot.listeners_send_message {
-		if reflect.ValueOf(l).Pointer() == reflect.ValueOf(listener).Pointer() {
-			_bot.listeners_send_message = append(_bot.listeners_send_message[:i], _bot.listeners_send_message[i+1:]...)
-			return
-		}
-	}
+	_bot.listeners_send_message.removeFirst(func(l events.BotListenerSendMessage) bool { return sameFunc(l, listener) })
 }
 
+// Deprecated: 按函数指针比较，无法区分同一函数字面量创建的闭包，请使用 AddListenerCreateRobot 返回的 Handle
 func (_bot *Bot) RemoveListenerCreateRobot(listener events.BotListenerCreateRobot) {
-	for i, l := range _bot.listeners_create_robot {
-		if reflect.ValueOf(l).Pointer() == reflect.ValueOf(listener).Pointer() {
-			_bot.listeners_create_robot = append(_bot.listeners_create_robot[:i], _bot.listeners_create_robot[i+1:]...)
-			return
-		}
-	}
+	_bot.listeners_create_robot.removeFirst(func(l events.BotListenerCreateRobot) bool { return sameFunc(l, listener) })
 }
 
+// Deprecated: 按函数指针比较，无法区分同一函数字面量创建的闭包，请使用 AddListenerDeleteRobot 返回的 Handle
 func (_bot *Bot) RemoveListenerDeleteRobot(listener events.BotListenerDeleteRobot) {
-	for i, l := range _bot.listeners_delete_robot {
-		if reflect.ValueOf(l).Pointer() == reflect.ValueOf(listener).Pointer() {
-			_bot.listeners_delete_robot = append(_bot.listeners_delete_robot[:i], _bot.listeners_delete_robot[i+1:]...)
-			return
-		}
-	}
+	_bot.listeners_delete_robot.removeFirst(func(l events.BotListenerDeleteRobot) bool { return sameFunc(l, listener) })
 }
 
+// Deprecated: 按函数指针比较，无法区分同一函数字面量创建的闭包，请使用 AddListenerAddQuickEmoticon 返回的 Handle
 func (_bot *Bot) RemoveListenerAddQuickEmoticon(listener events.BotListenerAddQuickEmoticon) {
-	for i, l := range _bot.listeners_add_quick_emoticon {
-		if reflect.ValueOf(l).Pointer() == reflect.ValueOf(listener).Pointer() {
-			_bot.listeners_add_quick_emoticon = append(_bot.listeners_add_quick_emoticon[:i], _bot.listeners_add_quick_emoticon[i+1:]...)
-			return
-		}
-	}
+	_bot.listeners_add_quick_emoticon.removeFirst(func(l events.BotListenerAddQuickEmoticon) bool { return sameFunc(l, listener) })
 }
 
+// Deprecated: 按函数指针比较，无法区分同一函数字面量创建的闭包，请使用 AddListenerAuditCallback 返回的 Handle
 func (_bot *Bot) RemoveListenerAuditCallback(listener events.BotListenerAuditCallback) {
-	for i, l := range _bot.listeners_audit_callback {
-		if reflect.ValueOf(l).Pointer() == reflect.ValueOf(listener).Pointer() {
-			_bot.listeners_audit_callback = append(_bot.listeners_audit_callback[:i], _bot.listeners_audit_callback[i+1:]...)
-			return
-		}
-	}
+	_bot.listeners_audit_callback.removeFirst(func(l events.BotListenerAuditCallback) bool { return sameFunc(l, listener) })
 }
 
+// Deprecated: 按函数指针比较，无法区分同一函数字面量创建的闭包，请使用 AddListenerClickMsgComponent 返回的 Handle
+func (_bot *Bot) RemoveListenerClickMsgComponent(listener events.BotListenerClickMsgComponent) {
+	_bot.listeners_click_msg_component.removeFirst(func(l events.BotListenerClickMsgComponent) bool { return sameFunc(l, listener) })
+}
+
+// Deprecated: 按函数指针比较，无法区分同一函数字面量创建的闭包，请使用 AddlistenerRawRequest 返回的 Handle
 func (_bot *Bot) RemovelistenerRawRequest(listener events.BotListenerRawRequest) {
-	for i, l := range _bot.listeners_raw_request {
-		if reflect.ValueOf(l).Pointer() == reflect.ValueOf(listener).Pointer() {
-			_bot.listeners_raw_request = append(_bot.listeners_raw_request[:i], _bot.listeners_raw_request[i+1:]...)
-			return
-		}
-	}
+	_bot.listeners_raw_request.removeFirst(func(l events.BotListenerRawRequest) bool { return sameFunc(l, listener) })
 }
 
 func (_bot *Bot) AddOnCommand(plugin commands.OnCommand) error {
-	_bot.on_commands = append(_bot.on_commands, plugin)
+	_bot.on_commands.add(plugin)
 	return nil
 }
 
+// 同 AddOnCommand，返回的 Handle 可用于移除该指令
+func (_bot *Bot) AddOnCommandHandle(plugin commands.OnCommand) Handle {
+	return _bot.on_commands.add(plugin)
+}
+
 func (_bot *Bot) RemoveOnCommand(plugin commands.OnCommand) error {
-	for i, p := range _bot.on_commands {
-		if p.Equals(plugin) {
-			_bot.on_commands = append(_bot.on_commands[:i], _bot.on_commands[i+1:]...)
-			return nil
-		}
+	if !_bot.on_commands.removeFirst(func(p commands.OnCommand) bool { return p.Equals(plugin) }) {
+		return errors.New("plugin not found")
 	}
-	return errors.New("plugin not found")
+	return nil
 }
 
 func (_bot *Bot) AddPreprocessor(preprocessor commands.Preprocessor) error {
-	_bot.preprocessors = append(_bot.preprocessors, preprocessor)
+	_bot.preprocessors.add(preprocessor)
 	return nil
 }
 
+// 同 AddPreprocessor，返回的 Handle 可用于移除该预处理器
+func (_bot *Bot) AddPreprocessorHandle(preprocessor commands.Preprocessor) Handle {
+	return _bot.preprocessors.add(preprocessor)
+}
+
+// Deprecated: 按函数指针比较，无法区分同一函数字面量创建的闭包，请使用 AddPreprocessorHandle 返回的 Handle
 func (_bot *Bot) RemovePreprocessor(preprocessor commands.Preprocessor) error {
-	for i, p := range _bot.preprocessors {
-		if reflect.ValueOf(p).Pointer() == reflect.ValueOf(preprocessor).Pointer() {
-			_bot.preprocessors = append(_bot.preprocessors[:i], _bot.preprocessors[i+1:]...)
-			return nil
-		}
+	if !_bot.preprocessors.removeFirst(func(p commands.Preprocessor) bool { return sameFunc(p, preprocessor) }) {
+		return errors.New("preprocessor not found")
 	}
-	return errors.New("preprocessor not found")
+	return nil
 }
 
 func (_bot *Bot) processHandlesBeforeStart() {
-	svr_ctx := bot_context_manager[_bot.Base.ID].svr_ctx
+	rt := _bot.runtime
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	svr_ctx := rt.bot_contexts[_bot.Base.ID].svr_ctx
 	if svr_ctx == nil {
 		panic("server context not found")
 	}
+	if svr_ctx.is_handles_processed { // gin panics on registering the same route twice
+		return
+	}
+	svr_ctx.is_handles_processed = true
 	// process handles that collides with bot's path
 	for p, b := range svr_ctx.bots {
 		_p := p
@@ -317,13 +380,13 @@ func (_bot *Bot) processHandlesBeforeStart() {
 		handles, ok := svr_ctx.handles[_p]
 		if ok {
 			handles = append(handles, func(c *gin.Context) bool {
-				hook(_b, c)
+				rt.hook(_b, c)
 				return false
 			})
 			delete(svr_ctx.handles, _p)
 		} else {
 			handles = []func(*gin.Context) bool{func(c *gin.Context) bool {
-				hook(_b, c)
+				rt.hook(_b, c)
 				return false
 			}}
 		}
@@ -360,39 +423,53 @@ func (_bot *Bot) processHandlesBeforeStart() {
 
 // internal start http bot server
 func (_bot *Bot) startBotAsHttp(_bot_ctx *botContext) error {
-	if _bot_ctx.svr_ctx.is_running {
+	if !_bot_ctx.svr_ctx.begin() {
 		_bot_ctx.svr_ctx.wg.Wait()
 		return nil
+	}
+	defer _bot_ctx.svr_ctx.end()
+	_bot.processHandlesBeforeStart()
+	err := _bot_ctx.svr_ctx.serve()
+	if err != nil {
+		_bot.Logger.Errorf("机器人 {%v} 停止监听 localhost%v : %v\n", _bot.Base.ID, _bot.addr_key, err)
 	} else {
-		_bot_ctx.svr_ctx.is_running = true
-		_bot.processHandlesBeforeStart()
-		_bot_ctx.svr_ctx.wg.Add(1)
-		defer _bot_ctx.svr_ctx.wg.Done()
-		err := _bot.svr.Run(_bot.addr_key)
-		_bot.Logger.Error("机器人 {%v} 停止监听 localhost%v : %v\n", _bot.Base.ID, _bot.addr_key, err)
-		return err
+		_bot.Logger.Infof("机器人 {%v} 停止监听 localhost%v\n", _bot.Base.ID, _bot.addr_key)
 	}
+	return err
 }
 
 // internal start websocket bot client
 func (_bot *Bot) startBotAsWs(_bot_ctx *botContext) error {
-	if _bot_ctx.ws_ctx.is_running {
+	if !_bot_ctx.ws_ctx.begin() {
 		_bot_ctx.ws_ctx.wg.Wait()
 		return nil
-	} else {
-		_bot_ctx.ws_ctx.is_running = true
-		_bot_ctx.ws_ctx.wg.Add(1)
-		defer _bot_ctx.ws_ctx.wg.Done()
-		err := _bot.wsClientLoop(_bot_ctx.ws_ctx.uri)
-		_bot.Logger.Error("机器人 {%v} 停止监听 %v : %v\n", _bot.Base.ID, _bot_ctx.ws_ctx.uri, err)
-		return err
 	}
+	defer _bot_ctx.ws_ctx.end()
+	_bot.wsClientLoop(_bot_ctx.ws_ctx)
+	_bot.Logger.Infof("机器人 {%v} 停止监听 %v\n", _bot.Base.ID, _bot_ctx.ws_ctx.uri)
+	return nil
 }
 
-func (_bot *Bot) Start() error {
+// internal mark bot as running and load plugins
+func (_bot *Bot) prepareStart() {
+	_bot.state_mu.Lock()
+	if _bot.is_running {
+		_bot.state_mu.Unlock()
+		return
+	}
 	_bot.is_running = true
+	select {
+	case <-_bot.shutdown: // restart after Stop
+		_bot.shutdown = make(chan struct{})
+		_bot.ctx, _bot.cancel_ctx = context.WithCancel(context.Background())
+		_bot.event_wg = &sync.WaitGroup{} // the last Stop may be still waiting for the old one
+	default:
+	}
+	_bot.startWorkerPoolLocked()
+	_bot.state_mu.Unlock()
+	_bot.scheduler.start()
 	if _bot.plugins == nil {
-		_bot.plugins = plugin.FetchPlugins() // load plugins from plugins context manager
+		_bot.plugins = _bot.runtime.plugins.Fetch() // load plugins from plugins context manager
 	}
 
 	for _plugin_name, _plugin := range _bot.plugins {
@@ -404,14 +481,29 @@ func (_bot *Bot) Start() error {
 		}
 		_bot.Logger.Infof("机器人 {%v} 加载了插件 %s (%s)\n", _bot.Base.ID, _plugin_name, _enable)
 	}
+}
+
+// 返回机器人所在HTTP服务器的http.Handler，并将机器人设为运行状态但不监听端口（ws机器人返回nil）
+//
+// 可用于把机器人嵌入到其他HTTP服务器中，或在测试中直接注入回调请求（参考 eventsim 模块）
+func (_bot *Bot) Handler() http.Handler {
+	_bot_ctx := _bot.runtime.getBotContext(_bot.Base.ID)
+	if _bot_ctx == nil || _bot_ctx.svr_ctx == nil {
+		return nil
+	}
+	_bot.prepareStart()
+	_bot.processHandlesBeforeStart()
+	return _bot.svr
+}
+
+// 启动机器人，阻塞直到 Stop() 或 Shutdown() 被调用（正常停止时返回nil）或监听失败
+func (_bot *Bot) Start() error {
+	_bot.prepareStart()
 
 	_bot.Logger.Infof("机器人 {%v} 于 localhost%v 开始运行\n", _bot.Base.ID, _bot.addr_key)
-	var _bot_ctx *botContext
-	for _, bot_ctx := range bot_context_manager {
-		if bot_ctx.bot == _bot {
-			_bot_ctx = bot_ctx
-			break
-		}
+	_bot_ctx := _bot.runtime.getBotContext(_bot.Base.ID)
+	if _bot_ctx == nil || _bot_ctx.bot != _bot {
+		return errors.New("bot not found in runtime")
 	}
 	if _bot_ctx.ws_ctx != nil {
 		return _bot.startBotAsWs(_bot_ctx)
@@ -420,9 +512,15 @@ func (_bot *Bot) Start() error {
 	}
 }
 
+// 开始运行默认运行时的所有机器人，阻塞直到所有机器人停止
 func StartAllBot() {
+	default_runtime.StartAllBot()
+}
+
+// 开始运行该运行时的所有机器人，阻塞直到所有机器人停止
+func (rt *Runtime) StartAllBot() {
 	var wg sync.WaitGroup
-	for _, bot_ctx := range bot_context_manager {
+	for _, bot_ctx := range rt.botContexts() {
 		wg.Add(1)
 		go func(_bot *Bot) {
 			defer wg.Done()
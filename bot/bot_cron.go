@@ -0,0 +1,225 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* schedule specs used by the scheduler
+ *
+ * cron表达式：分 时 日 月 周（5段），或 秒 分 时 日 月 周（6段），支持 * 、 a-b 、步长（在 * 或 a-b 后加上 /n）及逗号分隔的列表，
+ * 月份可使用JAN~DEC，周可使用SUN~SAT（0与7均为周日）；日与周同时指定时满足其一即可（与标准cron相同）
+ * 预定义：@yearly、@monthly、@weekly、@daily（或@midnight）、@hourly
+ * 间隔：@every 10m（首次在添加后10分钟执行）
+ * 单次：@at 2006-01-02T15:04:05+08:00（RFC3339）或 @at 2006-01-02 15:04（使用任务的时区），@in 10m（添加时转换为@at） */
+
+type schedule interface {
+	next(after time.Time) time.Time // zero time if there is no next run
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var cron_fields = []cronField{
+	{0, 59, nil}, // second
+	{0, 59, nil}, // minute
+	{0, 23, nil}, // hour
+	{1, 31, nil}, // day of month
+	{1, 12, map[string]int{"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12}}, // month
+	{0, 7, map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}}, // day of week
+}
+
+var cron_descriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+type cronSchedule struct {
+	fields   [6]uint64 // bit sets of allowed values
+	dom_star bool
+	dow_star bool
+	location *time.Location
+}
+
+type everySchedule struct {
+	interval time.Duration
+}
+
+type onceSchedule struct {
+	at time.Time
+}
+
+func (s everySchedule) next(after time.Time) time.Time {
+	return after.Add(s.interval)
+}
+
+func (s onceSchedule) next(after time.Time) time.Time {
+	if s.at.After(after) {
+		return s.at
+	}
+	return time.Time{}
+}
+
+// parse spec in location, @in is relative to now
+func parseSchedule(spec string, location *time.Location, now time.Time) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expr, ok := cron_descriptors[spec]; ok {
+		return parseCron(expr, location)
+	}
+	switch {
+	case strings.HasPrefix(spec, "@every "):
+		interval, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid interval in %q", spec)
+		}
+		return everySchedule{interval: interval}, nil
+	case strings.HasPrefix(spec, "@in "):
+		delay, err := time.ParseDuration(strings.TrimSpace(spec[len("@in "):]))
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("invalid delay in %q", spec)
+		}
+		return onceSchedule{at: now.Add(delay).Round(time.Second)}, nil
+	case strings.HasPrefix(spec, "@at "):
+		value := strings.TrimSpace(spec[len("@at "):])
+		if at, err := time.Parse(time.RFC3339, value); err == nil {
+			return onceSchedule{at: at}, nil
+		}
+		for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04"} {
+			if at, err := time.ParseInLocation(layout, value, location); err == nil {
+				return onceSchedule{at: at}, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid time in %q", spec)
+	case strings.HasPrefix(spec, "@"):
+		return nil, fmt.Errorf("unknown schedule %q", spec)
+	}
+	return parseCron(spec, location)
+}
+
+func parseCron(expr string, location *time.Location) (*cronSchedule, error) {
+	parts := strings.Fields(expr)
+	switch len(parts) {
+	case 5:
+		parts = append([]string{"0"}, parts...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 or 6 fields", expr)
+	}
+	s := &cronSchedule{location: location, dom_star: parts[3] == "*" || parts[3] == "?", dow_star: parts[5] == "*" || parts[5] == "?"}
+	for i, part := range parts {
+		bits, err := parseCronField(part, cron_fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+		s.fields[i] = bits
+	}
+	if s.fields[5]&(1<<7) != 0 { // 7 is also sunday
+		s.fields[5] |= 1
+	}
+	return s, nil
+}
+
+func parseCronValue(value string, field cronField) (int, error) {
+	if v, ok := field.names[strings.ToUpper(value)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < field.min || v > field.max {
+		return 0, fmt.Errorf("value %q out of range [%d, %d]", value, field.min, field.max)
+	}
+	return v, nil
+}
+
+func parseCronField(part string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(part, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+			item = item[:i]
+		}
+		low, high := field.min, field.max
+		switch {
+		case item == "*" || item == "?":
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if low, err = parseCronValue(bounds[0], field); err != nil {
+				return 0, err
+			}
+			if high, err = parseCronValue(bounds[1], field); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q", item)
+			}
+		default:
+			v, err := parseCronValue(item, field)
+			if err != nil {
+				return 0, err
+			}
+			low = v
+			if step == 1 {
+				high = v
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) match(i int, v int) bool {
+	return s.fields[i]&(1<<uint(v)) != 0
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	dom, dow := s.match(3, t.Day()), s.match(5, int(t.Weekday()))
+	if s.dom_star || s.dow_star {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (s *cronSchedule) next(after time.Time) time.Time {
+	t := after.In(s.location).Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.match(4, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.match(2, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.match(1, t.Minute()) {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		if !s.match(0, t.Second()) {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
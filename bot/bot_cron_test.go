@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	utc := time.UTC
+	cst := time.FixedZone("CST", 8*60*60)
+	date := func(location *time.Location, values ...int) time.Time {
+		return time.Date(values[0], time.Month(values[1]), values[2], values[3], values[4], values[5], 0, location)
+	}
+	cases := []struct {
+		spec     string
+		location *time.Location
+		after    time.Time
+		want     time.Time
+	}{
+		// month rollover
+		{"0 0 31 * *", utc, date(utc, 2024, 4, 1, 0, 0, 0), date(utc, 2024, 5, 31, 0, 0, 0)},
+		{"0 0 29 2 *", utc, date(utc, 2023, 3, 1, 0, 0, 0), date(utc, 2024, 2, 29, 0, 0, 0)},
+		{"59 23 31 12 *", utc, date(utc, 2024, 12, 31, 23, 59, 0), date(utc, 2025, 12, 31, 23, 59, 0)},
+		{"@monthly", utc, date(utc, 2024, 1, 31, 12, 0, 0), date(utc, 2024, 2, 1, 0, 0, 0)},
+		{"0 0 1 JAN,jul *", utc, date(utc, 2024, 2, 1, 0, 0, 0), date(utc, 2024, 7, 1, 0, 0, 0)},
+		// day of month or day of week when both are set
+		{"0 9 13 * 5", utc, date(utc, 2024, 9, 1, 0, 0, 0), date(utc, 2024, 9, 6, 9, 0, 0)},
+		{"0 9 13 * 5", utc, date(utc, 2024, 9, 6, 9, 0, 0), date(utc, 2024, 9, 13, 9, 0, 0)},
+		{"0 9 1 * MON", utc, date(utc, 2024, 7, 30, 0, 0, 0), date(utc, 2024, 8, 1, 9, 0, 0)},
+		{"0 9 1 * MON", utc, date(utc, 2024, 8, 1, 9, 0, 0), date(utc, 2024, 8, 5, 9, 0, 0)},
+		// day of week only, 7 is sunday
+		{"0 9 * * 7", utc, date(utc, 2024, 9, 1, 10, 0, 0), date(utc, 2024, 9, 8, 9, 0, 0)},
+		{"0 9 ? * 1-5", utc, date(utc, 2024, 9, 7, 0, 0, 0), date(utc, 2024, 9, 9, 9, 0, 0)},
+		// day of month only
+		{"0 9 15 * *", utc, date(utc, 2024, 9, 15, 9, 0, 0), date(utc, 2024, 10, 15, 9, 0, 0)},
+		// seconds, steps and ranges
+		{"*/15 * * * * *", utc, date(utc, 2024, 1, 1, 12, 0, 7), date(utc, 2024, 1, 1, 12, 0, 15)},
+		{"0 8-18/5 * * *", utc, date(utc, 2024, 1, 1, 9, 0, 0), date(utc, 2024, 1, 1, 13, 0, 0)},
+		{"30 */6 * * *", utc, date(utc, 2024, 1, 1, 18, 30, 0), date(utc, 2024, 1, 2, 0, 30, 0)},
+		// timezone
+		{"0 8 * * *", cst, date(utc, 2024, 1, 1, 0, 30, 0), date(utc, 2024, 1, 2, 0, 0, 0)},
+		{"0 8 * * *", cst, date(utc, 2023, 12, 31, 23, 30, 0), date(utc, 2024, 1, 1, 0, 0, 0)},
+		{"0 0 1 * *", cst, date(utc, 2024, 1, 31, 16, 0, 0), date(utc, 2024, 2, 29, 16, 0, 0)},
+		{"@daily", cst, date(utc, 2024, 1, 1, 15, 0, 0), date(utc, 2024, 1, 1, 16, 0, 0)},
+		// never
+		{"0 0 30 2 *", utc, date(utc, 2024, 1, 1, 0, 0, 0), time.Time{}},
+	}
+	for _, c := range cases {
+		sched, err := parseSchedule(c.spec, c.location, c.after)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %v", c.spec, err)
+			continue
+		}
+		if got := sched.next(c.after); !got.Equal(c.want) {
+			t.Errorf("%q (%v).next(%v) = %v, want %v", c.spec, c.location, c.after, got, c.want)
+		}
+	}
+}
+
+func TestScheduleEveryAndOnce(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cst := time.FixedZone("CST", 8*60*60)
+	cases := []struct {
+		spec     string
+		location *time.Location
+		want     time.Time
+	}{
+		{"@every 90m", time.UTC, now.Add(90 * time.Minute)},
+		{"@in 10m", time.UTC, now.Add(10 * time.Minute)},
+		{"@at 2024-01-01T13:00:00+08:00", time.UTC, time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC)},
+		{"@at 2024-01-01 21:00", cst, time.Date(2024, 1, 1, 13, 0, 0, 0, time.UTC)},
+		{"@at 2024-01-01 12:00:30", time.UTC, now.Add(30 * time.Second)},
+	}
+	for _, c := range cases {
+		sched, err := parseSchedule(c.spec, c.location, now)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %v", c.spec, err)
+			continue
+		}
+		got := sched.next(now)
+		if c.want.After(now) && !got.Equal(c.want) || !c.want.After(now) && !got.IsZero() {
+			t.Errorf("%q.next(%v) = %v, want %v", c.spec, now, got, c.want)
+		}
+	}
+	once, _ := parseSchedule("@in 10m", time.UTC, now)
+	if at := once.next(now.Add(10 * time.Minute)); !at.IsZero() {
+		t.Errorf("one-shot schedule runs again at %v", at)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * FOO *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"@every",
+		"@every 0s",
+		"@every soon",
+		"@in -1m",
+		"@at tomorrow",
+		"@weekdays",
+	} {
+		if _, err := parseSchedule(spec, time.UTC, time.Now()); err == nil {
+			t.Errorf("parseSchedule(%q) returned no error", spec)
+		}
+	}
+}
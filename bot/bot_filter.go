@@ -10,12 +10,6 @@ type filterManager struct {
 	entries map[string]time.Time
 }
 
-func (fm *filterManager) add(id string) {
-	fm.mu.Lock()
-	defer fm.mu.Unlock()
-	fm.entries[id] = time.Now()
-}
-
 func (fm *filterManager) loop() {
 	for {
 		time.Sleep(time.Minute * 5)
@@ -29,8 +23,14 @@ func (fm *filterManager) loop() {
 	}
 }
 
-// check if id in entries, if already exists, return true that need filter
-func (fm *filterManager) needFilter(id string) bool {
-	_, ok := fm.entries[id]
-	return ok
+// check if id in entries, if already exists, return true that need filter, otherwise record it;
+// checking and recording are done under the same lock so that concurrent deliveries of the same event are filtered
+func (fm *filterManager) filter(id string) bool {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if _, ok := fm.entries[id]; ok {
+		return true
+	}
+	fm.entries[id] = time.Now()
+	return false
 }
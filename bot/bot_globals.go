@@ -1,6 +1,8 @@
 package bot
 
 import (
+	"context"
+	"net/http"
 	"sync"
 
 	apis "github.com/GLGDLY/mhy_botsdk/apis"
@@ -11,56 +13,75 @@ import (
 	plugin "github.com/GLGDLY/mhy_botsdk/plugins"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 type Bot struct {
-	Base           models.BotBase // 机器人基本信息
-	path_key       string
-	addr_key       string
-	svr            *gin.Engine
-	filter_manager *filterManager      // used to filter event that passed repeatly in a short time
-	abstract_bot   *plugin.AbstractBot // bot的抽象类，用于为插件提供基础机器人功能
-	is_running     bool                // 是否正在运行
+	Base                models.BotBase // 机器人基本信息
+	path_key            string
+	addr_key            string
+	svr                 *gin.Engine
+	filter_manager      *filterManager      // used to filter event that passed repeatly in a short time
+	abstract_bot        *plugin.AbstractBot // bot的抽象类，用于为插件提供基础机器人功能
+	runtime             *Runtime            // 机器人所属的运行时
+	is_running          bool                // 是否正在运行
+	state_mu            sync.RWMutex        // 保护is_running、shutdown及ctx
+	shutdown            chan struct{}       // Stop时关闭，用于结束等待中的 WaitForCommand 等
+	ctx                 context.Context     // 所有事件的context.Context的父context，Stop时取消
+	cancel_ctx          context.CancelFunc  // 取消ctx
+	event_wg            *sync.WaitGroup     // 正在处理的事件，Stop时等待其完成；重新启动时使用新的WaitGroup，不受上次未完成的Stop影响
+	worker_pool         *workerPool         // 处理事件的工作池，nil时每个事件使用一个新的goroutine
+	worker_pool_options *WorkerPoolOptions  // 工作池设置，Start时据此创建工作池
 	/* 事件监听器开始 */
-	listeners_join_villa         []events.BotListenerJoinVilla
-	listeners_send_message       []events.BotListenerSendMessage
-	listeners_create_robot       []events.BotListenerCreateRobot
-	listeners_delete_robot       []events.BotListenerDeleteRobot
-	listeners_add_quick_emoticon []events.BotListenerAddQuickEmoticon
-	listeners_audit_callback     []events.BotListenerAuditCallback
-	listeners_raw_request        []events.BotListenerRawRequest
+	listeners_join_villa          registry[events.BotListenerJoinVilla]
+	listeners_send_message        registry[events.BotListenerSendMessage]
+	listeners_create_robot        registry[events.BotListenerCreateRobot]
+	listeners_delete_robot        registry[events.BotListenerDeleteRobot]
+	listeners_add_quick_emoticon  registry[events.BotListenerAddQuickEmoticon]
+	listeners_audit_callback      registry[events.BotListenerAuditCallback]
+	listeners_click_msg_component registry[events.BotListenerClickMsgComponent]
+	listeners_raw_request         registry[events.BotListenerRawRequest]
+	middlewares                   registry[EventMiddleware] // 包裹所有事件处理的中间件
 	/* 事件监听器结束 */
 	/* reverse proxy start */
 	reverse_proxy_http_msg_chan []chan [2][]byte // [body, sign]
 	reverse_proxy_ws_msg_chan   []chan [2][]byte // [body, sign]
 	/* reverse proxy end */
-	use_default_logger                   bool                      // 是否使用默认的日志记录器，默认为false
-	is_plugins_short_circuit_affect_main bool                      // 插件中的指令短路是否会影响主程序其余指令和监听器的执行，默认为false
-	is_filter_self_msg                   bool                      // 是否过滤自己发送的消息，默认为true
-	is_verify_msg_signature              bool                      // 是否验证接受到事件的签名，默认为true
-	plugins                              map[string]*plugin.Plugin // 插件列表
-	on_commands                          []commands.OnCommand      // 处理消息事件的指令列表
-	preprocessors                        []commands.Preprocessor   // 消息事的预处理器，用于在运行指令列表和监听器之前处理事件
-	wait_for_command_registers           []waitForCommandRegister  // 用户处理消息时暂停等待指令的处理列表
-	Api                                  *apis.ApiBase             // api接口
-	Logger                               logger.LoggerInterface    // 日志记录器
+	use_default_logger                   bool                                // 是否使用默认的日志记录器，默认为false
+	is_plugins_short_circuit_affect_main bool                                // 插件中的指令短路是否会影响主程序其余指令和监听器的执行，默认为false
+	is_filter_self_msg                   bool                                // 是否过滤自己发送的消息，默认为true
+	is_verify_msg_signature              bool                                // 是否验证接受到事件的签名，默认为true
+	plugins                              map[string]*plugin.Plugin           // 插件列表
+	on_commands                          registry[commands.OnCommand]        // 处理消息事件的指令列表
+	preprocessors                        registry[commands.Preprocessor]     // 消息事的预处理器，用于在运行指令列表和监听器之前处理事件
+	wait_for_command_registers           registry[*waitForCommandRegister]   // 用户处理消息时暂停等待指令的处理列表
+	wait_for_component_registers         registry[*waitForComponentRegister] // 暂停等待消息组件被点击的处理列表
+	scheduler                            *jobScheduler                       // 定时任务调度器
+	Api                                  *apis.ApiBase                       // api接口
+	Logger                               logger.LoggerInterface              // 日志记录器
 }
 
 /* context managers start */
 type serverContext struct {
-	svr        *gin.Engine
-	svr_addr   string
-	is_running bool
-	wg         *sync.WaitGroup
-	bots       map[string][]*Bot                    // path: bot
-	handles    map[string][]func(*gin.Context) bool // path: handler
+	svr                  *gin.Engine
+	svr_addr             string
+	is_running           bool // 由mu保护
+	is_handles_processed bool // 是否已将bots和handles注册到gin路由
+	wg                   *sync.WaitGroup
+	mu                   sync.Mutex                           // 保护is_running及http_svr
+	http_svr             *http.Server                         // 正在运行的http服务器，用于Shutdown
+	bots                 map[string][]*Bot                    // path: bot
+	handles              map[string][]func(*gin.Context) bool // path: handler
 }
 
 type wsContext struct {
 	uri        string
-	is_running bool
+	is_running bool // 由mu保护
 	wg         *sync.WaitGroup
 	bots       map[string][]*Bot // path: bot
+	mu         sync.Mutex        // 保护is_running、stop及conn
+	stop       chan struct{}     // 关闭时停止重连
+	conn       *websocket.Conn   // 当前的ws连接
 }
 
 type botContext struct {
@@ -69,17 +90,26 @@ type botContext struct {
 	ws_ctx  *wsContext
 }
 
-var bot_context_manager map[string]*botContext = make(map[string]*botContext) // id: bot
-var other_svr_context_manager map[string]*serverContext = make(map[string]*serverContext)
+type Runtime struct {
+	mu            sync.RWMutex
+	bot_contexts  map[string]*botContext    // id: bot
+	other_servers map[string]*serverContext // addr: server，仅包含路由和反向代理而没有机器人的HTTP服务器
+	plugins       *plugin.Registry          // 插件注册表
+}
 
 /* context managers end */
 
 /* Global */
 
 // 开始运行所有机器人和 HTTP 服务器
+func (rt *Runtime) StartAll() {
+	rt.StartAllBot()
+	rt.StartAllHttpServer()
+}
+
+// 开始运行默认运行时的所有机器人和 HTTP 服务器
 func StartAll() {
-	StartAllBot()
-	StartAllHttpServer()
+	default_runtime.StartAll()
 }
 
 func init() {
@@ -1,7 +1,9 @@
 package bot
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,24 +16,35 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-func processEvent(_bot *Bot, event events.Event) {
+// awaited is true if the event is already filtered and handed to the wait-for registers by deliverAwaited
+func processEvent(_bot *Bot, event events.Event, awaited bool) {
 	event_id := event.Event.Id
-	if _bot.filter_manager.needFilter(event_id) {
+	if !awaited && _bot.filter_manager.filter(event_id) {
 		_bot.Logger.Debugf("filter repeat event: %v+\n", event)
 		return
-	} else {
-		_bot.filter_manager.add(event_id)
 	}
 
 	if _bot.use_default_logger {
 		_bot.Logger.Debugf("receive event: %v+\n", event)
 	}
+	ctx := newEventContext(_bot, event)
+	defer ctx.cancel()
+	ctx.run(_bot.middlewares.snapshot(), func() {
+		event := ctx.Event
+		// expose the values to the handlers, with a context not cancelled by the end of the processing
+		event.Event.EventBase.SetContext(handlerContext{Context: _bot.context(), values: ctx.Context()}, ctx)
+		handleEvent(_bot, event, awaited)
+	})
+}
+
+// run the preprocessors, wait-for registers (unless awaited), plugins, commands and listeners of the event
+func handleEvent(_bot *Bot, event events.Event, awaited bool) {
 	event_type := event.Event.Type
 switch_label:
 	switch event_type {
 	case events.JoinVilla:
 		event := events.Event2EventJoinVilla(event)
-		for _, listener := range _bot.listeners_join_villa {
+		for _, listener := range _bot.listeners_join_villa.snapshot() {
 			utils.Try(func() { listener(event) }, func(err interface{}, tb string) {
 				_bot.Logger.Error("listener {", utils.GetFunctionName(listener), "} error: ", err, "\n", tb)
 			})
@@ -42,13 +55,13 @@ switch_label:
 			break switch_label
 		}
 		// 1. run preprocessors
-		for _, _preprocessor := range _bot.preprocessors {
+		for _, _preprocessor := range _bot.preprocessors.snapshot() {
 			utils.Try(func() { _preprocessor(event) }, func(err interface{}, tb string) {
 				_bot.Logger.Error("preprocessor {", utils.GetFunctionName(_preprocessor), "} error: ", err, "\n", tb)
 			})
 		}
 		// 2. run wait_for command registers
-		if _bot.checkWaifForCommand(event) {
+		if !awaited && _bot.checkWaifForCommand(event) {
 			break switch_label
 		}
 		// 3. run plugins
@@ -81,41 +94,51 @@ switch_label:
 		}
 
 		// 4. run on commands
-		for _, _command := range _bot.on_commands {
+		for _, _command := range _bot.on_commands.snapshot() {
 			if _command.CheckCommand(event, _bot.Logger, _bot.Api) {
 				break switch_label // short circuit
 			}
 		}
 		// 5. run normal listeners
-		for _, listener := range _bot.listeners_send_message {
+		for _, listener := range _bot.listeners_send_message.snapshot() {
 			utils.Try(func() { listener(event) }, func(err interface{}, tb string) {
 				_bot.Logger.Error("listener {", utils.GetFunctionName(listener), "} error: ", err, "\n", tb)
 			})
 		}
 	case events.CreateRobot:
 		event := events.Event2EventCreateRobot(event)
-		for _, listener := range _bot.listeners_create_robot {
+		for _, listener := range _bot.listeners_create_robot.snapshot() {
 			utils.Try(func() { listener(event) }, func(err interface{}, tb string) {
 				_bot.Logger.Error("listener {", utils.GetFunctionName(listener), "} error: ", err, "\n", tb)
 			})
 		}
 	case events.DeleteRobot:
 		event := events.Event2EventDeleteRobot(event)
-		for _, listener := range _bot.listeners_delete_robot {
+		for _, listener := range _bot.listeners_delete_robot.snapshot() {
 			utils.Try(func() { listener(event) }, func(err interface{}, tb string) {
 				_bot.Logger.Error("listener {", utils.GetFunctionName(listener), "} error: ", err, "\n", tb)
 			})
 		}
 	case events.AddQuickEmoticon:
 		event := events.Event2EventAddQuickEmoticon(event)
-		for _, listener := range _bot.listeners_add_quick_emoticon {
+		for _, listener := range _bot.listeners_add_quick_emoticon.snapshot() {
 			utils.Try(func() { listener(event) }, func(err interface{}, tb string) {
 				_bot.Logger.Error("listener {", utils.GetFunctionName(listener), "} error: ", err, "\n", tb)
 			})
 		}
 	case events.AuditCallback:
 		event := events.Event2EventAuditCallback(event)
-		for _, listener := range _bot.listeners_audit_callback {
+		for _, listener := range _bot.listeners_audit_callback.snapshot() {
+			utils.Try(func() { listener(event) }, func(err interface{}, tb string) {
+				_bot.Logger.Error("listener {", utils.GetFunctionName(listener), "} error: ", err, "\n", tb)
+			})
+		}
+	case events.ClickMsgComponent:
+		event := events.Event2EventClickMsgComponent(event, _bot.Api)
+		if !awaited && _bot.checkWaitForComponent(event) {
+			break switch_label
+		}
+		for _, listener := range _bot.listeners_click_msg_component.snapshot() {
 			utils.Try(func() { listener(event) }, func(err interface{}, tb string) {
 				_bot.Logger.Error("listener {", utils.GetFunctionName(listener), "} error: ", err, "\n", tb)
 			})
@@ -125,8 +148,24 @@ switch_label:
 	}
 }
 
-// decode and dispatch event from raw request
-func dispatchEvent(raw_body []byte, sign *string) {
+// invalidate the api cache entries affected by the event
+func invalidateCacheByEvent(_bot *Bot, event events.Event) {
+	cache := _bot.Api.GetCache()
+	if cache == nil {
+		return
+	}
+	switch event.Event.Type {
+	case events.JoinVilla:
+		data := event.Event.ExtendData.EventData.JoinVilla
+		cache.InvalidateMember(data.VillaId, data.JoinUid)
+		cache.InvalidateVillaInfo(data.VillaId)
+	case events.CreateRobot, events.DeleteRobot:
+		cache.InvalidateVilla(event.Event.Robot.VillaId)
+	}
+}
+
+// decode and dispatch event from raw request, return ErrEventRejected if the event should be retried by the platform
+func (rt *Runtime) dispatchEvent(raw_body []byte, sign *string) error {
 	raw_body_str := string(raw_body)
 
 	// decode event
@@ -134,25 +173,31 @@ func dispatchEvent(raw_body []byte, sign *string) {
 	err := json.Unmarshal(raw_body, &event)
 	if err != nil {
 		fmt.Println("decode event error (" + err.Error() + "): " + raw_body_str)
-		return
+		return nil
 	}
 	if event.Type == "hb" { // handle heartbeat packet if using websocket protocol(based on reverse proxy)
-		return
+		return nil
 	}
 
 	// find bot ctx by id to allow multiple bot running on same port &|| path
 	_id := event.Event.Robot.Template.Id
-	_bot_ctx, ok := bot_context_manager[_id] // find bot ctx by id to allow multiple bot running on same port &|| path
-	if !ok {
-		// fmt.Println("bot id: ", _id, " not found in ctx: ", bot_context_manager)
-		return
+	_bot_ctx := rt.getBotContext(_id) // find bot ctx by id to allow multiple bot running on same port &|| path
+	if _bot_ctx == nil {
+		return nil
 	}
 	_bot := _bot_ctx.bot
 
-	// check if bot is running
-	if !_bot.is_running {
-		return
+	// check if bot is running, Stop waits for the event until it is processed
+	event_wg := _bot.acquireEvent()
+	if event_wg == nil {
+		return nil
 	}
+	is_processing := false
+	defer func() {
+		if !is_processing {
+			event_wg.Done()
+		}
+	}()
 
 	// get sign for ws bot from event directly
 	if sign == nil {
@@ -175,21 +220,28 @@ func dispatchEvent(raw_body []byte, sign *string) {
 		verify, err := pubKeyVerify(*sign, raw_body_str, _bot.Base.Secret, _bot.Base.PubKey)
 		if (!verify) || (err != nil) {
 			_bot.Logger.Debug("new event verify error, rejected: ", err)
-			return
+			return nil
 		}
 	}
-	go processEvent(_bot, event) // use goroutine to avoid blocking (especially handle wait_for)
+	// invalidate before processing, so that listeners will not get stale data
+	invalidateCacheByEvent(_bot, event)
+	is_processing = true
+	err = _bot.submitEvent(event, event_wg)
+	if errors.Is(err, ErrEventRejected) {
+		return err
+	}
+	return nil
 }
 
 // hook for http bot
 
-func hook(_bots []*Bot, c *gin.Context) {
+func (rt *Runtime) hook(_bots []*Bot, c *gin.Context) {
 	// send raw request to listeners on bot of current path and port
 	for _, _bot := range _bots {
-		if !_bot.is_running {
+		if !_bot.isRunning() {
 			continue
 		}
-		for _, listener := range _bot.listeners_raw_request {
+		for _, listener := range _bot.listeners_raw_request.snapshot() {
 			go utils.Try(func() { listener(c) }, func(err interface{}, tb string) {
 				_bot.Logger.Error("listener {", utils.GetFunctionName(listener), "} error: ", err, "\n", tb)
 			})
@@ -205,28 +257,31 @@ func hook(_bots []*Bot, c *gin.Context) {
 		return
 	}
 
-	defer func() { // ensure response
-		c.JSON(http.StatusOK, gin.H{
-			"message": "",
-			"retcode": 0,
-		})
-	}()
-
 	// read body content and sign
 	raw_body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		fmt.Println("new event read body error: ", err)
-		return
+	} else {
+		sign := c.Request.Header.Get("x-rpc-bot_sign")
+		// dispatch event
+		err = rt.dispatchEvent(raw_body, &sign)
+		if err != nil { // let the platform retry
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"message": err.Error(),
+				"retcode": -1,
+			})
+			return
+		}
 	}
-	sign := c.Request.Header.Get("x-rpc-bot_sign")
-
-	// dispatch event
-	dispatchEvent(raw_body, &sign)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "",
+		"retcode": 0,
+	})
 }
 
 // hook for ws bot
 
-func wshook(conn *websocket.Conn) {
+func (rt *Runtime) wshook(conn *websocket.Conn) {
 	for {
 		mtype, raw_body, err := conn.ReadMessage()
 		if err != nil {
@@ -234,7 +289,13 @@ func wshook(conn *websocket.Conn) {
 		}
 		switch mtype {
 		case websocket.TextMessage:
-			dispatchEvent(raw_body, nil)
+			if err := rt.dispatchEvent(raw_body, nil); err != nil {
+				conn.WriteJSON(gin.H{
+					"message": err.Error(),
+					"retcode": -1,
+				})
+				continue
+			}
 			conn.WriteJSON(gin.H{
 				"message": "",
 				"retcode": 0,
@@ -250,26 +311,53 @@ func wshook(conn *websocket.Conn) {
 	}
 }
 
-func (_bot *Bot) wsClientLoop(_url string) error {
+// connect to the ws server and reconnect on disconnection until ws_ctx is closed
+func (_bot *Bot) wsClientLoop(ws_ctx *wsContext) {
+	ws_ctx.mu.Lock()
+	stop := ws_ctx.stop
+	ws_ctx.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	wait := func() {
+		select {
+		case <-time.After(1 * time.Second):
+		case <-ctx.Done():
+		}
+	}
+
+	_url := ws_ctx.uri
 	do_once_flag := true
-	for {
+	for ctx.Err() == nil {
 		func() {
-			conn, resp, err := websocket.DefaultDialer.Dial(_url, nil)
+			conn, resp, err := websocket.DefaultDialer.DialContext(ctx, _url, nil)
 			if err != nil {
-				_bot.Logger.Errorf("ws服务端 %v 连接失败：%s", _url, err.Error())
-				time.Sleep(1 * time.Second)
+				if ctx.Err() == nil {
+					_bot.Logger.Errorf("ws服务端 %v 连接失败：%s", _url, err.Error())
+				}
+				wait()
 				return
 			}
 			defer conn.Close()
 			resp.Body.Close()
+			if !ws_ctx.setConn(conn) {
+				return
+			}
+			defer ws_ctx.setConn(nil)
 			if do_once_flag {
 				do_once_flag = false
 				_bot.Logger.Infof("ws服务端 %v 连接成功", _url)
 			} else {
 				_bot.Logger.Debugf("ws服务端 %v 重新连接成功", _url)
 			}
-			wshook(conn)
-			time.Sleep(1 * time.Second)
+			_bot.runtime.wshook(conn)
+			wait()
 		}()
 	}
 }
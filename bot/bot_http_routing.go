@@ -17,17 +17,21 @@ import (
 //
 // - handler: 路由处理器回调函数——函数返回true则短路，返回false则继续执行（系统将在所有同路径端口的handler都不短路后再处理消息）
 func AddHttpRouteHandler(path, addr string, handler func(*gin.Context) bool) {
-	for _, _bot := range bot_context_manager {
-		if _bot.bot.addr_key == addr {
-			if _bot.svr_ctx.handles[path] == nil {
-				_bot.svr_ctx.handles[path] = make([]func(*gin.Context) bool, 0)
-			}
+	default_runtime.AddHttpRouteHandler(path, addr, handler)
+}
+
+// 在该运行时中新增一个http路由，参数同 AddHttpRouteHandler
+func (rt *Runtime) AddHttpRouteHandler(path, addr string, handler func(*gin.Context) bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for _, _bot := range rt.bot_contexts {
+		if _bot.svr_ctx != nil && _bot.bot.addr_key == addr {
 			_bot.svr_ctx.handles[path] = append(_bot.svr_ctx.handles[path], handler)
 			return
 		}
 	}
-	if other_svr_context_manager[addr] == nil {
-		other_svr_context_manager[addr] = &serverContext{
+	if rt.other_servers[addr] == nil {
+		rt.other_servers[addr] = &serverContext{
 			svr:        gin.Default(),
 			svr_addr:   addr,
 			is_running: false,
@@ -35,66 +39,63 @@ func AddHttpRouteHandler(path, addr string, handler func(*gin.Context) bool) {
 			bots:       make(map[string][]*Bot),
 			handles:    make(map[string][]func(*gin.Context) bool),
 		}
-	} else {
-		if other_svr_context_manager[addr].handles[path] == nil {
-			other_svr_context_manager[addr].handles[path] = make([]func(*gin.Context) bool, 0)
-		}
-		other_svr_context_manager[addr].handles[path] = append(other_svr_context_manager[addr].handles[path], handler)
 	}
+	rt.other_servers[addr].handles[path] = append(rt.other_servers[addr].handles[path], handler)
 }
 
 func RemoveHttpRouteHandler(path, addr string, handler func(*gin.Context) bool) {
-	for _, _bot := range bot_context_manager {
-		if _bot.bot.addr_key == addr {
-			if _bot.svr_ctx.handles[path] == nil {
-				return
-			}
-			for i, h := range _bot.svr_ctx.handles[path] {
-				if reflect.ValueOf(h).Pointer() == reflect.ValueOf(handler).Pointer() {
-					_bot.svr_ctx.handles[path] = append(_bot.svr_ctx.handles[path][:i], _bot.svr_ctx.handles[path][i+1:]...)
-					return
-				}
+	default_runtime.RemoveHttpRouteHandler(path, addr, handler)
+}
+
+func (rt *Runtime) RemoveHttpRouteHandler(path, addr string, handler func(*gin.Context) bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	remove := func(svr_ctx *serverContext) bool {
+		for i, h := range svr_ctx.handles[path] {
+			if reflect.ValueOf(h).Pointer() == reflect.ValueOf(handler).Pointer() {
+				svr_ctx.handles[path] = append(svr_ctx.handles[path][:i], svr_ctx.handles[path][i+1:]...)
+				return true
 			}
 		}
+		return false
 	}
-	if other_svr_context_manager[addr] == nil {
-		return
-	} else {
-		if other_svr_context_manager[addr].handles[path] == nil {
+	for _, _bot := range rt.bot_contexts {
+		if _bot.svr_ctx != nil && _bot.bot.addr_key == addr && remove(_bot.svr_ctx) {
 			return
 		}
-		for i, h := range other_svr_context_manager[addr].handles[path] {
-			if reflect.ValueOf(h).Pointer() == reflect.ValueOf(handler).Pointer() {
-				other_svr_context_manager[addr].handles[path] = append(other_svr_context_manager[addr].handles[path][:i], other_svr_context_manager[addr].handles[path][i+1:]...)
-				return
-			}
-		}
+	}
+	if svr_ctx := rt.other_servers[addr]; svr_ctx != nil {
+		remove(svr_ctx)
 	}
 }
 
+// 开始运行默认运行时的所有 HTTP 服务器（不阻塞）
 func StartAllHttpServer() {
-	for _, _bot := range bot_context_manager {
-		if _bot.svr_ctx.is_running {
+	default_runtime.StartAllHttpServer()
+}
+
+// 开始运行该运行时的所有 HTTP 服务器（不阻塞）
+func (rt *Runtime) StartAllHttpServer() {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	for _, _bot := range rt.bot_contexts {
+		if _bot.svr_ctx == nil || !_bot.svr_ctx.begin() { // ws bot has no server
 			continue
 		}
-		_bot.svr_ctx.is_running = true
-		_bot.svr_ctx.wg.Add(1)
 		b := _bot
 		go func() {
-			defer b.svr_ctx.wg.Done()
-			b.svr_ctx.svr.Run(b.bot.addr_key)
+			defer b.svr_ctx.end()
+			b.svr_ctx.serve()
 		}()
 	}
-	for _, _svr := range other_svr_context_manager {
-		if _svr.is_running {
+	for _, _svr := range rt.other_servers {
+		if !_svr.begin() {
 			continue
 		}
-		_svr.is_running = true
-		_svr.wg.Add(1)
 		s := _svr
 		go func() {
-			defer s.wg.Done()
-			s.svr.Run(s.svr_addr)
+			defer s.end()
+			s.serve()
 		}()
 	}
 }
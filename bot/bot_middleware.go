@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"context"
+	"sync"
+
+	events "github.com/GLGDLY/mhy_botsdk/events"
+	utils "github.com/GLGDLY/mhy_botsdk/utils"
+)
+
+/* event middlewares
+ *
+ * 中间件包裹每个事件的完整处理流程（预处理器 → WaitFor → 插件 → 指令 → 监听器），按添加顺序执行：
+ *
+ *	bot.Use(func(ctx *bot.EventContext, next func()) {
+ *		start := time.Now()
+ *		next() // 继续执行后续中间件及事件处理，不调用则不处理该事件
+ *		log.Println(ctx.Type(), time.Since(start))
+ *	})
+ *
+ * 处理函数中可通过事件的 Context()、Get() 获取 EventContext 的 Context() 中的值及 Set 保存的值 */
+
+// 事件中间件，调用next执行后续中间件及事件处理
+type EventMiddleware func(ctx *EventContext, next func())
+
+// 单个事件的处理上下文，在所有中间件间共享
+type EventContext struct {
+	Bot   *Bot         // 处理该事件的机器人
+	Event events.Event // 原始事件，中间件可修改，后续处理使用修改后的事件（消息内容以 SendMessage.ContentRaw 为准）
+
+	mu      sync.RWMutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	values  map[string]interface{}
+	aborted bool
+}
+
+func newEventContext(_bot *Bot, event events.Event) *EventContext {
+	ctx, cancel := context.WithCancel(_bot.context())
+	return &EventContext{Bot: _bot, Event: event, ctx: ctx, cancel: cancel}
+}
+
+// 该事件的context.Context，事件处理完毕或机器人停止后取消；
+// 处理函数中事件的 Context() 带有其中的值，但只在机器人停止时取消（不带有此处设置的超时），事件的回复等辅助函数默认使用该ctx
+func (c *EventContext) Context() context.Context {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ctx
+}
+
+// 替换该事件的context.Context（如加入追踪信息或超时），应基于 Context() 派生
+func (c *EventContext) SetContext(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ctx = ctx
+}
+
+// 保存键值，用于在中间件之间及向处理函数传递数据，处理函数中可通过事件的 Get 获取
+func (c *EventContext) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+	c.values[key] = value
+}
+
+func (c *EventContext) Get(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, ok := c.values[key]
+	return value, ok
+}
+
+// 中止处理，之后调用的next不再执行后续中间件及事件处理；已在执行中的外层中间件仍会继续执行
+func (c *EventContext) Abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aborted = true
+}
+
+func (c *EventContext) IsAborted() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.aborted
+}
+
+func (c *EventContext) Type() events.EventType {
+	return c.Event.Event.Type
+}
+
+// 事件所在的别野、房间及相关用户（如发送消息的用户、点击组件的用户），事件没有该项时为0
+func (c *EventContext) Scope() (villa_id, room_id, uid uint64) {
+	return eventScope(c.Event)
+}
+
+// context carrying the values of the event context but cancelled only when the bot is stopped,
+// so that the handlers can still reply after the processing of the event is finished (e.g. in a goroutine or after WaitForCommand)
+type handlerContext struct {
+	context.Context // context of the bot
+	values          context.Context
+}
+
+func (c handlerContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+// run the middlewares and then the handler, a panic in a middleware stops the processing of the event
+func (c *EventContext) run(middlewares []EventMiddleware, handler func()) {
+	if len(middlewares) == 0 {
+		handler()
+		return
+	}
+	i := 0
+	var next func()
+	next = func() {
+		if c.IsAborted() || i > len(middlewares) {
+			return
+		}
+		if i == len(middlewares) {
+			i++
+			handler()
+			return
+		}
+		middleware := middlewares[i]
+		i++
+		middleware(c, next)
+	}
+	utils.Try(next, func(err interface{}, tb string) {
+		c.Bot.Logger.Error("middleware error: ", err, "\n", tb)
+	})
+}
+
+// 添加包裹所有事件处理的中间件，按添加顺序执行；返回的 Handle 可用于移除该中间件，正在处理的事件不受影响
+func (_bot *Bot) Use(middleware EventMiddleware) Handle {
+	return _bot.middlewares.add(middleware)
+}
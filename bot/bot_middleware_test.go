@@ -0,0 +1,228 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	apis "github.com/GLGDLY/mhy_botsdk/apis"
+	"github.com/GLGDLY/mhy_botsdk/apistub"
+	events "github.com/GLGDLY/mhy_botsdk/events"
+	"github.com/GLGDLY/mhy_botsdk/eventsim"
+	models "github.com/GLGDLY/mhy_botsdk/models"
+)
+
+type traceKey struct{}
+
+// send a signed message event to the handler of the bot
+func sendSimMessage(t *testing.T, sim *eventsim.Simulator, handler http.Handler, text string) {
+	t.Helper()
+	body, err := sim.SendMessage(eventsim.Message{VillaID: 1, RoomID: 2, FromUserID: 3, Nickname: "u", Parts: []interface{}{text}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := sim.ServeHTTP(handler, "/", body).Code; code != http.StatusOK {
+		t.Fatalf("callback of %q returned %d", text, code)
+	}
+}
+
+func TestMiddlewareContextReachesHandlers(t *testing.T) {
+	sim, err := eventsim.NewSimulator("bot_mw", "secret", "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_bot := newTestBot(t, "bot_mw", sim.PubKey(), ":0")
+	_bot.Use(func(ctx *EventContext, next func()) {
+		ctx.Set("user", "alice")
+		ctx.SetContext(context.WithValue(ctx.Context(), traceKey{}, "trace-1"))
+		next()
+	})
+	api_trace := make(chan interface{}, 1)
+	_bot.UseAPI(func(next apis.RoundTrip) apis.RoundTrip {
+		return func(call *apis.APICall) (int, error) {
+			api_trace <- call.Request.Context().Value(traceKey{})
+			return http.StatusOK, nil // not sent to the server
+		}
+	})
+	type seen struct {
+		user, trace interface{}
+	}
+	seen_by := make(chan seen, 2)
+	_bot.AddPreprocessor(func(e events.EventSendMessage) {
+		user, _ := e.Get("user")
+		seen_by <- seen{user, e.Context().Value(traceKey{})}
+	})
+	_bot.AddListenerSendMessage(func(e events.EventSendMessage) {
+		user, _ := e.Get("user")
+		seen_by <- seen{user, e.Context().Value(traceKey{})}
+		e.Reply("hi")
+	})
+	sendSimMessage(t, sim, _bot.Handler(), "hello")
+
+	for _, name := range []string{"preprocessor", "listener"} {
+		select {
+		case s := <-seen_by:
+			if s.user != "alice" || s.trace != "trace-1" {
+				t.Errorf("%s got user %v and trace %v, want alice and trace-1", name, s.user, s.trace)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s is not called", name)
+		}
+	}
+	select {
+	case trace := <-api_trace:
+		if trace != "trace-1" {
+			t.Errorf("Reply is sent with trace %v, want the context of the event", trace)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reply is not sent")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := _bot.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopCancelsEventContext(t *testing.T) {
+	sim, err := eventsim.NewSimulator("bot_mw", "secret", "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_bot := newTestBot(t, "bot_mw", sim.PubKey(), ":0")
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	_bot.AddListenerSendMessage(func(e events.EventSendMessage) {
+		if e.GetContent(true) != "hello" {
+			return
+		}
+		close(started)
+		select {
+		case <-e.Context().Done():
+			cancelled <- e.Context().Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- nil
+		}
+	})
+	sendSimMessage(t, sim, _bot.Handler(), "hello")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := _bot.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop() error = %v, want context.DeadlineExceeded", err)
+	}
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Errorf("context of the event is not cancelled by Stop, err = %v", err)
+	}
+
+	// a restarted bot gives the events a new context
+	ok := make(chan error, 1)
+	_bot.AddListenerSendMessage(func(e events.EventSendMessage) {
+		if e.GetContent(true) == "again" {
+			ok <- e.Context().Err()
+		}
+	})
+	sendSimMessage(t, sim, _bot.Handler(), "again")
+	select {
+	case err := <-ok:
+		if err != nil {
+			t.Errorf("context of the event after restart is %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener is not called after restart")
+	}
+	if err := _bot.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bot replying to the stub server, with villa 1 and room 2
+func newReplyTestBot(t *testing.T, sim *eventsim.Simulator) (*Bot, *apistub.Server) {
+	srv := apistub.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddVilla(1, "villa", 3)
+	srv.AddRoom(1, srv.AddGroup(1, "group"), 2, "room")
+	_bot := newTestBot(t, "bot_reply", sim.PubKey(), ":0")
+	_bot.SetAPIBaseURL(srv.URL)
+	return _bot, srv
+}
+
+func TestReplyAfterHandlerReturns(t *testing.T) {
+	sim, err := eventsim.NewSimulator("bot_reply", "secret", "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_bot, srv := newReplyTestBot(t, sim)
+	replied := make(chan error, 1)
+	_bot.AddListenerSendMessage(func(e events.EventSendMessage) {
+		go func() {
+			time.Sleep(50 * time.Millisecond) // the processing of the event is finished
+			_, _, err := e.Reply("late")
+			replied <- err
+		}()
+	})
+	sendSimMessage(t, sim, _bot.Handler(), "hello")
+	select {
+	case err := <-replied:
+		if err != nil {
+			t.Fatalf("delayed Reply() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reply() is not called")
+	}
+	if msg, ok := srv.LastMessage(); !ok || msg.Text() != "late" {
+		t.Errorf("last message = %+v, %v", msg, ok)
+	}
+	if err := _bot.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplyAfterWaitForCommand(t *testing.T) {
+	sim, err := eventsim.NewSimulator("bot_reply", "secret", "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_bot, srv := newReplyTestBot(t, sim) // no worker pool, the awaited event is delivered by its own processing
+	replied := make(chan error, 1)
+	_bot.AddListenerSendMessage(func(e events.EventSendMessage) {
+		if e.GetContent(true) != "ask" {
+			return
+		}
+		timeout := 5 * time.Second
+		res, err := _bot.WaitForCommand(models.WaitForCommandRegister{
+			Scope:   models.ScopeUser,
+			Command: models.CommandBase{Command: []string{"yes"}, IsShortCircuit: true},
+			Data:    e,
+			Timeout: &timeout,
+		})
+		if err == nil {
+			time.Sleep(50 * time.Millisecond) // the processing of the awaited event is finished
+			_, _, err = res.Reply("got " + res.GetContent(true))
+		}
+		replied <- err
+	})
+	handler := _bot.Handler()
+	sendSimMessage(t, sim, handler, "ask")
+	for len(_bot.wait_for_command_registers.snapshot()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	sendSimMessage(t, sim, handler, "yes")
+	select {
+	case err := <-replied:
+		if err != nil {
+			t.Fatalf("Reply() to the awaited event error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reply() is not called")
+	}
+	if msg, ok := srv.LastMessage(); !ok || msg.Text() != "got yes" {
+		t.Errorf("last message = %+v, %v", msg, ok)
+	}
+	if err := _bot.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
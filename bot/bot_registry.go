@@ -0,0 +1,95 @@
+package bot
+
+import "sync"
+
+/* registries of listeners, commands, preprocessors and wait-for registers */
+
+// Add* 返回的注册项句柄，用于移除对应的监听器、指令或预处理器
+type Handle struct {
+	id     uint64
+	remove func(id uint64) bool
+}
+
+// 移除对应的注册项，已被移除时返回false；可在事件处理过程中调用，正在处理中的事件仍可能调用到该注册项
+func (h Handle) Remove() bool {
+	if h.remove == nil {
+		return false
+	}
+	return h.remove(h.id)
+}
+
+// copy-on-write list, the slices are replaced on every change and never modified in place,
+// so that the snapshot can be iterated without holding the lock while entries are added or removed
+type registry[T any] struct {
+	mu     sync.RWMutex
+	ids    []uint64
+	values []T
+	next   uint64
+}
+
+// must be called with mu held
+func (r *registry[T]) addLocked(value T) Handle {
+	r.next++
+	id := r.next
+	r.ids = append(r.ids[:len(r.ids):len(r.ids)], id) // force a copy
+	r.values = append(r.values[:len(r.values):len(r.values)], value)
+	return Handle{id: id, remove: r.remove}
+}
+
+func (r *registry[T]) add(value T) Handle {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addLocked(value)
+}
+
+// add the value unless an existing value conflicts with it
+func (r *registry[T]) addUnique(value T, conflict func(existing T) bool) (Handle, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range r.values {
+		if conflict(v) {
+			return Handle{}, false
+		}
+	}
+	return r.addLocked(value), true
+}
+
+// must be called with mu held
+func (r *registry[T]) removeAtLocked(i int) {
+	ids := make([]uint64, 0, len(r.ids)-1)
+	r.ids = append(append(ids, r.ids[:i]...), r.ids[i+1:]...)
+	values := make([]T, 0, len(r.values)-1)
+	r.values = append(append(values, r.values[:i]...), r.values[i+1:]...)
+}
+
+func (r *registry[T]) remove(id uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, _id := range r.ids {
+		if _id == id {
+			r.removeAtLocked(i)
+			return true
+		}
+	}
+	return false
+}
+
+// remove the first value matching, used by the Remove* methods that compare listeners
+func (r *registry[T]) removeFirst(match func(T) bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, v := range r.values {
+		if match(v) {
+			r.removeAtLocked(i)
+			return true
+		}
+	}
+	return false
+}
+
+// the current values, must not be modified
+func (r *registry[T]) snapshot() []T {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.values
+}
@@ -0,0 +1,33 @@
+package bot
+
+import (
+	"testing"
+
+	commands "github.com/GLGDLY/mhy_botsdk/commands"
+	events "github.com/GLGDLY/mhy_botsdk/events"
+)
+
+func TestHandleRemove(t *testing.T) {
+	_bot := newTestBot(t, "bot_registry", "", ":0")
+	if err := _bot.AddOnCommand(commands.OnCommand{Command: []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	command := _bot.AddOnCommandHandle(commands.OnCommand{Command: []string{"b"}})
+	preprocessor := func(events.EventSendMessage) {}
+	if err := _bot.AddPreprocessor(preprocessor); err != nil {
+		t.Fatal(err)
+	}
+	handle := _bot.AddPreprocessorHandle(preprocessor) // same function, told apart by the handle
+	if !command.Remove() || command.Remove() {
+		t.Error("Remove() of the command handle should succeed only once")
+	}
+	if !handle.Remove() {
+		t.Error("Remove() of the preprocessor handle = false")
+	}
+	if cmds := _bot.on_commands.snapshot(); len(cmds) != 1 || cmds[0].Command[0] != "a" {
+		t.Errorf("commands after Remove() = %+v", cmds)
+	}
+	if n := len(_bot.preprocessors.snapshot()); n != 1 {
+		t.Errorf("%d preprocessors after Remove(), want 1", n)
+	}
+}
@@ -51,7 +51,11 @@ func (_bot *Bot) defaultWSProxyLoop(ws *websocket.Conn, msg_chan chan [2][]byte)
 
 	for {
 		select {
-		case msg := <-msg_chan:
+		case msg, ok := <-msg_chan:
+			if !ok { // closed by Bot.Stop
+				ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
 			body := strings.TrimSpace(string(msg[0]))
 			body = body[:len(body)-1] + ",\"sign\":\"" + string(msg[1]) + "\"}"
 			err := ws.WriteMessage(websocket.TextMessage, []byte(body))
@@ -89,8 +93,12 @@ func (_bot *Bot) AddReverseProxyWS(path, addr string) {
 
 	is_added := false
 
+	rt := _bot.runtime
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
 	// check if exists in http routes
-	if svr_ctx_ptr := other_svr_context_manager[addr]; svr_ctx_ptr != nil {
+	if svr_ctx_ptr := rt.other_servers[addr]; svr_ctx_ptr != nil {
 		if svr_ctx_ptr.handles[path] != nil {
 			_bot.Logger.Errorf("相关端口 %v 和路径 %v 已被其他服务占用，无法添加反向代理", addr, path)
 			close(msg_chan)
@@ -104,8 +112,8 @@ func (_bot *Bot) AddReverseProxyWS(path, addr string) {
 		}
 	}
 	// check if exists in bot routes
-	for _, _bot_ctx := range bot_context_manager {
-		if _bot_ctx.bot.addr_key == addr {
+	for _, _bot_ctx := range rt.bot_contexts {
+		if _bot_ctx.svr_ctx != nil && _bot_ctx.bot.addr_key == addr {
 			if _bot_ctx.bot.path_key == path {
 				_bot.Logger.Errorf("相关端口 %v 和路径 %v 已被其他服务占用，无法添加反向代理", addr, path)
 				close(msg_chan)
@@ -124,14 +132,14 @@ func (_bot *Bot) AddReverseProxyWS(path, addr string) {
 			break
 		}
 	}
-	// else, create new svr_ctx to other_svr_context_manager
+	// else, create new svr_ctx to other_servers
 	if !is_added {
 		svr_ctx_handles := make(map[string][]func(*gin.Context) bool)
 		svr_ctx_handles[path] = []func(*gin.Context) bool{func(c *gin.Context) bool {
 			_bot.defaultWSProxyHook(msg_chan, c)
 			return true
 		}}
-		other_svr_context_manager[addr] = &serverContext{
+		rt.other_servers[addr] = &serverContext{
 			svr:        gin.Default(),
 			svr_addr:   addr,
 			is_running: false,
@@ -0,0 +1,56 @@
+package bot
+
+import (
+	plugin "github.com/GLGDLY/mhy_botsdk/plugins"
+)
+
+/* 机器人运行时，持有机器人、HTTP服务器及插件
+ *
+ * 不同的Runtime之间互不影响，可用于在同一进程中运行多组相互隔离的机器人（如并行测试）；
+ * NewBot、StartAll、AddHttpRouteHandler、Shutdown 等全局函数均使用默认运行时 DefaultRuntime() */
+
+// 使用新的运行时，插件需通过 Runtime.RegisterPlugin 注册
+func NewRuntime() *Runtime {
+	return newRuntime(plugin.NewRegistry())
+}
+
+func newRuntime(plugins *plugin.Registry) *Runtime {
+	return &Runtime{
+		bot_contexts:  make(map[string]*botContext),
+		other_servers: make(map[string]*serverContext),
+		plugins:       plugins,
+	}
+}
+
+var default_runtime = newRuntime(plugin.DefaultRegistry())
+
+// 获取全局函数所使用的默认运行时，其插件注册表即 plugins.RegisterPlugin 所使用的注册表
+func DefaultRuntime() *Runtime {
+	return default_runtime
+}
+
+// 注册插件到下一个在该运行时启动的Bot实例
+func (rt *Runtime) RegisterPlugin(name string, context *plugin.Plugin) {
+	rt.plugins.Register(name, context)
+}
+
+func (rt *Runtime) ClearPlugins() {
+	rt.plugins.Clear()
+}
+
+func (rt *Runtime) getBotContext(bot_id string) *botContext {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return rt.bot_contexts[bot_id]
+}
+
+// snapshot of the bot contexts, so that bots can be started or stopped without holding the lock
+func (rt *Runtime) botContexts() []*botContext {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	ret := make([]*botContext, 0, len(rt.bot_contexts))
+	for _, bot_ctx := range rt.bot_contexts {
+		ret = append(ret, bot_ctx)
+	}
+	return ret
+}
@@ -0,0 +1,528 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	apis "github.com/GLGDLY/mhy_botsdk/apis"
+)
+
+/* scheduled and delayed jobs */
+
+// 定时任务执行的函数，ctx为任务执行的上下文
+type JobFunc func(ctx context.Context, _bot *Bot) error
+
+type JobActionType string
+
+const (
+	JobActionSendMessage   JobActionType = "send_message"   // 发送Content（内嵌格式，同 SendMessage）
+	JobActionSendMarkdown  JobActionType = "send_markdown"  // 发送Content（Markdown，同 SendMarkdown）
+	JobActionPinMessage    JobActionType = "pin_message"    // 置顶MsgUID对应的消息
+	JobActionUnpinMessage  JobActionType = "unpin_message"  // 取消置顶MsgUID对应的消息
+	JobActionRecallMessage JobActionType = "recall_message" // 撤回MsgUID对应的消息
+)
+
+// 调用API的定时任务动作，可被持久化
+type JobAction struct {
+	Type    JobActionType `json:"type"`
+	VillaID uint64        `json:"villa_id"`
+	RoomID  uint64        `json:"room_id"`
+	Content string        `json:"content,omitempty"` // 发送消息的内容
+	MsgUID  string        `json:"msg_uid,omitempty"` // 置顶、撤回的消息id
+	SendAt  int64         `json:"send_at,omitempty"` // 置顶、撤回的消息的发送时间
+}
+
+/* 定时任务，Spec的格式见 bot_cron.go 顶部说明（cron表达式、@daily、@every 1h、@at ...、@in 10m）
+ *
+ * Action、FuncName、Func三者必须且只能设置一个：Action为调用API的动作，FuncName为通过 RegisterJobFunc 注册的函数名，
+ * 两者均可被持久化；Func为任意函数，仅保存在内存中 */
+type Job struct {
+	ID       string     `json:"id"`                  // 任务id，为空时自动生成
+	Spec     string     `json:"spec"`                // 执行时间
+	Timezone string     `json:"timezone,omitempty"`  // IANA时区名（如"Asia/Shanghai"），为空时使用本地时区
+	Action   *JobAction `json:"action,omitempty"`    // 调用API的动作
+	FuncName string     `json:"func_name,omitempty"` // 已注册的函数名
+	Func     JobFunc    `json:"-"`                   // 任意函数，不会被持久化
+}
+
+// 任务的运行状态
+type JobInfo struct {
+	Job
+	NextRun time.Time // 下次执行时间，为零值时表示不会再执行
+	LastRun time.Time // 上次执行时间
+	LastErr error     // 上次执行的错误
+	Runs    int       // 已执行的次数
+}
+
+// 持久化保存的任务
+type JobRecord struct {
+	Job
+	NextRun time.Time `json:"next_run"`
+}
+
+// 任务的持久化存储，每次任务增删或执行后都会以全部可持久化的任务调用Save，Save失败时会稍后重试
+type JobStore interface {
+	Load() ([]JobRecord, error)
+	Save(records []JobRecord) error
+}
+
+// 以JSON文件保存任务的 JobStore
+type FileJobStore struct {
+	path string
+}
+
+func NewFileJobStore(path string) *FileJobStore {
+	return &FileJobStore{path: path}
+}
+
+func (store *FileJobStore) Load() ([]JobRecord, error) {
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var records []JobRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// write to a temporary file then rename it, so that the file is never half written
+func (store *FileJobStore) Save(records []JobRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if _err := tmp.Close(); err == nil {
+		err = _err
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), store.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+type scheduledJob struct {
+	job      Job
+	schedule schedule
+	next     time.Time
+	last     time.Time
+	last_err error
+	runs     int
+}
+
+type jobScheduler struct {
+	mu         sync.Mutex
+	bot        *Bot
+	jobs       map[string]*scheduledJob
+	running    map[string]bool // ids of the running jobs, kept when a job is replaced or cancelled so that runs never overlap
+	funcs      map[string]JobFunc
+	store      JobStore
+	seq        uint64
+	version    uint64     // bumped on every change of the persistent jobs
+	save_mu    sync.Mutex // serialises the saves, so that an older snapshot never overwrites a newer one
+	saved      uint64     // version of the last successful save, guarded by save_mu
+	wake       chan struct{}
+	done       chan struct{}      // closed to stop the loop, nil when the loop is not running
+	ctx        context.Context    // context of the running jobs
+	cancel_ctx context.CancelFunc // cancel ctx
+	jobs_wg    sync.WaitGroup     // running jobs
+}
+
+// interval of retrying a failed save of the job store
+const job_save_retry_interval = time.Minute
+
+func newJobScheduler(_bot *Bot) *jobScheduler {
+	return &jobScheduler{bot: _bot, jobs: make(map[string]*scheduledJob), running: make(map[string]bool),
+		funcs: make(map[string]JobFunc), wake: make(chan struct{}, 1)}
+}
+
+func (action *JobAction) validate() error {
+	switch action.Type {
+	case JobActionSendMessage, JobActionSendMarkdown:
+		if action.Content == "" {
+			return errors.New("job action content is empty")
+		}
+	case JobActionPinMessage, JobActionUnpinMessage, JobActionRecallMessage:
+		if action.MsgUID == "" {
+			return errors.New("job action msg_uid is empty")
+		}
+	default:
+		return fmt.Errorf("unknown job action type %q", action.Type)
+	}
+	return nil
+}
+
+func (action *JobAction) run(ctx context.Context, api *apis.ApiBase) error {
+	var err error
+	switch action.Type {
+	case JobActionSendMessage:
+		_, _, err = api.SendMessageCtx(ctx, action.VillaID, action.RoomID, action.Content)
+	case JobActionSendMarkdown:
+		_, _, err = api.SendMarkdownCtx(ctx, action.VillaID, action.RoomID, action.Content)
+	case JobActionPinMessage, JobActionUnpinMessage:
+		_, _, err = api.PinMessageCtx(ctx, action.VillaID, action.MsgUID, action.Type == JobActionUnpinMessage, action.RoomID, action.SendAt)
+	case JobActionRecallMessage:
+		_, _, err = api.RecallMessageCtx(ctx, action.VillaID, action.MsgUID, action.RoomID, action.SendAt)
+	default:
+		err = fmt.Errorf("unknown job action type %q", action.Type)
+	}
+	return err
+}
+
+func (job Job) persistent() bool {
+	return job.Func == nil
+}
+
+// check the job and parse its schedule, "@in" is turned into "@at" so that it can be persisted
+func (s *jobScheduler) prepare(job *Job, now time.Time) (schedule, error) {
+	kinds := 0
+	for _, set := range []bool{job.Action != nil, job.FuncName != "", job.Func != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return nil, errors.New("exactly one of Action, FuncName and Func should be set")
+	}
+	if job.Action != nil {
+		if err := job.Action.validate(); err != nil {
+			return nil, err
+		}
+		action := *job.Action
+		job.Action = &action
+	}
+	location := time.Local
+	if job.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(job.Timezone); err != nil {
+			return nil, err
+		}
+	}
+	sched, err := parseSchedule(job.Spec, location, now)
+	if err != nil {
+		return nil, err
+	}
+	if once, ok := sched.(onceSchedule); ok {
+		job.Spec = "@at " + once.at.In(location).Format(time.RFC3339)
+	}
+	return sched, nil
+}
+
+func (s *jobScheduler) newID() string {
+	for {
+		s.seq++
+		id := "job-" + strconv.FormatUint(s.seq, 10)
+		if _, ok := s.jobs[id]; !ok {
+			return id
+		}
+	}
+}
+
+func (s *jobScheduler) add(job Job) (string, error) {
+	now := time.Now()
+	sched, err := s.prepare(&job, now)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	if job.ID == "" {
+		job.ID = s.newID()
+	}
+	old, replaced := s.jobs[job.ID]
+	s.jobs[job.ID] = &scheduledJob{job: job, schedule: sched, next: sched.next(now)}
+	s.changed(job.persistent() || (replaced && old.job.persistent()))
+	s.mu.Unlock()
+	s.persist()
+	return job.ID, nil
+}
+
+func (s *jobScheduler) cancel(id string) bool {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.jobs, id)
+	s.changed(job.job.persistent())
+	s.mu.Unlock()
+	s.persist()
+	return true
+}
+
+func (s *jobScheduler) list() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]JobInfo, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		ret = append(ret, JobInfo{Job: job.job, NextRun: job.next, LastRun: job.last, LastErr: job.last_err, Runs: job.runs})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+// load the stored jobs, then save the jobs in memory together with them
+func (s *jobScheduler) setStore(store JobStore) error {
+	var records []JobRecord
+	if store != nil {
+		var err error
+		if records, err = store.Load(); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	s.mu.Lock()
+	s.store = store
+	for _, record := range records {
+		job := record.Job
+		sched, err := s.prepare(&job, now)
+		if err != nil {
+			s.bot.Logger.Warnf("跳过无法加载的定时任务 %s : %v\n", job.ID, err)
+			continue
+		}
+		if _, ok := s.jobs[job.ID]; ok || job.ID == "" {
+			continue // the job added in code takes precedence
+		}
+		next := sched.next(now)
+		if _, ok := sched.(onceSchedule); ok && next.IsZero() {
+			next = now // missed one-shot job runs at once
+		} else if !record.NextRun.IsZero() && record.NextRun.After(now) {
+			next = record.NextRun
+		}
+		s.jobs[job.ID] = &scheduledJob{job: job, schedule: sched, next: next}
+	}
+	s.changed(true)
+	s.mu.Unlock()
+	s.persist()
+	return nil
+}
+
+// wake up the loop and mark the persistent jobs as changed, must be called with mu held; call persist after unlocking mu
+func (s *jobScheduler) changed(persist bool) {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	if persist {
+		s.version++
+	}
+}
+
+// save the persistent jobs if they are changed since the last successful save, the store is called without holding mu;
+// return false if the save fails, then it is retried by the next call
+func (s *jobScheduler) persist() bool {
+	s.save_mu.Lock()
+	defer s.save_mu.Unlock()
+	s.mu.Lock()
+	store, version := s.store, s.version
+	if store == nil || version == s.saved {
+		s.mu.Unlock()
+		return true
+	}
+	records := []JobRecord{}
+	for _, job := range s.jobs {
+		if job.job.persistent() {
+			records = append(records, JobRecord{Job: job.job, NextRun: job.next})
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	if err := store.Save(records); err != nil {
+		s.bot.Logger.Errorf("保存定时任务失败 : %v\n", err)
+		return false
+	}
+	s.saved = version
+	return true
+}
+
+// start the loop if it is not running
+func (s *jobScheduler) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done != nil {
+		return
+	}
+	s.done = make(chan struct{})
+	s.ctx, s.cancel_ctx = context.WithCancel(context.Background())
+	go s.loop(s.done)
+}
+
+// stop the loop and wait for the running jobs, whose context is cancelled when ctx is done
+func (s *jobScheduler) stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.done == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	close(s.done)
+	s.done = nil
+	cancel := s.cancel_ctx
+	s.mu.Unlock()
+	err := waitCtx(ctx, &s.jobs_wg)
+	cancel()
+	return err
+}
+
+func (s *jobScheduler) loop(done chan struct{}) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		now := time.Now()
+		var earliest time.Time
+		s.mu.Lock()
+		if s.done != done { // stopped, stop may be waiting for jobs_wg
+			s.mu.Unlock()
+			return
+		}
+		persist := false
+		for id, job := range s.jobs {
+			if job.next.IsZero() {
+				continue
+			}
+			if !job.next.After(now) {
+				if _, once := job.schedule.(onceSchedule); once && s.running[id] {
+					continue // run after the previous run finishes, which wakes up the loop
+				}
+				if s.running[id] {
+					s.bot.Logger.Warnf("定时任务 %s 上次执行尚未结束，跳过本次执行\n", id)
+				} else {
+					s.running[id] = true
+					s.jobs_wg.Add(1)
+					go s.run(s.ctx, job, now)
+				}
+				job.next = job.schedule.next(now)
+				if job.next.IsZero() {
+					delete(s.jobs, id)
+				}
+				persist = persist || job.job.persistent()
+			}
+			if !job.next.IsZero() && (earliest.IsZero() || job.next.Before(earliest)) {
+				earliest = job.next
+			}
+		}
+		if persist {
+			s.changed(true)
+		}
+		s.mu.Unlock()
+		saved := s.persist()
+
+		wait := time.Hour
+		if !earliest.IsZero() {
+			wait = time.Until(earliest)
+		}
+		if !saved && wait > job_save_retry_interval {
+			wait = job_save_retry_interval
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (s *jobScheduler) run(ctx context.Context, job *scheduledJob, at time.Time) {
+	var err error
+	defer s.jobs_wg.Done()
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("panic: %v", e)
+		}
+		if err != nil {
+			s.bot.Logger.Errorf("定时任务 %s 执行失败 : %v\n", job.job.ID, err)
+		}
+		s.mu.Lock()
+		delete(s.running, job.job.ID)
+		s.changed(false) // a one-shot job with the same id may be waiting for this run
+		job.last = at
+		job.last_err = err
+		job.runs++
+		s.mu.Unlock()
+	}()
+	switch {
+	case job.job.Func != nil:
+		err = job.job.Func(ctx, s.bot)
+	case job.job.FuncName != "":
+		s.mu.Lock()
+		fn := s.funcs[job.job.FuncName]
+		s.mu.Unlock()
+		if fn == nil {
+			err = fmt.Errorf("job func %q is not registered", job.job.FuncName)
+			return
+		}
+		err = fn(ctx, s.bot)
+	case job.job.Action != nil:
+		err = job.job.Action.run(ctx, s.bot.Api)
+	}
+}
+
+/* bot methods */
+
+// 添加定时任务，返回任务id；id与已有任务相同时会替换该任务
+func (_bot *Bot) AddJob(job Job) (string, error) {
+	return _bot.scheduler.add(job)
+}
+
+// 按Spec定时执行fn，如 ScheduleFunc("@in 10m", fn)、ScheduleFunc("0 20 * * *", fn)，该任务不会被持久化
+func (_bot *Bot) ScheduleFunc(spec string, fn JobFunc) (string, error) {
+	return _bot.scheduler.add(Job{Spec: spec, Func: fn})
+}
+
+// 按Spec定时发送消息，msg为内嵌格式（同 SendMessage），如 ScheduleMessage("0 20 * * *", villa_id, room_id, "晚上好")
+func (_bot *Bot) ScheduleMessage(spec string, villa_id, room_id uint64, msg string) (string, error) {
+	return _bot.scheduler.add(Job{Spec: spec, Action: &JobAction{Type: JobActionSendMessage, VillaID: villa_id, RoomID: room_id, Content: msg}})
+}
+
+// 取消定时任务，任务不存在时返回false；已开始执行的任务不会被中断
+func (_bot *Bot) CancelJob(id string) bool {
+	return _bot.scheduler.cancel(id)
+}
+
+// 获取所有定时任务及其运行状态，按id排序
+func (_bot *Bot) GetJobs() []JobInfo {
+	return _bot.scheduler.list()
+}
+
+// 注册可被 Job.FuncName 引用的函数，需要持久化的自定义任务应使用注册的函数
+func (_bot *Bot) RegisterJobFunc(name string, fn JobFunc) {
+	_bot.scheduler.mu.Lock()
+	defer _bot.scheduler.mu.Unlock()
+	_bot.scheduler.funcs[name] = fn
+}
+
+/* 设置定时任务的持久化存储（如 NewFileJobStore("jobs.json")），传入nil则不持久化
+ *
+ * 设置时会加载已保存的任务（与已添加的任务id相同时以已添加的为准）；重启期间错过的单次任务会在加载后立即执行，
+ * 周期任务则从当前时间重新计算下次执行时间 */
+func (_bot *Bot) SetJobStore(store JobStore) error {
+	return _bot.scheduler.setStore(store)
+}
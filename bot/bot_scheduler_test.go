@@ -0,0 +1,204 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJobReplaceDoesNotOverlap(t *testing.T) {
+	_bot := newTestBot(t, "bot_job", "", ":0")
+	var active, max_active, runs int32
+	fn := func(ctx context.Context, _ *Bot) error {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			m := atomic.LoadInt32(&max_active)
+			if n <= m || atomic.CompareAndSwapInt32(&max_active, m, n) {
+				break
+			}
+		}
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(150 * time.Millisecond)
+		return nil
+	}
+	if _, err := _bot.AddJob(Job{ID: "x", Spec: "@every 20ms", Func: fn}); err != nil {
+		t.Fatal(err)
+	}
+	_bot.scheduler.start()
+	time.Sleep(60 * time.Millisecond) // the first run is in progress
+	if _, err := _bot.AddJob(Job{ID: "x", Spec: "@every 20ms", Func: fn}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := _bot.scheduler.stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if max_active != 1 {
+		t.Errorf("%d runs of the same job id overlapped", max_active)
+	}
+	if runs < 2 {
+		t.Errorf("job ran %d times, want at least 2", runs)
+	}
+}
+
+func TestJobNotStartedBeforeBotStart(t *testing.T) {
+	_bot := newTestBot(t, "bot_job", "", ":0")
+	ran := make(chan struct{}, 1)
+	if _, err := _bot.ScheduleFunc("@every 10ms", func(ctx context.Context, _ *Bot) error {
+		ran <- struct{}{}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ran:
+		t.Fatal("job ran before the bot is started")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// store reading the scheduler while saving, which deadlocks if Save is called with the scheduler locked
+type listingJobStore struct {
+	mu      sync.Mutex
+	_bot    *Bot
+	records []JobRecord
+	err     error
+}
+
+func (store *listingJobStore) Load() ([]JobRecord, error) {
+	return nil, nil
+}
+
+func (store *listingJobStore) Save(records []JobRecord) error {
+	done := make(chan struct{})
+	go func() {
+		store._bot.GetJobs()
+		close(done)
+	}()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		store.err = errors.New("Save is called with the scheduler locked")
+	}
+	store.records = records
+	return nil
+}
+
+func TestJobStoreSaveUnlocked(t *testing.T) {
+	_bot := newTestBot(t, "bot_job", "", ":0")
+	store := &listingJobStore{_bot: _bot}
+	if err := _bot.SetJobStore(store); err != nil {
+		t.Fatal(err)
+	}
+	id, err := _bot.ScheduleMessage("0 20 * * *", 1, 2, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := _bot.ScheduleFunc("@every 1h", func(ctx context.Context, _ *Bot) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	store.mu.Lock()
+	if store.err != nil {
+		t.Error(store.err)
+	}
+	if len(store.records) != 1 || store.records[0].ID != id {
+		t.Errorf("saved records = %+v, want only job %s", store.records, id)
+	}
+	store.mu.Unlock()
+	if !_bot.CancelJob(id) {
+		t.Fatal("CancelJob() = false")
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.records) != 0 {
+		t.Errorf("saved records after cancel = %+v", store.records)
+	}
+}
+
+func TestOnceJobWaitsForPreviousRun(t *testing.T) {
+	_bot := newTestBot(t, "bot_job", "", ":0")
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	if _, err := _bot.AddJob(Job{ID: "x", Spec: "@every 10ms", Func: func(ctx context.Context, _ *Bot) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	_bot.scheduler.start()
+	defer _bot.scheduler.stop(context.Background())
+	<-started
+	ran := make(chan struct{})
+	if _, err := _bot.AddJob(Job{ID: "x", Spec: "@in 1h", Func: func(ctx context.Context, _ *Bot) error {
+		close(ran)
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	_bot.scheduler.mu.Lock()
+	_bot.scheduler.jobs["x"].next = time.Now() // due while the previous run of x is in progress
+	_bot.scheduler.changed(false)
+	_bot.scheduler.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-ran:
+		t.Fatal("one-shot job overlapped the previous run")
+	default:
+	}
+	close(release)
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("one-shot job is dropped instead of running after the previous run")
+	}
+}
+
+// store failing the first save of a job
+type flakyJobStore struct {
+	mu     sync.Mutex
+	failed bool
+	saves  int
+	saved  []JobRecord
+}
+
+func (store *flakyJobStore) Load() ([]JobRecord, error) {
+	return nil, nil
+}
+
+func (store *flakyJobStore) Save(records []JobRecord) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.saves++
+	if len(records) > 0 && !store.failed {
+		store.failed = true
+		return errors.New("disk full")
+	}
+	store.saved = records
+	return nil
+}
+
+func TestJobStoreRetryFailedSave(t *testing.T) {
+	_bot := newTestBot(t, "bot_job", "", ":0")
+	store := &flakyJobStore{}
+	if err := _bot.SetJobStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := _bot.ScheduleMessage("0 20 * * *", 1, 2, "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if !_bot.scheduler.persist() {
+		t.Fatal("persist() = false after the store recovers")
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if !store.failed || len(store.saved) != 1 {
+		t.Errorf("saves = %d, saved %+v, want the failed save retried", store.saves, store.saved)
+	}
+}
@@ -0,0 +1,257 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/* graceful shutdown */
+
+// 机器人停止时，等待中的 WaitForCommand 及 WaitForComponentClick 返回的错误
+var ErrShutdown = errors.New("bot shutdown")
+
+// wait for wg, return ctx.Err() if ctx is done first
+func waitCtx(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (_bot *Bot) isRunning() bool {
+	_bot.state_mu.RLock()
+	defer _bot.state_mu.RUnlock()
+	return _bot.is_running
+}
+
+// closed when the bot is stopped
+func (_bot *Bot) shutdownChan() <-chan struct{} {
+	_bot.state_mu.RLock()
+	defer _bot.state_mu.RUnlock()
+	return _bot.shutdown
+}
+
+// parent of the context of every event, cancelled when Stop returns
+func (_bot *Bot) context() context.Context {
+	_bot.state_mu.RLock()
+	defer _bot.state_mu.RUnlock()
+	return _bot.ctx
+}
+
+// mark an event as in-flight if the bot is running, Done of the returned WaitGroup must be called after it is processed
+func (_bot *Bot) acquireEvent() *sync.WaitGroup {
+	_bot.state_mu.RLock()
+	defer _bot.state_mu.RUnlock()
+	if !_bot.is_running {
+		return nil
+	}
+	_bot.event_wg.Add(1)
+	return _bot.event_wg
+}
+
+// mark the server as running and add it to wg, return false if it is already running; end must be called after serve returns
+func (svr_ctx *serverContext) begin() bool {
+	svr_ctx.mu.Lock()
+	defer svr_ctx.mu.Unlock()
+	if svr_ctx.is_running {
+		return false
+	}
+	svr_ctx.is_running = true
+	svr_ctx.wg.Add(1)
+	return true
+}
+
+func (svr_ctx *serverContext) end() {
+	svr_ctx.mu.Lock()
+	svr_ctx.is_running = false
+	svr_ctx.mu.Unlock()
+	svr_ctx.wg.Done()
+}
+
+// run the http server until it is shut down, http.ErrServerClosed is not returned
+func (svr_ctx *serverContext) serve() error {
+	http_svr := &http.Server{Addr: svr_ctx.svr_addr, Handler: svr_ctx.svr}
+	svr_ctx.mu.Lock()
+	svr_ctx.http_svr = http_svr
+	svr_ctx.mu.Unlock()
+	err := http_svr.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (svr_ctx *serverContext) shutdown(ctx context.Context) error {
+	svr_ctx.mu.Lock()
+	http_svr := svr_ctx.http_svr
+	svr_ctx.http_svr = nil
+	svr_ctx.mu.Unlock()
+	if http_svr == nil {
+		return nil
+	}
+	return http_svr.Shutdown(ctx)
+}
+
+func (svr_ctx *serverContext) hasRunningBot() bool {
+	for _, _bots := range svr_ctx.bots {
+		for _, _bot := range _bots {
+			if _bot.isRunning() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mark the client as running and add it to wg, return false if it is already running; end must be called after the client loop returns
+func (ws_ctx *wsContext) begin() bool {
+	ws_ctx.mu.Lock()
+	defer ws_ctx.mu.Unlock()
+	if ws_ctx.is_running {
+		return false
+	}
+	ws_ctx.is_running = true
+	ws_ctx.stop = make(chan struct{})
+	ws_ctx.wg.Add(1)
+	return true
+}
+
+func (ws_ctx *wsContext) end() {
+	ws_ctx.mu.Lock()
+	ws_ctx.is_running = false
+	ws_ctx.mu.Unlock()
+	ws_ctx.wg.Done()
+}
+
+// keep the current connection so that it can be closed on stop, return false if already stopped
+func (ws_ctx *wsContext) setConn(conn *websocket.Conn) bool {
+	ws_ctx.mu.Lock()
+	defer ws_ctx.mu.Unlock()
+	if conn != nil && ws_ctx.stop == nil {
+		return false
+	}
+	ws_ctx.conn = conn
+	return true
+}
+
+// stop reconnecting and close the current connection
+func (ws_ctx *wsContext) close() {
+	ws_ctx.mu.Lock()
+	defer ws_ctx.mu.Unlock()
+	if ws_ctx.stop != nil {
+		close(ws_ctx.stop)
+		ws_ctx.stop = nil
+	}
+	if ws_ctx.conn != nil {
+		ws_ctx.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		ws_ctx.conn.Close()
+		ws_ctx.conn = nil
+	}
+}
+
+func (_bot *Bot) closeReverseProxies() {
+	for _, msg_chan := range _bot.reverse_proxy_http_msg_chan {
+		close(msg_chan)
+	}
+	for _, msg_chan := range _bot.reverse_proxy_ws_msg_chan {
+		close(msg_chan)
+	}
+	_bot.reverse_proxy_http_msg_chan = nil
+	_bot.reverse_proxy_ws_msg_chan = nil
+}
+
+/* 停止机器人，Start() 会随之返回
+ *
+ * 依次：不再处理新的回调事件（工作池中已排队的事件仍会处理）、关闭ws连接（所在HTTP服务器上没有其他运行中的机器人时关闭该服务器）、
+ * 使等待中的 WaitForCommand 等返回 ErrShutdown、等待正在处理的事件及定时任务完成、关闭反向代理；
+ * ctx结束时提前返回ctx的错误（正在执行的定时任务的ctx会被取消）。停止后可再次 Start()，但反向代理需要重新添加 */
+func (_bot *Bot) Stop(ctx context.Context) error {
+	_bot.state_mu.Lock()
+	was_running := _bot.is_running
+	_bot.is_running = false
+	cancel := _bot.cancel_ctx
+	event_wg := _bot.event_wg
+	select {
+	case <-_bot.shutdown:
+	default:
+		close(_bot.shutdown)
+	}
+	_bot.state_mu.Unlock()
+	_bot.stopWorkerPool() // queued events are still processed
+
+	var err error
+	if _bot_ctx := _bot.runtime.getBotContext(_bot.Base.ID); _bot_ctx != nil && _bot_ctx.bot == _bot {
+		if _bot_ctx.ws_ctx != nil {
+			_bot_ctx.ws_ctx.close()
+		}
+		_bot.runtime.mu.RLock()
+		has_running_bot := _bot_ctx.svr_ctx != nil && _bot_ctx.svr_ctx.hasRunningBot()
+		_bot.runtime.mu.RUnlock()
+		if _bot_ctx.svr_ctx != nil && !has_running_bot {
+			err = _bot_ctx.svr_ctx.shutdown(ctx)
+		}
+	}
+	_err := waitCtx(ctx, event_wg)
+	cancel() // cancel the events still in progress
+	if _err != nil {
+		return _err
+	}
+	if _err := _bot.scheduler.stop(ctx); _err != nil {
+		return _err
+	}
+	_bot.closeReverseProxies()
+	if was_running {
+		_bot.Logger.Infof("机器人 {%v} 已停止\n", _bot.Base.ID)
+	}
+	return err
+}
+
+// 停止默认运行时的所有机器人（见 Bot.Stop）并关闭所有HTTP服务器（包括 AddHttpRouteHandler 添加的），返回遇到的第一个错误
+func Shutdown(ctx context.Context) error {
+	return default_runtime.Shutdown(ctx)
+}
+
+// 停止该运行时的所有机器人并关闭所有HTTP服务器，同 Shutdown
+func (rt *Runtime) Shutdown(ctx context.Context) error {
+	bot_contexts := rt.botContexts()
+	rt.mu.RLock()
+	other_servers := make([]*serverContext, 0, len(rt.other_servers))
+	for _, svr_ctx := range rt.other_servers {
+		other_servers = append(other_servers, svr_ctx)
+	}
+	rt.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(bot_contexts)+len(other_servers))
+	for _, bot_ctx := range bot_contexts {
+		wg.Add(1)
+		go func(_bot *Bot) {
+			defer wg.Done()
+			errs <- _bot.Stop(ctx)
+		}(bot_ctx.bot)
+	}
+	wg.Wait()
+	for _, svr_ctx := range other_servers {
+		errs <- svr_ctx.shutdown(ctx)
+	}
+	close(errs)
+	var first_err error
+	for err := range errs {
+		if err != nil && first_err == nil {
+			first_err = err
+		}
+	}
+	return first_err
+}
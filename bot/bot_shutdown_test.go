@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartStopSharedServer(t *testing.T) {
+	addr := freeAddr(t)
+	first := newTestBot(t, "bot_a", "", addr)
+	second := first.runtime.NewBot("bot_b", "secret", "", "/b", addr)
+	second.SetLogger(testLogger{t})
+	rt := first.runtime
+
+	for round := 0; round < 2; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ { // concurrent starters of the same server
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rt.StartAllBot()
+			}()
+		}
+		waitListening(t, addr)
+		for !first.isRunning() || !second.isRunning() {
+			time.Sleep(time.Millisecond)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := rt.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("round %d: StartAllBot does not return after Shutdown", round)
+		}
+	}
+}
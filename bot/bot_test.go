@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	logger "github.com/GLGDLY/mhy_botsdk/logger"
+)
+
+// logger writing to the test log instead of the console and log files
+type testLogger struct {
+	t testing.TB
+}
+
+func (l testLogger) Log(level logger.LoggerLevel, v ...interface{}) {
+	l.t.Log(append([]interface{}{level}, v...)...)
+}
+
+func (l testLogger) Logf(level logger.LoggerLevel, format string, v ...interface{}) {
+	l.t.Logf("%v "+format, append([]interface{}{level}, v...)...)
+}
+
+func (l testLogger) Debug(v ...interface{}) { l.Log(logger.LoggerLevelDebug, v...) }
+func (l testLogger) Info(v ...interface{})  { l.Log(logger.LoggerLevelInfo, v...) }
+func (l testLogger) Warn(v ...interface{})  { l.Log(logger.LoggerLevelWarn, v...) }
+func (l testLogger) Error(v ...interface{}) { l.Log(logger.LoggerLevelError, v...) }
+
+func (l testLogger) Debugf(format string, v ...interface{}) {
+	l.Logf(logger.LoggerLevelDebug, format, v...)
+}
+func (l testLogger) Infof(format string, v ...interface{}) {
+	l.Logf(logger.LoggerLevelInfo, format, v...)
+}
+func (l testLogger) Warnf(format string, v ...interface{}) {
+	l.Logf(logger.LoggerLevelWarn, format, v...)
+}
+func (l testLogger) Errorf(format string, v ...interface{}) {
+	l.Logf(logger.LoggerLevelError, format, v...)
+}
+
+// new bot of a new runtime, logging to the test log
+func newTestBot(t testing.TB, bot_id, pubkey, addr string) *Bot {
+	_bot := NewRuntime().NewBot(bot_id, "secret", pubkey, "/", addr)
+	_bot.SetLogger(testLogger{t})
+	return _bot
+}
+
+// free local address for a bot to listen on
+func freeAddr(t testing.TB) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// wait until the server at addr accepts connections
+func waitListening(t testing.TB, addr string) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server at %s is not listening", addr)
+}
@@ -24,64 +24,205 @@ type waitForCommandRegister struct {
 	cancel   chan bool
 }
 
-func (_bot *Bot) validateWaitForCommandScope(reg waitForCommandRegister, data events.EventSendMessage) bool {
-	if reg.register.Scope&models.ScopeGlobal != 0 { // always true if global scope is enabled
+type waitForComponentRegister struct {
+	register models.WaitForComponentRegister
+	villa_id string
+	room_id  string
+	uid      string
+	channel  chan *events.EventClickMsgComponent
+	cancel   chan bool
+}
+
+func validateWaitForScope(scope models.Scope, villa_id, room_id, uid string, data_villa_id, data_room_id, data_uid uint64) bool {
+	if scope&models.ScopeGlobal != 0 { // always true if global scope is enabled
 		return true
 	}
-	if reg.register.Scope&models.ScopeVilla != 0 {
-		if reg.villa_id != utils.String(data.Data.VillaId) {
+	if scope&models.ScopeVilla != 0 {
+		if villa_id != utils.String(data_villa_id) {
 			return false
 		}
 	}
-	if reg.register.Scope&models.ScopeRoom != 0 {
-		if reg.room_id != utils.String(data.Data.RoomId) {
+	if scope&models.ScopeRoom != 0 {
+		if room_id != utils.String(data_room_id) {
 			return false
 		}
 	}
-	if reg.register.Scope&models.ScopeUser != 0 {
-		if reg.uid != utils.String(data.Data.FromUserId) {
+	if scope&models.ScopeUser != 0 {
+		if uid != utils.String(data_uid) {
 			return false
 		}
 	}
 	return true // if all scope is satisfied, return true
 }
 
+func (_bot *Bot) validateWaitForCommandScope(reg *waitForCommandRegister, data events.EventSendMessage) bool {
+	return validateWaitForScope(reg.register.Scope, reg.villa_id, reg.room_id, reg.uid, data.Data.VillaId, data.Data.RoomId, data.Data.FromUserId)
+}
+
 // return true if the short circuit is needed
 func (_bot *Bot) checkWaifForCommand(data events.EventSendMessage) bool {
+	return deliverWaitForCommand(_bot.matchWaitForCommand(data), data)
+}
+
+// registers waiting for the message
+func (_bot *Bot) matchWaitForCommand(data events.EventSendMessage) []*waitForCommandRegister {
+	var ret []*waitForCommandRegister
 	msg := data.GetContent(false)
 	at := "@" + data.Robot.Template.Name
-	for _, reg := range _bot.wait_for_command_registers {
-		if _bot.validateWaitForCommandScope(reg, data) {
-			if reg.register.Command.Command != nil {
-				for _, v := range reg.register.Command.Command {
-					if strings.Contains(msg, v) && (!reg.register.Command.RequireAT || strings.Contains(msg, at)) {
-						reg.channel <- &data
-						if reg.register.Command.IsShortCircuit {
-							return true
-						}
-					}
-				}
-			}
-			if reg.regex == nil && reg.register.Command.Regex != "" {
-				reg.regex = regexp.MustCompile(reg.register.Command.Regex)
+	for _, reg := range _bot.wait_for_command_registers.snapshot() {
+		if !_bot.validateWaitForCommandScope(reg, data) {
+			continue
+		}
+		if reg.register.Command.RequireAT && !strings.Contains(msg, at) {
+			continue
+		}
+		matched := reg.regex != nil && reg.regex.FindString(msg) != ""
+		for _, v := range reg.register.Command.Command {
+			if strings.Contains(msg, v) {
+				matched = true
+				break
 			}
-			if reg.regex != nil {
-				fmt.Println(reg.regex, msg, reg.regex.FindString(msg))
-				if reg.regex.FindString(msg) != "" && (!reg.register.Command.RequireAT || strings.Contains(msg, at)) {
-					reg.channel <- &data
-					if reg.register.Command.IsShortCircuit {
-						return true
-					}
-				}
+		}
+		if matched {
+			ret = append(ret, reg)
+		}
+	}
+	return ret
+}
+
+// send the message to the registers, return true if the short circuit is needed
+func deliverWaitForCommand(regs []*waitForCommandRegister, data events.EventSendMessage) bool {
+	for _, reg := range regs {
+		select {
+		case reg.channel <- &data:
+			if reg.register.Command.IsShortCircuit {
+				return true
 			}
+		default: // already triggered and not yet consumed
 		}
 	}
 	return false
 }
 
+// return true if the click is consumed by a waiting register
+func (_bot *Bot) checkWaitForComponent(data events.EventClickMsgComponent) bool {
+	return deliverWaitForComponent(_bot.matchWaitForComponent(data), data)
+}
+
+// registers waiting for the click
+func (_bot *Bot) matchWaitForComponent(data events.EventClickMsgComponent) []*waitForComponentRegister {
+	var ret []*waitForComponentRegister
+	for _, reg := range _bot.wait_for_component_registers.snapshot() {
+		if reg.register.ComponentID != "" && reg.register.ComponentID != data.Data.ComponentId {
+			continue
+		}
+		if reg.register.BotMsgID != "" && reg.register.BotMsgID != data.Data.BotMsgId {
+			continue
+		}
+		if validateWaitForScope(reg.register.Scope, reg.villa_id, reg.room_id, reg.uid, data.Data.VillaId, data.Data.RoomId, data.Data.Uid) {
+			ret = append(ret, reg)
+		}
+	}
+	return ret
+}
+
+// send the click to the first register accepting it, return true if it is consumed
+func deliverWaitForComponent(regs []*waitForComponentRegister, data events.EventClickMsgComponent) bool {
+	for _, reg := range regs {
+		select {
+		case reg.channel <- &data:
+			return true
+		default: // already triggered and not yet consumed
+		}
+	}
+	return false
+}
+
+/* hand the event straight to the wait-for registers it satisfies, used to let it skip the worker pool queue,
+ * so that a handler waiting for the next command is not blocked by the queue behind it.
+ * delivered is true if the event is filtered and handed to the registers, then the rest of the processing must skip both;
+ * done is true if the event needs no further processing (short circuit or repeated event) */
+func (_bot *Bot) deliverAwaited(event events.Event) (delivered, done bool) {
+	event.Event.EventBase.SetContext(_bot.context(), nil) // not processed by the middlewares
+	switch event.Event.Type {
+	case events.SendMessage:
+		if len(_bot.wait_for_command_registers.snapshot()) == 0 {
+			return false, false
+		}
+		data := events.Event2EventSendMessage(event, _bot.Api)
+		if _bot.is_filter_self_msg && data.Data.Content.User.Id == _bot.Base.ID {
+			return false, false
+		}
+		regs := _bot.matchWaitForCommand(data)
+		if len(regs) == 0 {
+			return false, false
+		}
+		if _bot.filter_manager.filter(event.Event.Id) {
+			return true, true
+		}
+		return true, deliverWaitForCommand(regs, data)
+	case events.ClickMsgComponent:
+		if len(_bot.wait_for_component_registers.snapshot()) == 0 {
+			return false, false
+		}
+		data := events.Event2EventClickMsgComponent(event, _bot.Api)
+		regs := _bot.matchWaitForComponent(data)
+		if len(regs) == 0 {
+			return false, false
+		}
+		if _bot.filter_manager.filter(event.Event.Id) {
+			return true, true
+		}
+		return true, deliverWaitForComponent(regs, data)
+	}
+	return false, false
+}
+
+// get the villa, room and user of the event for scope validation
+func parseWaitForScopeData(scope models.Scope, data interface{}) (villa_id, room_id, uid string, err error) {
+	if data != nil && reflect.TypeOf(data).Kind() == reflect.Ptr {
+		data = reflect.ValueOf(data).Elem().Interface()
+	}
+	switch data := data.(type) {
+	case events.EventJoinVilla:
+		if scope&models.ScopeRoom != 0 {
+			return "", "", "", errors.New("JoinVilla事件不可使用Room房间作用域")
+		}
+		return utils.String(data.Data.VillaId), "", utils.String(data.Data.JoinUid), nil
+	case events.EventSendMessage:
+		return utils.String(data.Data.VillaId), utils.String(data.Data.RoomId), utils.String(data.Data.FromUserId), nil
+	case events.EventCreateRobot:
+		if scope&models.ScopeRoom != 0 {
+			return "", "", "", errors.New("CreateRobot事件不可使用Room房间作用域")
+		}
+		if scope&models.ScopeUser != 0 {
+			return "", "", "", errors.New("CreateRobot事件不可使用User用户作用域")
+		}
+		return utils.String(data.Data.VillaId), "", "", nil
+	case events.EventDeleteRobot:
+		if scope&models.ScopeRoom != 0 {
+			return "", "", "", errors.New("DeleteRobot事件不可使用Room房间作用域")
+		}
+		if scope&models.ScopeUser != 0 {
+			return "", "", "", errors.New("DeleteRobot事件不可使用User用户作用域")
+		}
+		return utils.String(data.Data.VillaId), "", "", nil
+	case events.EventAddQuickEmoticon:
+		return utils.String(data.Data.VillaId), utils.String(data.Data.RoomId), utils.String(data.Data.Uid), nil
+	case events.EventAuditCallback:
+		return utils.String(data.Data.VillaId), utils.String(data.Data.RoomId), utils.String(data.Data.UserId), nil
+	case events.EventClickMsgComponent:
+		return utils.String(data.Data.VillaId), utils.String(data.Data.RoomId), utils.String(data.Data.Uid), nil
+	case nil:
+		return "", "", "", errors.New("缺少必要的参数：Data")
+	default:
+		return "", "", "", fmt.Errorf("未知的数据类型: %v", reflect.TypeOf(data))
+	}
+}
+
 /* public */
 
-// 等待特定指令的触发，并回传触发该指令的消息事件（或超时错误）；
+// 等待特定指令的触发，并回传触发该指令的消息事件（或超时错误，机器人停止时为 ErrShutdown）；
 // 用于暂停处理当前消息链，等待特定指令的触发或超时再回复
 func (_bot *Bot) WaitForCommand(reg models.WaitForCommandRegister) (*events.EventSendMessage, error) {
 	// manage default values for optional args
@@ -95,83 +236,89 @@ func (_bot *Bot) WaitForCommand(reg models.WaitForCommandRegister) (*events.Even
 	}
 
 	// create internal register struct
-	_reg := waitForCommandRegister{
+	_reg := &waitForCommandRegister{
 		register: reg,
 		channel:  make(chan *events.EventSendMessage, 1),
 		cancel:   make(chan bool, 1),
 	}
-	defer close(_reg.channel)
+	if reg.Command.Regex != "" {
+		regex, err := regexp.Compile(reg.Command.Regex)
+		if err != nil {
+			return nil, err
+		}
+		_reg.regex = regex
+	}
 
 	// valid scope with data type and write in cooresponding scope validation data
-	if reg.Data != nil && reflect.TypeOf(reg.Data).Kind() == reflect.Ptr {
-		reg.Data = reflect.ValueOf(reg.Data).Elem().Interface()
-	}
-	switch reg.Data.(type) {
-	case events.EventJoinVilla:
-		if reg.Scope&models.ScopeRoom != 0 {
-			return nil, errors.New("JoinVilla事件不可使用Room房间作用域")
-		}
-		_reg.villa_id = utils.String(reg.Data.(events.EventJoinVilla).Data.VillaId)
-		_reg.uid = utils.String(reg.Data.(events.EventJoinVilla).Data.JoinUid)
-	case events.EventSendMessage:
-		_reg.villa_id = utils.String(reg.Data.(events.EventSendMessage).Data.VillaId)
-		_reg.room_id = utils.String(reg.Data.(events.EventSendMessage).Data.RoomId)
-		_reg.uid = utils.String(reg.Data.(events.EventSendMessage).Data.FromUserId)
-	case events.EventCreateRobot:
-		if reg.Scope&models.ScopeRoom != 0 {
-			return nil, errors.New("CreateRobot事件不可使用Room房间作用域")
-		}
-		if reg.Scope&models.ScopeUser != 0 {
-			return nil, errors.New("CreateRobot事件不可使用User用户作用域")
-		}
-		_reg.villa_id = utils.String(reg.Data.(events.EventCreateRobot).Data.VillaId)
-	case events.EventDeleteRobot:
-		if reg.Scope&models.ScopeRoom != 0 {
-			return nil, errors.New("DeleteRobot事件不可使用Room房间作用域")
-		}
-		if reg.Scope&models.ScopeUser != 0 {
-			return nil, errors.New("DeleteRobot事件不可使用User用户作用域")
-		}
-		_reg.villa_id = utils.String(reg.Data.(events.EventDeleteRobot).Data.VillaId)
-	case events.EventAddQuickEmoticon:
-		_reg.villa_id = utils.String(reg.Data.(events.EventAddQuickEmoticon).Data.VillaId)
-		_reg.room_id = utils.String(reg.Data.(events.EventAddQuickEmoticon).Data.RoomId)
-		_reg.uid = utils.String(reg.Data.(events.EventAddQuickEmoticon).Data.Uid)
-	case events.EventAuditCallback:
-		_reg.villa_id = utils.String(reg.Data.(events.EventAuditCallback).Data.VillaId)
-		_reg.room_id = utils.String(reg.Data.(events.EventAuditCallback).Data.RoomId)
-		_reg.uid = utils.String(reg.Data.(events.EventAuditCallback).Data.UserId)
-	case nil:
-		return nil, errors.New("缺少必要的参数：Data")
-	default:
-		return nil, fmt.Errorf("未知的数据类型: %v", reflect.TypeOf(reg.Data))
+	var err error
+	_reg.villa_id, _reg.room_id, _reg.uid, err = parseWaitForScopeData(reg.Scope, reg.Data)
+	if err != nil {
+		return nil, err
 	}
 
 	// register to bot
-	if !(*reg.AllowRepeat) && reg.Identify != nil {
-		for _, v := range _bot.wait_for_command_registers {
-			if v.register.Identify != nil && *v.register.Identify == *reg.Identify {
-				return nil, errors.New("重复的标识 (AllowRepeat: false)")
-			}
-		}
+	handle, ok := _bot.wait_for_command_registers.addUnique(_reg, func(v *waitForCommandRegister) bool {
+		return !(*reg.AllowRepeat) && reg.Identify != nil && v.register.Identify != nil && *v.register.Identify == *reg.Identify
+	})
+	if !ok {
+		return nil, errors.New("重复的标识 (AllowRepeat: false)")
 	}
+	defer handle.Remove()
 
-	_bot.wait_for_command_registers = append(_bot.wait_for_command_registers, _reg)
-	defer func() {
-		for i, v := range _bot.wait_for_command_registers {
-			if reflect.DeepEqual(v, _reg) {
-				_bot.wait_for_command_registers = append(_bot.wait_for_command_registers[:i], _bot.wait_for_command_registers[i+1:]...)
-				break
-			}
+	// wait for command
+	var timer <-chan time.Time
+	if *reg.Timeout != 0 { // no timeout if timeout is 0
+		timer = time.After(*reg.Timeout)
+	}
+	select {
+	case res := <-_reg.channel:
+		return res, nil
+	case <-timer:
+		return nil, fmt.Errorf("timeout")
+	case <-_reg.cancel:
+		return nil, fmt.Errorf("cancel")
+	case <-_bot.shutdownChan():
+		return nil, ErrShutdown
+	}
+}
+
+// 等待消息组件（回传型按钮）被点击，并回传点击事件（或超时错误，机器人停止时为 ErrShutdown）；被等待的点击不会再传递给 ClickMsgComponent 监听器
+func (_bot *Bot) WaitForComponentClick(reg models.WaitForComponentRegister) (*events.EventClickMsgComponent, error) {
+	// manage default values for optional args
+	if reg.Timeout == nil {
+		var timeout time.Duration = 1 * time.Minute
+		reg.Timeout = &timeout
+	}
+	if reg.AllowRepeat == nil {
+		var allow_repeat bool = true
+		reg.AllowRepeat = &allow_repeat
+	}
+
+	_reg := &waitForComponentRegister{
+		register: reg,
+		channel:  make(chan *events.EventClickMsgComponent, 1),
+		cancel:   make(chan bool, 1),
+	}
+	if reg.Scope&models.ScopeGlobal == 0 && reg.Scope != 0 {
+		var err error
+		_reg.villa_id, _reg.room_id, _reg.uid, err = parseWaitForScopeData(reg.Scope, reg.Data)
+		if err != nil {
+			return nil, err
 		}
-	}()
+	}
 
-	// wait for command
+	// register to bot
+	handle, ok := _bot.wait_for_component_registers.addUnique(_reg, func(v *waitForComponentRegister) bool {
+		return !(*reg.AllowRepeat) && reg.Identify != nil && v.register.Identify != nil && *v.register.Identify == *reg.Identify
+	})
+	if !ok {
+		return nil, errors.New("重复的标识 (AllowRepeat: false)")
+	}
+	defer handle.Remove()
+
+	// wait for click
 	var timer <-chan time.Time
-	if *reg.Timeout == 0 { // no timeout if timeout is 0
-		timer := make(chan time.Time, 1)
-		defer close(timer)
-	} else {
+	if *reg.Timeout != 0 { // no timeout if timeout is 0
 		timer = time.After(*reg.Timeout)
 	}
 	select {
@@ -181,14 +328,28 @@ func (_bot *Bot) WaitForCommand(reg models.WaitForCommandRegister) (*events.Even
 		return nil, fmt.Errorf("timeout")
 	case <-_reg.cancel:
 		return nil, fmt.Errorf("cancel")
+	case <-_bot.shutdownChan():
+		return nil, ErrShutdown
 	}
 }
 
-// 取消等待特定指令的注册
+// 取消等待特定指令或组件点击的注册
 func (_bot *Bot) CancelWaitForCommand(identify string) error {
-	for i, v := range _bot.wait_for_command_registers {
+	for _, v := range _bot.wait_for_command_registers.snapshot() {
 		if v.register.Identify != nil && *v.register.Identify == identify {
-			_bot.wait_for_command_registers[i].cancel <- true
+			select {
+			case v.cancel <- true:
+			default: // already cancelled
+			}
+			return nil
+		}
+	}
+	for _, v := range _bot.wait_for_component_registers.snapshot() {
+		if v.register.Identify != nil && *v.register.Identify == identify {
+			select {
+			case v.cancel <- true:
+			default: // already cancelled
+			}
 			return nil
 		}
 	}
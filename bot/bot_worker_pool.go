@@ -0,0 +1,265 @@
+package bot
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	events "github.com/GLGDLY/mhy_botsdk/events"
+	utils "github.com/GLGDLY/mhy_botsdk/utils"
+)
+
+/* bounded worker pool for event processing */
+
+// 事件队列已满时的处理方式
+type OverflowPolicy uint8
+
+const (
+	OverflowBlock      OverflowPolicy = iota // 阻塞回调请求直至队列有空位（默认）
+	OverflowDropNewest                       // 丢弃新的事件
+	OverflowDropOldest                       // 丢弃队列中最早的事件
+	OverflowReject                           // 拒绝新的事件，HTTP回调返回503以使平台重试（ws回调返回retcode -1）
+)
+
+// 事件的串行处理方式，同一键的事件按接收顺序逐个处理
+type EventOrder uint8
+
+const (
+	OrderNone EventOrder = iota // 不保证顺序（默认）
+	OrderRoom                   // 同一房间的事件按顺序处理，没有房间的事件（如JoinVilla）不保证顺序
+	OrderUser                   // 同一用户的事件按顺序处理，没有用户的事件（如CreateRobot）不保证顺序
+)
+
+// 事件处理工作池的设置
+type WorkerPoolOptions struct {
+	Workers   int            // 处理事件的goroutine数量，<=0时为CPU核数
+	QueueSize int            // 等待处理的事件数上限，<=0时为1024
+	Overflow  OverflowPolicy // 队列已满时的处理方式
+	Order     EventOrder     // 串行处理方式
+}
+
+// OverflowReject 时队列已满的错误
+var ErrEventRejected = errors.New("event queue is full")
+
+// OverflowDropNewest 时或工作池已关闭时，事件被丢弃的错误
+var ErrEventDropped = errors.New("event dropped")
+
+type poolTask struct {
+	key  string // serial key, empty for no ordering
+	run  func()
+	drop func() // called instead of run if the task is dropped from the queue
+}
+
+type workerPool struct {
+	mu        sync.Mutex
+	not_empty *sync.Cond // signaled when a task is queued or a key is released
+	not_full  *sync.Cond // signaled when a task is taken or the pool is closed
+	queue     []*poolTask
+	running   map[string]bool // keys of the tasks being processed
+	options   WorkerPoolOptions
+	closed    bool
+}
+
+func newWorkerPool(options WorkerPoolOptions) *workerPool {
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = 1024
+	}
+	pool := &workerPool{running: make(map[string]bool), options: options}
+	pool.not_empty = sync.NewCond(&pool.mu)
+	pool.not_full = sync.NewCond(&pool.mu)
+	for i := 0; i < options.Workers; i++ {
+		go pool.worker()
+	}
+	return pool
+}
+
+// queue the task according to the overflow policy
+func (pool *workerPool) submit(task *poolTask) error {
+	pool.mu.Lock()
+	var dropped *poolTask
+	for !pool.closed && dropped == nil && len(pool.queue) >= pool.options.QueueSize {
+		switch pool.options.Overflow {
+		case OverflowDropNewest:
+			pool.mu.Unlock()
+			return ErrEventDropped
+		case OverflowReject:
+			pool.mu.Unlock()
+			return ErrEventRejected
+		case OverflowDropOldest:
+			dropped = pool.queue[0]
+			pool.queue = pool.queue[1:]
+		default:
+			pool.not_full.Wait()
+		}
+	}
+	if pool.closed {
+		pool.mu.Unlock()
+		return ErrEventDropped
+	}
+	pool.queue = append(pool.queue, task)
+	pool.mu.Unlock()
+	pool.not_empty.Signal()
+	if dropped != nil {
+		dropped.drop()
+	}
+	return nil
+}
+
+// take the first task whose key is not being processed, nil if the pool is closed and drained
+func (pool *workerPool) take() *poolTask {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for {
+		for i, task := range pool.queue {
+			if task.key != "" && pool.running[task.key] {
+				continue
+			}
+			pool.queue = append(pool.queue[:i:i], pool.queue[i+1:]...)
+			if task.key != "" {
+				pool.running[task.key] = true
+			}
+			pool.not_full.Signal()
+			return task
+		}
+		if pool.closed && len(pool.queue) == 0 {
+			return nil
+		}
+		pool.not_empty.Wait()
+	}
+}
+
+func (pool *workerPool) release(task *poolTask) {
+	if task.key == "" {
+		return
+	}
+	pool.mu.Lock()
+	delete(pool.running, task.key)
+	pool.mu.Unlock()
+	pool.not_empty.Broadcast() // the next task of the key may be waiting
+}
+
+func (pool *workerPool) worker() {
+	for task := pool.take(); task != nil; task = pool.take() {
+		task.run()
+		pool.release(task)
+	}
+}
+
+// stop accepting tasks, the queued tasks are still processed
+func (pool *workerPool) close() {
+	pool.mu.Lock()
+	pool.closed = true
+	pool.mu.Unlock()
+	pool.not_empty.Broadcast()
+	pool.not_full.Broadcast()
+}
+
+// villa, room and user of the event, 0 if the event does not have one
+func eventScope(event events.Event) (villa_id, room_id, uid uint64) {
+	data := event.Event.ExtendData.EventData
+	switch event.Event.Type {
+	case events.JoinVilla:
+		return data.JoinVilla.VillaId, 0, data.JoinVilla.JoinUid
+	case events.SendMessage:
+		return data.SendMessage.VillaId, data.SendMessage.RoomId, data.SendMessage.FromUserId
+	case events.CreateRobot:
+		return data.CreateRobot.VillaId, 0, 0
+	case events.DeleteRobot:
+		return data.DeleteRobot.VillaId, 0, 0
+	case events.AddQuickEmoticon:
+		return data.AddQuickEmoticon.VillaId, data.AddQuickEmoticon.RoomId, data.AddQuickEmoticon.Uid
+	case events.AuditCallback:
+		return data.AuditCallback.VillaId, data.AuditCallback.RoomId, data.AuditCallback.UserId
+	case events.ClickMsgComponent:
+		return data.ClickMsgComponent.VillaId, data.ClickMsgComponent.RoomId, data.ClickMsgComponent.Uid
+	}
+	return 0, 0, 0
+}
+
+// serial key of the event, empty if the event has no room or user to order by
+func eventOrderKey(event events.Event, order EventOrder) string {
+	villa_id, room_id, uid := eventScope(event)
+	switch {
+	case order == OrderRoom && room_id != 0:
+		return "room:" + utils.String(villa_id) + ":" + utils.String(room_id)
+	case order == OrderUser && uid != 0:
+		return "user:" + utils.String(uid)
+	}
+	return ""
+}
+
+/* 设置处理事件的工作池，默认为nil，即每个事件使用一个新的goroutine处理
+ *
+ * 事件在队列中等待空闲的goroutine处理，队列已满时按 Overflow 处理；Order 不为 OrderNone 时同一房间/用户的事件按接收顺序逐个处理。
+ * 满足等待中的 WaitForCommand、WaitForComponentClick 的事件在收到时直接交给等待的处理函数（不经过队列、中间件及预处理器），
+ * 以免处理函数等待同一用户的下一个指令时被队列阻塞；未被短路的事件再进入队列执行其余的处理流程。
+ * 等待中的处理函数仍会占用工作池的goroutine，大量并发等待时应增加 Workers。运行中设置时，旧工作池中的事件仍会处理完毕 */
+func (_bot *Bot) SetWorkerPool(options *WorkerPoolOptions) {
+	_bot.state_mu.Lock()
+	old_pool := _bot.worker_pool
+	_bot.worker_pool_options = options
+	_bot.worker_pool = nil
+	if options != nil && _bot.is_running {
+		_bot.worker_pool = newWorkerPool(*options)
+	}
+	_bot.state_mu.Unlock()
+	if old_pool != nil {
+		old_pool.close()
+	}
+}
+
+// must be called with state_mu held
+func (_bot *Bot) startWorkerPoolLocked() {
+	if _bot.worker_pool == nil && _bot.worker_pool_options != nil {
+		_bot.worker_pool = newWorkerPool(*_bot.worker_pool_options)
+	}
+}
+
+func (_bot *Bot) stopWorkerPool() {
+	_bot.state_mu.Lock()
+	pool := _bot.worker_pool
+	_bot.worker_pool = nil
+	_bot.state_mu.Unlock()
+	if pool != nil {
+		pool.close()
+	}
+}
+
+// process the event with the worker pool, or in a new goroutine if there is no pool; event_wg.Done is called after it is processed or dropped
+func (_bot *Bot) submitEvent(event events.Event, event_wg *sync.WaitGroup) error {
+	_bot.state_mu.RLock()
+	pool := _bot.worker_pool
+	_bot.state_mu.RUnlock()
+	awaited := false
+	if pool != nil {
+		var done bool
+		if awaited, done = _bot.deliverAwaited(event); done {
+			event_wg.Done()
+			return nil
+		}
+	}
+	run := func() {
+		defer event_wg.Done()
+		processEvent(_bot, event, awaited)
+	}
+	if pool == nil {
+		go run() // use goroutine to avoid blocking (especially handle wait_for)
+		return nil
+	}
+	err := pool.submit(&poolTask{
+		key: eventOrderKey(event, pool.options.Order),
+		run: run,
+		drop: func() {
+			_bot.Logger.Warnf("event queue is full, drop event: %v\n", event.Event.Id)
+			event_wg.Done()
+		},
+	})
+	if err != nil {
+		_bot.Logger.Warnf("event not processed (%v): %v\n", err, event.Event.Id)
+		event_wg.Done()
+	}
+	return err
+}
@@ -0,0 +1,243 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	events "github.com/GLGDLY/mhy_botsdk/events"
+	"github.com/GLGDLY/mhy_botsdk/eventsim"
+	models "github.com/GLGDLY/mhy_botsdk/models"
+)
+
+// records the order of the tasks run or dropped by a pool
+type poolLog struct {
+	mu      sync.Mutex
+	ran     []int
+	dropped []int
+}
+
+func (l *poolLog) task(i int, key string, block <-chan struct{}) *poolTask {
+	return &poolTask{
+		key: key,
+		run: func() {
+			if block != nil {
+				<-block
+			}
+			l.mu.Lock()
+			l.ran = append(l.ran, i)
+			l.mu.Unlock()
+		},
+		drop: func() {
+			l.mu.Lock()
+			l.dropped = append(l.dropped, i)
+			l.mu.Unlock()
+		},
+	}
+}
+
+func (l *poolLog) get() (ran, dropped []int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]int{}, l.ran...), append([]int{}, l.dropped...)
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pool with its only worker blocked by task 0 and a full queue of tasks 1 and 2
+func fullPool(t *testing.T, overflow OverflowPolicy) (*workerPool, *poolLog, chan struct{}) {
+	pool := newWorkerPool(WorkerPoolOptions{Workers: 1, QueueSize: 2, Overflow: overflow})
+	log := &poolLog{}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := pool.submit(&poolTask{run: func() { close(started); <-release }}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	for i := 1; i <= 2; i++ {
+		if err := pool.submit(log.task(i, "", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pool, log, release
+}
+
+// close the pool after releasing the worker (if not yet released), and wait until want tasks are run or dropped
+func drainPool(pool *workerPool, log *poolLog, release chan struct{}, want int) (ran, dropped []int) {
+	if release != nil {
+		close(release)
+	}
+	pool.close()
+	for i := 0; i < 200; i++ {
+		if ran, dropped = log.get(); len(ran)+len(dropped) >= want {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return
+}
+
+func TestWorkerPoolOverflowDropNewest(t *testing.T) {
+	pool, log, release := fullPool(t, OverflowDropNewest)
+	if err := pool.submit(log.task(3, "", nil)); !errors.Is(err, ErrEventDropped) {
+		t.Errorf("submit() error = %v, want ErrEventDropped", err)
+	}
+	if ran, dropped := drainPool(pool, log, release, 2); !equalInts(ran, []int{1, 2}) || len(dropped) != 0 {
+		t.Errorf("ran %v, dropped %v", ran, dropped)
+	}
+}
+
+func TestWorkerPoolOverflowReject(t *testing.T) {
+	pool, log, release := fullPool(t, OverflowReject)
+	if err := pool.submit(log.task(3, "", nil)); !errors.Is(err, ErrEventRejected) {
+		t.Errorf("submit() error = %v, want ErrEventRejected", err)
+	}
+	if ran, dropped := drainPool(pool, log, release, 2); !equalInts(ran, []int{1, 2}) || len(dropped) != 0 {
+		t.Errorf("ran %v, dropped %v", ran, dropped)
+	}
+}
+
+func TestWorkerPoolOverflowDropOldest(t *testing.T) {
+	pool, log, release := fullPool(t, OverflowDropOldest)
+	if err := pool.submit(log.task(3, "", nil)); err != nil {
+		t.Errorf("submit() error = %v", err)
+	}
+	if ran, dropped := drainPool(pool, log, release, 3); !equalInts(ran, []int{2, 3}) || !equalInts(dropped, []int{1}) {
+		t.Errorf("ran %v, dropped %v, want ran [2 3] and dropped [1]", ran, dropped)
+	}
+}
+
+func TestWorkerPoolOverflowBlock(t *testing.T) {
+	pool, log, release := fullPool(t, OverflowBlock)
+	submitted := make(chan error, 1)
+	go func() {
+		submitted <- pool.submit(log.task(3, "", nil))
+	}()
+	select {
+	case err := <-submitted:
+		t.Fatalf("submit() to a full queue returned %v without blocking", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-submitted; err != nil {
+		t.Errorf("blocked submit() error = %v", err)
+	}
+	if ran, dropped := drainPool(pool, log, nil, 3); !equalInts(ran, []int{1, 2, 3}) || len(dropped) != 0 {
+		t.Errorf("ran %v, dropped %v", ran, dropped)
+	}
+}
+
+func TestWorkerPoolClosed(t *testing.T) {
+	pool := newWorkerPool(WorkerPoolOptions{Workers: 1})
+	pool.close()
+	log := &poolLog{}
+	if err := pool.submit(log.task(1, "", nil)); !errors.Is(err, ErrEventDropped) {
+		t.Errorf("submit() to a closed pool error = %v, want ErrEventDropped", err)
+	}
+}
+
+func TestWorkerPoolOrder(t *testing.T) {
+	pool := newWorkerPool(WorkerPoolOptions{Workers: 4})
+	log := &poolLog{}
+	block := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		pool.submit(log.task(i, "room:1:1", block)) // same key, processed one by one
+	}
+	pool.submit(log.task(3, "room:1:2", nil)) // other key, not blocked by the first room
+	for i := 0; i < 200; i++ {
+		if ran, _ := log.get(); len(ran) == 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ran, _ := log.get(); !equalInts(ran, []int{3}) {
+		t.Fatalf("ran %v before releasing the first room, want [3]", ran)
+	}
+	close(block)
+	pool.close()
+	for i := 0; i < 200; i++ {
+		if ran, _ := log.get(); len(ran) == 4 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ran, _ := log.get(); !equalInts(ran, []int{3, 0, 1, 2}) {
+		t.Errorf("ran %v, want [3 0 1 2]", ran)
+	}
+}
+
+func TestWorkerPoolWaitForBypass(t *testing.T) {
+	sim, err := eventsim.NewSimulator("bot_pool", "secret", "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_bot := newTestBot(t, "bot_pool", sim.PubKey(), ":0")
+	_bot.SetWorkerPool(&WorkerPoolOptions{Workers: 1, QueueSize: 4, Order: OrderUser})
+
+	var mu sync.Mutex
+	var order []string
+	record := func(s string) {
+		mu.Lock()
+		order = append(order, s)
+		mu.Unlock()
+	}
+	_bot.AddListenerSendMessage(func(e events.EventSendMessage) {
+		content := e.GetContent(true)
+		if content != "wait" {
+			record(content)
+			return
+		}
+		timeout := 5 * time.Second
+		res, err := _bot.WaitForCommand(models.WaitForCommandRegister{
+			Scope:   models.ScopeUser,
+			Command: models.CommandBase{Command: []string{"yes"}, IsShortCircuit: true},
+			Data:    e,
+			Timeout: &timeout,
+		})
+		if err != nil {
+			record("error: " + err.Error())
+			return
+		}
+		record("waited " + res.GetContent(true))
+	})
+	handler := _bot.Handler()
+	send := func(text string) {
+		body, err := sim.SendMessage(eventsim.Message{VillaID: 1, RoomID: 2, FromUserID: 3, Nickname: "u", Parts: []interface{}{text}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := sim.ServeHTTP(handler, "/", body).Code; code != http.StatusOK {
+			t.Fatalf("callback of %q returned %d", text, code)
+		}
+	}
+
+	send("wait")
+	for len(_bot.wait_for_command_registers.snapshot()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	send("other") // not awaited, queued behind the waiting handler
+	send("yes")   // awaited, handed to the waiting handler directly
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := _bot.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 2 || order[0] != "waited yes" || order[1] != "other" {
+		t.Errorf("processed %q, want [\"waited yes\" \"other\"]", order)
+	}
+}
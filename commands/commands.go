@@ -12,13 +12,10 @@ import (
 
 // internal use
 func CommandCheckIsAdmin(ListenerName string, AdminErrorMsg string, data events.EventSendMessage, _logger logger.LoggerInterface, _api *apis.ApiBase) bool {
-	res, http_code, err := _api.GetMember(data.Robot.VillaId, data.Data.FromUserId)
-	if err != nil {
+	res, _, err := _api.GetMemberCtx(data.Context(), data.Robot.VillaId, data.Data.FromUserId)
+	if err != nil { // including non-zero retcode, see apis.APIError
 		_logger.Error("command listener {", ListenerName, "} get member role info error: ", err)
 		return false
-	} else if http_code != 200 || res.Retcode != 0 {
-		_logger.Error("command listener {", ListenerName, "} get member role info error: ", res)
-		return false
 	}
 	is_admin := false
 	for _, v := range res.Data.Member.RoleList {
@@ -32,7 +29,7 @@ func CommandCheckIsAdmin(ListenerName string, AdminErrorMsg string, data events.
 			if err != nil {
 				_logger.Error("command listener {", ListenerName, "} error: ", err)
 			}
-			_, http, err := _api.SendMessage(data.Robot.VillaId, data.Data.RoomId, AdminErrorMsg)
+			_, http, err := _api.SendMessageCtx(data.Context(), data.Robot.VillaId, data.Data.RoomId, AdminErrorMsg)
 			if err != nil || http != 200 {
 				_logger.Error("command listener {", ListenerName, "} error on sending admin error msg: ", err, "(", http, ")")
 			}
@@ -70,7 +67,7 @@ func (p *OnCommand) processCommand(data events.EventSendMessage, _logger logger.
 	// check user custom permission
 	if p.RequirePermission != nil && !p.RequirePermission(data) {
 		if p.PermissionErrorMsg != "" {
-			_, http, err := _api.SendMessage(data.Robot.VillaId, data.Data.RoomId, p.PermissionErrorMsg)
+			_, http, err := _api.SendMessageCtx(data.Context(), data.Robot.VillaId, data.Data.RoomId, p.PermissionErrorMsg)
 			if err != nil || http != 200 {
 				_logger.Error("command listener {", utils.GetFunctionName(p.Listener), "} error on sending permission error msg: ", err, "(", http, ")")
 			}
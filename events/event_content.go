@@ -0,0 +1,251 @@
+package events
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	api_models "github.com/GLGDLY/mhy_botsdk/api_models"
+)
+
+/* incoming message content */
+
+// 消息中的实体，Offset与Length均以UTF-16编码单元计算
+type MsgContentEntity struct {
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+	Entity struct {
+		Type                   api_models.MsgEntityType `json:"type"`
+		BotId                  string                   `json:"bot_id"`                    // mentioned_robot
+		UserId                 string                   `json:"user_id"`                   // mentioned_user
+		VillaId                string                   `json:"villa_id"`                  // villa_room_link
+		RoomId                 string                   `json:"room_id"`                   // villa_room_link
+		Url                    string                   `json:"url"`                       // link
+		RequiresBotAccessToken bool                     `json:"requires_bot_access_token"` // link
+		FontStyle              string                   `json:"font_style"`                // style
+	} `json:"entity"`
+}
+
+type MsgContentImage struct {
+	Url  string `json:"url"`
+	Size struct {
+		Width  uint64 `json:"width"`
+		Height uint64 `json:"height"`
+	} `json:"size"`
+	FileSize uint64 `json:"file_size"`
+}
+
+// 消息的content，文本消息使用Text、Entities、Images，图片消息使用Url、Size、FileSize，帖子消息使用PostId
+type MsgContentBody struct {
+	Text     string             `json:"text"`
+	Entities []MsgContentEntity `json:"entities"`
+	Images   []MsgContentImage  `json:"images"`
+	MsgContentImage
+	PostId string `json:"post_id"`
+}
+
+type MsgContentQuote struct {
+	QuotedMessageId         string `json:"quoted_message_id"`
+	QuotedMessageSendTime   int64  `json:"quoted_message_send_time"`
+	OriginalMessageId       string `json:"original_message_id"`
+	OriginalMessageSendTime int64  `json:"original_message_send_time"`
+}
+
+type MsgMemberRole struct {
+	Name          string `json:"name"`
+	Color         string `json:"color"`
+	WebColor      string `json:"web_color"`
+	RoleFontColor string `json:"role_font_color"`
+	RoleBgColor   string `json:"role_bg_color"`
+}
+
+// 发送者的身份组，兼容单个对象与数组两种格式
+type MsgMemberRoles []MsgMemberRole
+
+func (roles *MsgMemberRoles) UnmarshalJSON(b []byte) error {
+	var list []MsgMemberRole
+	if err := json.Unmarshal(b, &list); err == nil {
+		*roles = list
+		return nil
+	}
+	var role MsgMemberRole
+	if err := json.Unmarshal(b, &role); err != nil {
+		return err
+	}
+	*roles = MsgMemberRoles{role}
+	return nil
+}
+
+// 消息中跳转房间的实体
+type MentionedRoom struct {
+	Text    string
+	VillaId uint64
+	RoomId  uint64
+}
+
+// 消息中的链接实体
+type MsgLink struct {
+	Text                   string
+	Url                    string
+	RequiresBotAccessToken bool
+}
+
+/* helper functions for EventSendMessage content */
+
+// 获取实体所覆盖的文本
+func (e *EventSendMessage) EntityText(entity MsgContentEntity) string {
+	text := utf16.Encode([]rune(e.Data.Content.Content.Text))
+	start, end := entity.Offset, entity.Offset+entity.Length
+	if start < 0 || end > len(text) || start > end {
+		return ""
+	}
+	return string(utf16.Decode(text[start:end]))
+}
+
+func (e *EventSendMessage) entitiesOf(entity_type api_models.MsgEntityType) []MsgContentEntity {
+	ret := []MsgContentEntity{}
+	for _, entity := range e.Data.Content.Content.Entities {
+		if entity.Entity.Type == entity_type {
+			ret = append(ret, entity)
+		}
+	}
+	return ret
+}
+
+// 消息中艾特的用户id（不包括机器人）
+func (e *EventSendMessage) MentionedUsers() []uint64 {
+	ret := []uint64{}
+	for _, entity := range e.entitiesOf(api_models.MsgEntityMentionUserType) {
+		if uid, err := strconv.ParseUint(entity.Entity.UserId, 10, 64); err == nil {
+			ret = append(ret, uid)
+		}
+	}
+	return ret
+}
+
+// 消息中艾特的机器人id
+func (e *EventSendMessage) MentionedRobots() []string {
+	ret := []string{}
+	for _, entity := range e.entitiesOf(api_models.MsgEntityMentionRobotType) {
+		ret = append(ret, entity.Entity.BotId)
+	}
+	return ret
+}
+
+// 消息中跳转房间的实体
+func (e *EventSendMessage) MentionedRooms() []MentionedRoom {
+	ret := []MentionedRoom{}
+	for _, entity := range e.entitiesOf(api_models.MsgEntityVillaRoomLinkType) {
+		villa_id, _ := strconv.ParseUint(entity.Entity.VillaId, 10, 64)
+		room_id, _ := strconv.ParseUint(entity.Entity.RoomId, 10, 64)
+		ret = append(ret, MentionedRoom{Text: e.EntityText(entity), VillaId: villa_id, RoomId: room_id})
+	}
+	return ret
+}
+
+// 消息中的链接
+func (e *EventSendMessage) Links() []MsgLink {
+	ret := []MsgLink{}
+	for _, entity := range e.entitiesOf(api_models.MsgEntityLinkType) {
+		ret = append(ret, MsgLink{Text: e.EntityText(entity), Url: entity.Entity.Url, RequiresBotAccessToken: entity.Entity.RequiresBotAccessToken})
+	}
+	return ret
+}
+
+// 是否艾特了当前机器人
+func (e *EventSendMessage) IsMentioningBot() bool {
+	for _, bot_id := range e.MentionedRobots() {
+		if bot_id == e.Robot.Template.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否艾特了全体成员
+func (e *EventSendMessage) IsMentioningAll() bool {
+	return len(e.entitiesOf(api_models.MsgEntityMentionAllType)) > 0 || e.Data.Content.MentionedInfo.Type == uint8(api_models.MentionAll)
+}
+
+// 消息引用的消息，没有引用时返回false
+func (e *EventSendMessage) Quote() (MsgContentQuote, bool) {
+	if e.Data.Content.Quote == nil || e.Data.Content.Quote.QuotedMessageId == "" {
+		return MsgContentQuote{}, false
+	}
+	return *e.Data.Content.Quote, true
+}
+
+// 消息中的图片，包括文本消息附带的图片及图片消息本身
+func (e *EventSendMessage) Images() []MsgContentImage {
+	ret := append([]MsgContentImage{}, e.Data.Content.Content.Images...)
+	if e.Data.Content.Content.Url != "" {
+		ret = append(ret, e.Data.Content.Content.MsgContentImage)
+	}
+	return ret
+}
+
+// 帖子消息的帖子id，不是帖子消息时为空
+func (e *EventSendMessage) PostId() string {
+	return e.Data.Content.Content.PostId
+}
+
+// 去除所有艾特（用户、机器人、全体）后的文本，并去除首尾空白
+func (e *EventSendMessage) PlainTextWithoutMentions() string {
+	text := utf16.Encode([]rune(e.Data.Content.Content.Text))
+	removed := make([]bool, len(text))
+	for _, entity := range e.Data.Content.Content.Entities {
+		switch entity.Entity.Type {
+		case api_models.MsgEntityMentionUserType, api_models.MsgEntityMentionRobotType, api_models.MsgEntityMentionAllType:
+			for i := entity.Offset; i < entity.Offset+entity.Length && i < len(text); i++ {
+				if i >= 0 {
+					removed[i] = true
+				}
+			}
+		}
+	}
+	kept := make([]uint16, 0, len(text))
+	for i, c := range text {
+		if !removed[i] {
+			kept = append(kept, c)
+		}
+	}
+	return strings.TrimSpace(string(utf16.Decode(kept)))
+}
+
+func (e *EventSendMessage) markupEntities() []api_models.MarkupEntity {
+	entities := make([]api_models.MarkupEntity, 0, len(e.Data.Content.Content.Entities))
+	for _, entity := range e.Data.Content.Content.Entities {
+		entities = append(entities, api_models.MarkupEntity{
+			Offset:                 entity.Offset,
+			Length:                 entity.Length,
+			Type:                   entity.Entity.Type,
+			UserID:                 entity.Entity.UserId,
+			BotID:                  entity.Entity.BotId,
+			RoomID:                 entity.Entity.RoomId,
+			URL:                    entity.Entity.Url,
+			RequiresBotAccessToken: entity.Entity.RequiresBotAccessToken,
+			FontStyle:              entity.Entity.FontStyle,
+		})
+	}
+	return entities
+}
+
+func (e *EventSendMessage) imageURLs() []string {
+	images := e.Images()
+	image_urls := make([]string, 0, len(images))
+	for _, image := range images {
+		image_urls = append(image_urls, image.Url)
+	}
+	return image_urls
+}
+
+// 将消息的文本、实体及附带的图片转换为内嵌格式（语法见 api_models.ParseMarkup），可直接传入 Reply 转发
+func (e *EventSendMessage) Markup() string {
+	return api_models.RenderMarkup(e.Data.Content.Content.Text, e.markupEntities(), e.imageURLs())
+}
+
+// 将消息转换为Markdown（语法见 api_models.ParseMarkdown），便于记录及存档
+func (e *EventSendMessage) Markdown() string {
+	return api_models.RenderMarkdown(e.Data.Content.Content.Text, e.markupEntities(), e.imageURLs())
+}
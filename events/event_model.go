@@ -1,6 +1,7 @@
 package events
 
 import (
+	"context"
 	"encoding/json"
 	"strings"
 
@@ -14,12 +15,13 @@ import (
 type EventType uint8
 
 const (
-	JoinVilla        EventType = 1
-	SendMessage      EventType = 2
-	CreateRobot      EventType = 3
-	DeleteRobot      EventType = 4
-	AddQuickEmoticon EventType = 5
-	AuditCallback    EventType = 6
+	JoinVilla         EventType = 1
+	SendMessage       EventType = 2
+	CreateRobot       EventType = 3
+	DeleteRobot       EventType = 4
+	AddQuickEmoticon  EventType = 5
+	AuditCallback     EventType = 6
+	ClickMsgComponent EventType = 7
 )
 
 /* --------- enum EventType end --------- */
@@ -46,46 +48,23 @@ type SendMessageData struct {
 			RongSdkVersion    string `json:"rong_sdk_version"`
 		} `json:"trace"`
 		MentionedInfo struct {
-			MentionedContent string   `json:"mentioned_content"`
-			UserIdList       []string `json:"user_id_list"`
-			Type             uint8    `json:"type"`
-		} `json:"mentioned_info"`
+			MentionedContent string   `json:"mentionedContent"`
+			UserIdList       []string `json:"userIdList"`
+			Type             uint8    `json:"type"` // 1为艾特全体，2为艾特部分用户或机器人
+		} `json:"mentionedInfo"`
 		User struct {
-			PortraitUri string `json:"portrait_uri"`
+			PortraitUri string `json:"portraitUri"`
 			Extra       struct {
-				MemberRoles struct {
-					Name          string `json:"name"`
-					Color         string `json:"color"`
-					WebColor      string `json:"web_color"`
-					RoleFontColor string `json:"role_font_color"`
-					RoleBgColor   string `json:"role_bg_color"`
-				} `json:"member_roles"`
-				State interface{} `json:"state"`
+				MemberRoles MsgMemberRoles `json:"member_roles"`
+				State       interface{}    `json:"state"`
 			} `json:"extra"`
 			Name     string `json:"name"`
 			Alias    string `json:"alias"`
 			Id       string `json:"id"`
 			Portrait string `json:"portrait"`
 		} `json:"user"`
-		Content struct {
-			Images []struct { // just guessing its data structure
-				Url  string `json:"url"`
-				Size struct {
-					Width  uint64 `json:"width"`
-					Height uint64 `json:"height"`
-				} `json:"size"`
-				FileSize uint64 `json:"file_size"`
-			} `json:"images"`
-			Entities []struct {
-				Offset uint8 `json:"offset"`
-				Length uint8 `json:"length"`
-				Entity struct {
-					Type  string `json:"type"`
-					BotId string `json:"bot_id"`
-				} `json:"entity"`
-			} `json:"entities"`
-			Text string `json:"text"`
-		}
+		Content MsgContentBody   `json:"content"`
+		Quote   *MsgContentQuote `json:"quote,omitempty"` // 引用的消息，没有引用时为nil
 	}
 	FromUserId uint64 `json:"from_user_id"`
 	SendAt     uint64 `json:"send_at"`
@@ -126,6 +105,17 @@ type AuditCallbackData struct {
 	AuditResult uint   `json:"audit_result"`
 }
 
+type ClickMsgComponentData struct {
+	VillaId     uint64 `json:"villa_id"`
+	RoomId      uint64 `json:"room_id"`
+	ComponentId string `json:"component_id"` // 被点击组件的id，即 api_models.MsgComponent.ID
+	MsgUid      string `json:"msg_uid"`
+	Uid         uint64 `json:"uid"` // 点击组件的用户id
+	BotMsgId    string `json:"bot_msg_id"`
+	TemplateId  uint64 `json:"template_id"` // 使用组件模板时的模板id
+	Extra       string `json:"extra"`       // 组件的extra
+}
+
 /* general */
 
 type Robot struct {
@@ -148,6 +138,37 @@ type EventBase struct {
 	CreatedAt uint64    `json:"created_at"`
 	Id        string    `json:"id"`
 	SendAt    uint64    `json:"send_at"`
+
+	ctx    context.Context // context of the event processing, set by the bot before calling the handlers
+	values EventValues     // values set by the middlewares
+}
+
+// 事件处理过程中由中间件保存的键值（如 bot.EventContext）
+type EventValues interface {
+	Get(key string) (interface{}, bool)
+}
+
+// 设置该事件的context.Context及中间件保存的键值，由机器人在调用处理函数前设置
+func (e *EventBase) SetContext(ctx context.Context, values EventValues) {
+	e.ctx = ctx
+	e.values = values
+}
+
+// 该事件的context.Context，带有中间件设置的值，机器人停止时取消（事件处理完毕后仍可使用，如在goroutine中或 WaitForCommand 之后回复）；
+// 事件的回复等辅助函数默认使用该ctx，未设置时为context.Background()
+func (e *EventBase) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// 获取中间件通过 EventContext.Set 保存的值
+func (e *EventBase) Get(key string) (interface{}, bool) {
+	if e.values == nil {
+		return nil, false
+	}
+	return e.values.Get(key)
 }
 
 /* event */
@@ -183,17 +204,24 @@ type EventAuditCallback struct {
 	Data AuditCallbackData
 }
 
+type EventClickMsgComponent struct {
+	EventBase
+	Data ClickMsgComponentData
+	api  *apis.ApiBase // used for reply helper function
+}
+
 type Event struct {
 	Event struct {
 		EventBase
 		ExtendData struct {
 			EventData struct {
-				JoinVilla        JoinVillaData        `json:"JoinVilla,omitempty"`
-				SendMessage      SendMessageData      `json:"SendMessage,omitempty"`
-				CreateRobot      CreateRobotData      `json:"CreateRobot,omitempty"`
-				DeleteRobot      DeleteRobotData      `json:"DeleteRobot,omitempty"`
-				AddQuickEmoticon AddQuickEmoticonData `json:"AddQuickEmoticon,omitempty"`
-				AuditCallback    AuditCallbackData    `json:"AuditCallback,omitempty"`
+				JoinVilla         JoinVillaData         `json:"JoinVilla,omitempty"`
+				SendMessage       SendMessageData       `json:"SendMessage,omitempty"`
+				CreateRobot       CreateRobotData       `json:"CreateRobot,omitempty"`
+				DeleteRobot       DeleteRobotData       `json:"DeleteRobot,omitempty"`
+				AddQuickEmoticon  AddQuickEmoticonData  `json:"AddQuickEmoticon,omitempty"`
+				AuditCallback     AuditCallbackData     `json:"AuditCallback,omitempty"`
+				ClickMsgComponent ClickMsgComponentData `json:"ClickMsgComponent,omitempty"`
 			} `json:"EventData"`
 		} `json:"extend_data"`
 	} `json:"event"`
@@ -219,15 +247,45 @@ func (e *EventSendMessage) GetContent(is_treat bool) string {
 // 在相应的房间回复消息 i.e. wrapper for api.SendMessage
 // 使用内嵌格式发送消息，并自动处理内部Entity（<@xxx>为艾特机器人或用户，<@everyone>为艾特全体，<#xxx>为跳转房间，<$xxx>为跳转连接）
 // 艾特用户会自动获取用户昵称，跳转房间会自动获取房间名称；艾特机器人会显示文字“机器人”，艾特全体会显示“全体成员”，跳转连接会显示链接自身
+// 另支持<$url|文字>自定义链接文字、<$$url>附带bot_member_access_token的链接、<!url>附带图片，以及<b>粗体</b>、<i>斜体</i>、<s>删除线</s>、<u>下划线</u>，完整语法见 api_models.ParseMarkup
 // 使用\< 和 \> 可转义 < 和 >，不会被解析为Entity
 func (e *EventSendMessage) Reply(msg ...string) (api_models.SendMessageModel, int, error) {
-	return e.api.SendMessage(e.Robot.VillaId, e.Data.RoomId, msg...)
+	return e.api.SendMessageCtx(e.Context(), e.Robot.VillaId, e.Data.RoomId, msg...)
+}
+
+// Reply 的context版本，ctx被取消时会中断仍未完成的请求
+func (e *EventSendMessage) ReplyCtx(ctx context.Context, msg ...string) (api_models.SendMessageModel, int, error) {
+	return e.api.SendMessageCtx(ctx, e.Robot.VillaId, e.Data.RoomId, msg...)
 }
 
 // 在相应的房间回复消息 i.e. wrapper for api.SendMessageCustomize
 // 使用models.NewMsg创建消息，然后使用models.SetText等方法加入内容，最后使用此函数发送
-func (e *EventSendMessage) ReplyCustomize(msg api_models.MsgInputModel) (api_models.SendMessageModel, int, error) {
-	return e.api.SendMessageCustomize(e.Robot.VillaId, e.Data.RoomId, msg)
+func (e *EventSendMessage) ReplyCustomize(msg api_models.MsgBuilder) (api_models.SendMessageModel, int, error) {
+	return e.api.SendMessageCustomizeCtx(e.Context(), e.Robot.VillaId, e.Data.RoomId, msg)
+}
+
+// ReplyCustomize 的context版本，ctx被取消时会中断仍未完成的请求
+func (e *EventSendMessage) ReplyCustomizeCtx(ctx context.Context, msg api_models.MsgBuilder) (api_models.SendMessageModel, int, error) {
+	return e.api.SendMessageCustomizeCtx(ctx, e.Robot.VillaId, e.Data.RoomId, msg)
+}
+
+/* helper functions for EventClickMsgComponent */
+
+// 在组件所在的房间回复消息，用于响应组件的点击，格式与 EventSendMessage.Reply 相同
+func (e *EventClickMsgComponent) Reply(msg ...string) (api_models.SendMessageModel, int, error) {
+	return e.api.SendMessageCtx(e.Context(), e.Data.VillaId, e.Data.RoomId, msg...)
+}
+
+func (e *EventClickMsgComponent) ReplyCtx(ctx context.Context, msg ...string) (api_models.SendMessageModel, int, error) {
+	return e.api.SendMessageCtx(ctx, e.Data.VillaId, e.Data.RoomId, msg...)
+}
+
+func (e *EventClickMsgComponent) ReplyCustomize(msg api_models.MsgBuilder) (api_models.SendMessageModel, int, error) {
+	return e.api.SendMessageCustomizeCtx(e.Context(), e.Data.VillaId, e.Data.RoomId, msg)
+}
+
+func (e *EventClickMsgComponent) ReplyCustomizeCtx(ctx context.Context, msg api_models.MsgBuilder) (api_models.SendMessageModel, int, error) {
+	return e.api.SendMessageCustomizeCtx(ctx, e.Data.VillaId, e.Data.RoomId, msg)
 }
 
 /* helpher functions for internal converting */
@@ -276,6 +334,14 @@ func Event2EventAuditCallback(event Event) EventAuditCallback {
 	return eventAuditCallback
 }
 
+func Event2EventClickMsgComponent(event Event, api *apis.ApiBase) EventClickMsgComponent {
+	var eventClickMsgComponent EventClickMsgComponent
+	eventClickMsgComponent.EventBase = event.Event.EventBase
+	eventClickMsgComponent.Data = event.Event.ExtendData.EventData.ClickMsgComponent
+	eventClickMsgComponent.api = api
+	return eventClickMsgComponent
+}
+
 /* listeners for each event */
 type BotListenerJoinVilla func(data EventJoinVilla)
 type BotListenerSendMessage func(data EventSendMessage)
@@ -283,6 +349,7 @@ type BotListenerCreateRobot func(data EventCreateRobot)
 type BotListenerDeleteRobot func(data EventDeleteRobot)
 type BotListenerAddQuickEmoticon func(data EventAddQuickEmoticon)
 type BotListenerAuditCallback func(data EventAuditCallback)
+type BotListenerClickMsgComponent func(data EventClickMsgComponent)
 
 /* raw request listener */
 type BotListenerRawRequest func(c *gin.Context)
@@ -0,0 +1,94 @@
+package events
+
+import (
+	"context"
+	"strings"
+
+	api_models "github.com/GLGDLY/mhy_botsdk/api_models"
+	apis "github.com/GLGDLY/mhy_botsdk/apis"
+)
+
+/* reply helpers for EventSendMessage, all requests are sent by the bot's ApiBase */
+
+// 引用当前消息进行回复，msg的格式与 Reply 相同
+func (e *EventSendMessage) ReplyQuote(msg ...string) (api_models.SendMessageModel, int, error) {
+	return e.ReplyQuoteCtx(e.Context(), msg...)
+}
+
+func (e *EventSendMessage) ReplyQuoteCtx(ctx context.Context, msg ...string) (api_models.SendMessageModel, int, error) {
+	_msg, _ := api_models.NewMsg(api_models.MsgTypeText)
+	if err := e.api.MessageParserCtx(ctx, &_msg, e.Robot.VillaId, msg...); err != nil {
+		return api_models.SendMessageModel{}, apis.HttpStatusLocalError, err
+	}
+	_msg.SetTextQuote(e.Data.MsgUid, e.Data.SendAt)
+	return e.api.SendMessageCustomizeCtx(ctx, e.Robot.VillaId, e.Data.RoomId, _msg)
+}
+
+// 艾特消息的发送者进行回复，msg的格式与 Reply 相同
+func (e *EventSendMessage) ReplyMention(msg ...string) (api_models.SendMessageModel, int, error) {
+	return e.ReplyMentionCtx(e.Context(), msg...)
+}
+
+func (e *EventSendMessage) ReplyMentionCtx(ctx context.Context, msg ...string) (api_models.SendMessageModel, int, error) {
+	nickname := e.Data.Nickname
+	if nickname == "" {
+		nickname = e.Data.Content.User.Name
+	}
+	_msg, _ := api_models.NewMsg(api_models.MsgTypeText)
+	_msg.SetText(api_models.MsgEntityMentionUser{Text: "@" + nickname, UserID: e.Data.FromUserId}, " ")
+	if err := e.api.MessageParserCtx(ctx, &_msg, e.Robot.VillaId, msg...); err != nil {
+		return api_models.SendMessageModel{}, apis.HttpStatusLocalError, err
+	}
+	return e.api.SendMessageCustomizeCtx(ctx, e.Robot.VillaId, e.Data.RoomId, _msg)
+}
+
+// 回复图片，url_or_path可为网络图片的链接（非米游社图片会先转存）或本地图片的路径（会先上传，并自动识别宽高及大小）
+func (e *EventSendMessage) ReplyImage(url_or_path string) (api_models.SendMessageModel, int, error) {
+	return e.ReplyImageCtx(e.Context(), url_or_path)
+}
+
+func (e *EventSendMessage) ReplyImageCtx(ctx context.Context, url_or_path string) (api_models.SendMessageModel, int, error) {
+	var msg *api_models.ImageMessage
+	if strings.HasPrefix(url_or_path, "http://") || strings.HasPrefix(url_or_path, "https://") {
+		msg = api_models.NewImageMessage(url_or_path)
+	} else {
+		msg = api_models.NewImageMessageFromFile(url_or_path)
+	}
+	return e.api.SendMessageCustomizeCtx(ctx, e.Robot.VillaId, e.Data.RoomId, msg)
+}
+
+// 回复米游社帖子
+func (e *EventSendMessage) ReplyPost(post_id string) (api_models.SendMessageModel, int, error) {
+	return e.ReplyPostCtx(e.Context(), post_id)
+}
+
+func (e *EventSendMessage) ReplyPostCtx(ctx context.Context, post_id string) (api_models.SendMessageModel, int, error) {
+	return e.api.SendMessageCustomizeCtx(ctx, e.Robot.VillaId, e.Data.RoomId, api_models.NewPostMessage(post_id))
+}
+
+// 撤回当前消息（机器人需要有相应的权限）
+func (e *EventSendMessage) Recall() (api_models.EmptyModel, int, error) {
+	return e.RecallCtx(e.Context())
+}
+
+func (e *EventSendMessage) RecallCtx(ctx context.Context) (api_models.EmptyModel, int, error) {
+	return e.api.RecallMessageCtx(ctx, e.Robot.VillaId, e.Data.MsgUid, e.Data.RoomId, int64(e.Data.SendAt))
+}
+
+// 置顶当前消息
+func (e *EventSendMessage) Pin() (api_models.EmptyModel, int, error) {
+	return e.PinCtx(e.Context())
+}
+
+func (e *EventSendMessage) PinCtx(ctx context.Context) (api_models.EmptyModel, int, error) {
+	return e.api.PinMessageCtx(ctx, e.Robot.VillaId, e.Data.MsgUid, false, e.Data.RoomId, int64(e.Data.SendAt))
+}
+
+// 取消置顶当前消息
+func (e *EventSendMessage) Unpin() (api_models.EmptyModel, int, error) {
+	return e.UnpinCtx(e.Context())
+}
+
+func (e *EventSendMessage) UnpinCtx(ctx context.Context) (api_models.EmptyModel, int, error) {
+	return e.api.PinMessageCtx(ctx, e.Robot.VillaId, e.Data.MsgUid, true, e.Data.RoomId, int64(e.Data.SendAt))
+}
@@ -0,0 +1,258 @@
+package eventsim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	api_models "github.com/GLGDLY/mhy_botsdk/api_models"
+	events "github.com/GLGDLY/mhy_botsdk/events"
+)
+
+/* 用于测试的回调事件模拟器
+ *
+ * 模拟器会生成一对RSA密钥，使用 PubKey() 作为 NewBot/NewWsBot 的 bot_pubkey 参数创建机器人后，
+ * 即可以与开放平台相同的方式（x-rpc-bot_sign）为任意事件签名，并注入到机器人的gin处理器或ws连接中，
+ * 从而在不关闭签名验证的情况下测试完整的事件处理流程 */
+
+type Simulator struct {
+	BotID       string
+	BotSecret   string
+	BotName     string
+	private_key *rsa.PrivateKey
+	pub_key     string
+	mu          sync.Mutex
+	next_id     uint64
+}
+
+// 创建模拟器，bot_id、bot_secret 需与被测试的机器人一致，bot_name 用于 @机器人 的文本
+func NewSimulator(bot_id, bot_secret, bot_name string) (*Simulator, error) {
+	private_key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&private_key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pub_key := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	return &Simulator{
+		BotID:       bot_id,
+		BotSecret:   bot_secret,
+		BotName:     bot_name,
+		private_key: private_key,
+		pub_key:     pub_key,
+	}, nil
+}
+
+// 获取PEM格式的公钥，用作创建机器人时的 bot_pubkey
+func (sim *Simulator) PubKey() string {
+	return sim.pub_key
+}
+
+// 以开放平台相同的方式为事件body签名，返回值即 x-rpc-bot_sign 请求头
+func (sim *Simulator) Sign(body []byte) string {
+	str := url.Values{
+		"body":   {strings.TrimSpace(string(body))},
+		"secret": {sim.BotSecret},
+	}.Encode()
+	hashed := sha256.Sum256([]byte(str))
+	sign, _ := rsa.SignPKCS1v15(rand.Reader, sim.private_key, crypto.SHA256, hashed[:])
+	return base64.StdEncoding.EncodeToString(sign)
+}
+
+func (sim *Simulator) newEventID() string {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.next_id++
+	return fmt.Sprintf("sim_%d_%d", time.Now().UnixNano(), sim.next_id)
+}
+
+/* event building */
+
+// 构建任意类型事件的json，data为事件的扩展数据（如events.JoinVillaData），会以 extend_data.EventData.<事件名> 的形式写入
+func (sim *Simulator) Build(villa_id uint64, event_type events.EventType, data interface{}) ([]byte, error) {
+	var event_name string
+	switch event_type {
+	case events.JoinVilla:
+		event_name = "JoinVilla"
+	case events.SendMessage:
+		event_name = "SendMessage"
+	case events.CreateRobot:
+		event_name = "CreateRobot"
+	case events.DeleteRobot:
+		event_name = "DeleteRobot"
+	case events.AddQuickEmoticon:
+		event_name = "AddQuickEmoticon"
+	case events.AuditCallback:
+		event_name = "AuditCallback"
+	case events.ClickMsgComponent:
+		event_name = "ClickMsgComponent"
+	default:
+		return nil, fmt.Errorf("unknown event type: %v", event_type)
+	}
+	now := time.Now()
+	event := map[string]interface{}{
+		"event": map[string]interface{}{
+			"robot": map[string]interface{}{
+				"template": map[string]interface{}{
+					"id":       sim.BotID,
+					"name":     sim.BotName,
+					"desc":     "",
+					"icon":     "",
+					"commands": []interface{}{},
+				},
+				"villa_id": villa_id,
+			},
+			"type":        event_type,
+			"extend_data": map[string]interface{}{"EventData": map[string]interface{}{event_name: data}},
+			"created_at":  now.Unix(),
+			"id":          sim.newEventID(),
+			"send_at":     now.Unix(),
+		},
+	}
+	return json.Marshal(event)
+}
+
+func (sim *Simulator) JoinVilla(data events.JoinVillaData) ([]byte, error) {
+	if data.JoinAt == 0 {
+		data.JoinAt = uint64(time.Now().Unix())
+	}
+	return sim.Build(data.VillaId, events.JoinVilla, data)
+}
+
+func (sim *Simulator) CreateRobot(villa_id uint64) ([]byte, error) {
+	return sim.Build(villa_id, events.CreateRobot, events.CreateRobotData{VillaId: villa_id})
+}
+
+func (sim *Simulator) DeleteRobot(villa_id uint64) ([]byte, error) {
+	return sim.Build(villa_id, events.DeleteRobot, events.DeleteRobotData{VillaId: villa_id})
+}
+
+func (sim *Simulator) AddQuickEmoticon(data events.AddQuickEmoticonData) ([]byte, error) {
+	return sim.Build(data.VillaId, events.AddQuickEmoticon, data)
+}
+
+func (sim *Simulator) AuditCallback(data events.AuditCallbackData) ([]byte, error) {
+	if data.BotTplId == "" {
+		data.BotTplId = sim.BotID
+	}
+	return sim.Build(data.VillaId, events.AuditCallback, data)
+}
+
+// 模拟用户点击消息组件（回传型按钮）
+func (sim *Simulator) ClickMsgComponent(data events.ClickMsgComponentData) ([]byte, error) {
+	return sim.Build(data.VillaId, events.ClickMsgComponent, data)
+}
+
+// 模拟用户发送的消息
+type Message struct {
+	VillaID    uint64
+	RoomID     uint64
+	FromUserID uint64
+	Nickname   string
+	// 消息内容，与 api_models.MsgInputModel 的 SetText 参数相同，
+	// 可混合使用 string 与 api_models.MsgEntityMentionRobot、MsgEntityMentionUser、MsgEntityVillaRoomLink 等实体
+	Parts  []interface{}
+	MsgUid string // 为空时自动生成
+}
+
+// 以机器人的名称生成 @机器人 的实体，用于 Message.Parts
+func (sim *Simulator) MentionBot() api_models.MsgEntityMentionRobot {
+	return api_models.MsgEntityMentionRobot{Text: "@" + sim.BotName, BotID: sim.BotID}
+}
+
+// 构建SendMessage事件，content会按开放平台的格式序列化为json字符串
+func (sim *Simulator) SendMessage(msg Message) ([]byte, error) {
+	text_msg, _ := api_models.NewMsg(api_models.MsgTypeText)
+	if err := text_msg.SetText(msg.Parts...); err != nil {
+		return nil, err
+	}
+	var content map[string]interface{}
+	if err := json.Unmarshal([]byte(text_msg.Finialize(msg.RoomID)["msg_content"].(string)), &content); err != nil {
+		return nil, err
+	}
+	if inner, ok := content["content"].(map[string]interface{}); ok {
+		inner["images"] = []interface{}{}
+	}
+	if _, ok := content["mentionedInfo"]; !ok {
+		content["mentionedInfo"] = map[string]interface{}{"mentionedContent": "", "userIdList": []string{}, "type": api_models.MentionUser}
+	}
+	content["user"] = map[string]interface{}{
+		"portraitUri": "",
+		"extra":       map[string]interface{}{"member_roles": []interface{}{}, "state": map[string]interface{}{}},
+		"name":        msg.Nickname,
+		"alias":       "",
+		"id":          fmt.Sprint(msg.FromUserID),
+		"portrait":    "",
+	}
+	content["trace"] = map[string]interface{}{
+		"visual_room_version": "1",
+		"app_version":         "",
+		"action_type":         0,
+		"bot_msg_id":          "",
+		"client":              "eventsim",
+		"env":                 "Test",
+		"rong_sdk_version":    "",
+	}
+	content_raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	if msg.MsgUid == "" {
+		msg.MsgUid = sim.newEventID()
+	}
+	return sim.Build(msg.VillaID, events.SendMessage, map[string]interface{}{
+		"content":      string(content_raw),
+		"from_user_id": msg.FromUserID,
+		"send_at":      time.Now().UnixMilli(),
+		"object_name":  1,
+		"room_id":      msg.RoomID,
+		"nickname":     msg.Nickname,
+		"msg_uid":      msg.MsgUid,
+		"bot_msg_id":   "",
+		"villa_id":     msg.VillaID,
+	})
+}
+
+/* event delivering */
+
+// 将签名后的事件以回调请求的形式直接交给handler（如 bot.Handler()）处理，path为机器人的回调路径
+func (sim *Simulator) ServeHTTP(handler http.Handler, path string, body []byte) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("x-rpc-bot_sign", sim.Sign(body))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// 将签名后的事件以回调请求的形式发送到正在监听的机器人（如 "http://127.0.0.1:8888/"）
+func (sim *Simulator) Post(raw_url string, body []byte) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodPost, raw_url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("x-rpc-bot_sign", sim.Sign(body))
+	return http.DefaultClient.Do(request)
+}
+
+// 以ws反向代理的格式为事件附加签名（签名作为最后一个字段 "sign"）
+func (sim *Simulator) SignForWS(body []byte) []byte {
+	trimmed := strings.TrimSpace(string(body))
+	return []byte(trimmed[:len(trimmed)-1] + ",\"sign\":\"" + sim.Sign(body) + "\"}")
+}
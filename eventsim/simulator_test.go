@@ -0,0 +1,174 @@
+package eventsim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	bot "github.com/GLGDLY/mhy_botsdk/bot"
+	events "github.com/GLGDLY/mhy_botsdk/events"
+	logger "github.com/GLGDLY/mhy_botsdk/logger"
+
+	"github.com/gorilla/websocket"
+)
+
+// logger writing to the test log instead of the console and log files
+type testLogger struct {
+	t testing.TB
+}
+
+func (l testLogger) Log(level logger.LoggerLevel, v ...interface{}) {
+	l.t.Log(append([]interface{}{level}, v...)...)
+}
+
+func (l testLogger) Logf(level logger.LoggerLevel, format string, v ...interface{}) {
+	l.t.Logf("%v "+format, append([]interface{}{level}, v...)...)
+}
+
+func (l testLogger) Debug(v ...interface{}) { l.Log(logger.LoggerLevelDebug, v...) }
+func (l testLogger) Info(v ...interface{})  { l.Log(logger.LoggerLevelInfo, v...) }
+func (l testLogger) Warn(v ...interface{})  { l.Log(logger.LoggerLevelWarn, v...) }
+func (l testLogger) Error(v ...interface{}) { l.Log(logger.LoggerLevelError, v...) }
+
+func (l testLogger) Debugf(format string, v ...interface{}) {
+	l.Logf(logger.LoggerLevelDebug, format, v...)
+}
+func (l testLogger) Infof(format string, v ...interface{}) {
+	l.Logf(logger.LoggerLevelInfo, format, v...)
+}
+func (l testLogger) Warnf(format string, v ...interface{}) {
+	l.Logf(logger.LoggerLevelWarn, format, v...)
+}
+func (l testLogger) Errorf(format string, v ...interface{}) {
+	l.Logf(logger.LoggerLevelError, format, v...)
+}
+
+// records the contents of the messages received by a bot
+type received struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (r *received) listener(e events.EventSendMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.texts = append(r.texts, e.GetContent(true))
+}
+
+func (r *received) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.texts...)
+}
+
+func newTestSimulator(t *testing.T, bot_id string) *Simulator {
+	sim, err := NewSimulator(bot_id, "secret", "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+func message(t *testing.T, sim *Simulator, parts ...interface{}) []byte {
+	body, err := sim.SendMessage(Message{VillaID: 1, RoomID: 2, FromUserID: 3, Nickname: "u", Parts: parts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func stopBot(t *testing.T, _bot *bot.Bot) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := _bot.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendMessageEvent(t *testing.T) {
+	sim := newTestSimulator(t, "bot_sim")
+	var event events.Event
+	if err := json.Unmarshal(message(t, sim, sim.MentionBot(), " hi"), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event.Type != events.SendMessage || event.Event.Robot.Template.Id != "bot_sim" || event.Event.Robot.VillaId != 1 {
+		t.Fatalf("event = %+v", event.Event.EventBase)
+	}
+	data := events.Event2EventSendMessage(event, nil)
+	if data.Data.RoomId != 2 || data.Data.FromUserId != 3 || data.GetContent(true) != "hi" {
+		t.Errorf("message = %+v, content %q", data.Data, data.GetContent(true))
+	}
+	if len(data.Data.Content.Content.Entities) != 1 {
+		t.Errorf("entities = %+v, want the mention of the bot", data.Data.Content.Content.Entities)
+	}
+}
+
+func TestInjectViaHttpHook(t *testing.T) {
+	sim := newTestSimulator(t, "bot_sim_http")
+	_bot := bot.NewRuntime().NewBot("bot_sim_http", "secret", sim.PubKey(), "/", ":0")
+	_bot.SetLogger(testLogger{t})
+	var r received
+	_bot.AddListenerSendMessage(r.listener)
+	handler := _bot.Handler()
+
+	if code := sim.ServeHTTP(handler, "/", message(t, sim, "signed")).Code; code != http.StatusOK {
+		t.Fatalf("callback returned %d", code)
+	}
+	// signed by another key pair, rejected by the signature verification
+	forger := newTestSimulator(t, "bot_sim_http")
+	if code := forger.ServeHTTP(handler, "/", message(t, forger, "forged")).Code; code != http.StatusOK {
+		t.Fatalf("callback of the forged event returned %d", code)
+	}
+	stopBot(t, _bot) // wait for the events in progress
+	if texts := r.get(); len(texts) != 1 || texts[0] != "signed" {
+		t.Errorf("received %q, want only [\"signed\"]", texts)
+	}
+}
+
+func TestInjectViaWSHook(t *testing.T) {
+	sim := newTestSimulator(t, "bot_sim_ws")
+	ws := sim.NewWSServer()
+	defer ws.Close()
+	_bot := bot.NewRuntime().NewWsBot("bot_sim_ws", "secret", sim.PubKey(), ws.URL)
+	_bot.SetLogger(testLogger{t})
+	var r received
+	_bot.AddListenerSendMessage(r.listener)
+	go _bot.Start()
+	if err := ws.WaitConnected(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ws.Send(message(t, sim, "signed")); err != nil {
+		t.Fatal(err)
+	}
+	forger := newTestSimulator(t, "bot_sim_ws")
+	forged := message(t, forger, "forged")
+	ws.mu.Lock()
+	err := ws.conns[0].WriteMessage(websocket.TextMessage, forger.SignForWS(forged))
+	ws.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500 && len(ws.Replies()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	replies := ws.Replies()
+	if len(replies) != 2 {
+		t.Fatalf("bot replied %d times, want 2", len(replies))
+	}
+	for _, reply := range replies {
+		var res struct {
+			Retcode int `json:"retcode"`
+		}
+		if err := json.Unmarshal(reply, &res); err != nil || res.Retcode != 0 {
+			t.Errorf("reply = %s", reply)
+		}
+	}
+	stopBot(t, _bot)
+	if texts := r.get(); len(texts) != 1 || texts[0] != "signed" {
+		t.Errorf("received %q, want only [\"signed\"]", texts)
+	}
+}
@@ -0,0 +1,103 @@
+package eventsim
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 模拟ws反向代理服务端，使用 URL 作为 NewWsBot 的 ws_uri，机器人连接后即可通过 Send 注入事件
+type WSServer struct {
+	*httptest.Server
+	URL       string // ws://... 格式的地址
+	sim       *Simulator
+	upgrader  websocket.Upgrader
+	mu        sync.Mutex
+	conns     []*websocket.Conn
+	connected chan struct{}
+	replies   [][]byte
+}
+
+func (sim *Simulator) NewWSServer() *WSServer {
+	ws := &WSServer{sim: sim, connected: make(chan struct{}, 1)}
+	ws.Server = httptest.NewServer(http.HandlerFunc(ws.serveHTTP))
+	ws.URL = "ws" + strings.TrimPrefix(ws.Server.URL, "http") + "/"
+	return ws
+}
+
+func (ws *WSServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	ws.mu.Lock()
+	ws.conns = append(ws.conns, conn)
+	ws.mu.Unlock()
+	select {
+	case ws.connected <- struct{}{}:
+	default:
+	}
+	for { // collect replies of the bot until the connection closes
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		ws.mu.Lock()
+		ws.replies = append(ws.replies, msg)
+		ws.mu.Unlock()
+	}
+}
+
+// 等待机器人连接，超时返回错误
+func (ws *WSServer) WaitConnected(timeout time.Duration) error {
+	ws.mu.Lock()
+	if len(ws.conns) > 0 {
+		ws.mu.Unlock()
+		return nil
+	}
+	ws.mu.Unlock()
+	select {
+	case <-ws.connected:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timeout")
+	}
+}
+
+// 将签名后的事件发送给所有已连接的机器人
+func (ws *WSServer) Send(body []byte) error {
+	signed := ws.sim.SignForWS(body)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if len(ws.conns) == 0 {
+		return errors.New("no bot connected")
+	}
+	for _, conn := range ws.conns {
+		if err := conn.WriteMessage(websocket.TextMessage, signed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 获取机器人对每个事件的回复（{"message":"","retcode":0}）
+func (ws *WSServer) Replies() [][]byte {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return append([][]byte{}, ws.replies...)
+}
+
+func (ws *WSServer) Close() {
+	ws.mu.Lock()
+	for _, conn := range ws.conns {
+		conn.Close()
+	}
+	ws.conns = nil
+	ws.mu.Unlock()
+	ws.Server.Close()
+}
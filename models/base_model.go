@@ -33,3 +33,13 @@ type WaitForCommandRegister struct {
 	Identify    *string        // 用于标识该注册的字符串，用于验证是否重复或取消注册
 	AllowRepeat *bool          // 是否允许重复触发（仅在Identify不为nil时生效），nil默认true
 }
+
+type WaitForComponentRegister struct {
+	Scope       Scope          // 作用域，根据Data判断点击组件的别野、房间、用户是否符合
+	ComponentID string         // 等待点击的组件id，为空时匹配任意组件
+	BotMsgID    string         // 仅匹配该条机器人消息（发送消息返回的bot_msg_id）中的组件，为空时不限制
+	Data        interface{}    // 用于判断作用域的事件，如触发发送该组件的 events.EventSendMessage
+	Timeout     *time.Duration // 超时时间，如果为0则不超时，nil默认60秒
+	Identify    *string        // 用于标识该注册的字符串，用于验证是否重复或取消注册
+	AllowRepeat *bool          // 是否允许重复触发（仅在Identify不为nil时生效），nil默认true
+}
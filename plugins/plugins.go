@@ -3,6 +3,7 @@ package plugins
 import (
 	"regexp"
 	"strings"
+	"sync"
 
 	commands "github.com/GLGDLY/mhy_botsdk/commands"
 	events "github.com/GLGDLY/mhy_botsdk/events"
@@ -42,7 +43,7 @@ func (p *OnCommand) processCommand(data events.EventSendMessage, _bot *AbstractB
 	}
 	if p.RequirePermission != nil && !p.RequirePermission(data, _bot) {
 		if p.PermissionErrorMsg != "" {
-			_, http, err := _bot.Api.SendMessage(data.Robot.VillaId, data.Data.RoomId, p.PermissionErrorMsg)
+			_, http, err := _bot.Api.SendMessageCtx(data.Context(), data.Robot.VillaId, data.Data.RoomId, p.PermissionErrorMsg)
 			if err != nil || http != 200 {
 				_bot.Logger.Error("command listener {", utils.GetFunctionName(p.Listener), "} error on sending permission error msg: ", err, "(", http, ")")
 			}
@@ -104,23 +105,59 @@ func (p *OnCommand) Equals(_p OnCommand) bool {
 }
 
 /* Context Managment for Plugins */
-var context_manager = make(map[string]*Plugin)
 
-// 注册插件到下一个Bot实例
-func RegisterPlugin(name string, context *Plugin) {
+// 插件注册表，每个 bot.Runtime 各自持有一个；RegisterPlugin 等全局函数使用默认注册表
+type Registry struct {
+	mu      sync.RWMutex
+	plugins map[string]*Plugin
+}
+
+func NewRegistry() *Registry {
+	return &Registry{plugins: make(map[string]*Plugin)}
+}
+
+var context_manager = NewRegistry()
+
+// 获取默认注册表，即默认 bot.Runtime 所使用的注册表
+func DefaultRegistry() *Registry {
+	return context_manager
+}
+
+// 注册插件到下一个使用该注册表的Bot实例
+func (r *Registry) Register(name string, context *Plugin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	context.IsEnable = true
-	context_manager[name] = context
+	r.plugins[name] = context
 }
 
-func FetchPlugins() map[string]*Plugin {
+// 获取所有插件的副本
+func (r *Registry) Fetch() map[string]*Plugin {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	context_manager_copy := make(map[string]*Plugin)
-	for k, v := range context_manager {
+	for k, v := range r.plugins {
 		_v := *v
 		context_manager_copy[k] = &_v
 	}
 	return context_manager_copy
 }
 
+func (r *Registry) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.plugins = make(map[string]*Plugin)
+}
+
+// 注册插件到下一个Bot实例
+func RegisterPlugin(name string, context *Plugin) {
+	context_manager.Register(name, context)
+}
+
+func FetchPlugins() map[string]*Plugin {
+	return context_manager.Fetch()
+}
+
 func ClearPlugins() {
-	context_manager = make(map[string]*Plugin)
+	context_manager.Clear()
 }